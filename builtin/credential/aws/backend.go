@@ -7,12 +7,11 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/endpoints"
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/hashicorp/golang-lru"
 	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
-	"github.com/patrickmn/go-cache"
 )
 
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
@@ -23,9 +22,17 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 	if err := b.Setup(ctx, conf); err != nil {
 		return nil, err
 	}
+	if err := b.resizeLookupCache(ctx, conf.StorageView); err != nil {
+		return nil, err
+	}
 	return b, nil
 }
 
+// Maximum number of EC2 and IAM client objects cached each. Clients are
+// cached per region, STS role and IAM endpoint override, so this only bounds
+// the memory used when many of these are in use.
+const maxCachedClients = 1000
+
 type backend struct {
 	*framework.Backend
 
@@ -35,9 +42,17 @@ type backend struct {
 	// Lock to make changes to role entries
 	roleMutex sync.RWMutex
 
+	// Upgrades of role entries in progress, keyed by role name, so that
+	// concurrent reads of a role needing an upgrade share a single upgrade
+	roleUpgradesMutex sync.Mutex
+	roleUpgrades      map[string]*roleUpgradeCall
+
 	// Lock to make changes to the blacklist entries
 	blacklistMutex sync.RWMutex
 
+	// Lock to check and record the signed requests of iam logins
+	replayCacheMutex sync.Mutex
+
 	// Guards the blacklist/whitelist tidy functions
 	tidyBlacklistCASGuard *uint32
 	tidyWhitelistCASGuard *uint32
@@ -51,22 +66,32 @@ type backend struct {
 	// of tidyCooldownPeriod.
 	nextTidyTime time.Time
 
-	// Map to hold the EC2 client objects indexed by region and STS role.
+	// Cache to hold the EC2 client objects indexed by region and STS role.
 	// This avoids the overhead of creating a client object for every login request.
 	// When the credentials are modified or deleted, all the cached client objects
-	// will be flushed. The empty STS role signifies the master account
-	EC2ClientsMap map[string]map[string]*ec2.EC2
-
-	// Map to hold the IAM client objects indexed by region and STS role.
-	// This avoids the overhead of creating a client object for every login request.
-	// When the credentials are modified or deleted, all the cached client objects
-	// will be flushed. The empty STS role signifies the master account
-	IAMClientsMap map[string]map[string]*iam.IAM
-
-	// Map of AWS unique IDs to the full ARN corresponding to that unique ID
-	// This avoids the overhead of an AWS API hit for every login request
-	// using the IAM auth method when bound_iam_principal_arn contains a wildcard
-	iamUserIdToArnCache *cache.Cache
+	// will be flushed. The empty STS role signifies the master account. At most
+	// maxCachedClients clients are kept, evicting the least recently used first.
+	EC2ClientsMap *lru.Cache
+
+	// Cache to hold the IAM client objects indexed by region, STS role and IAM
+	// endpoint override. This avoids the overhead of creating a client object
+	// for every login request. When the credentials are modified or deleted,
+	// all the cached client objects will be flushed. The empty STS role
+	// signifies the master account. At most maxCachedClients clients are kept,
+	// evicting the least recently used first.
+	IAMClientsMap *lru.Cache
+
+	// Cache of the results of AWS API lookups made during logins: the full
+	// ARNs of AWS unique IDs, used when bound_iam_principal_arn contains a
+	// wildcard, the management accounts of organizations and the permissions
+	// boundaries of IAM principals. This avoids the overhead of an AWS API hit
+	// for every login request. Its size is bounded by the max_cache_entries
+	// of the client configuration.
+	lookupCache *lookupCache
+
+	// Storage view of the backend, used to read the client configuration
+	// when it is invalidated
+	view logical.Storage
 
 	// AWS Account ID of the "default" AWS credentials
 	// This cache avoids the need to call GetCallerIdentity repeatedly to learn it
@@ -76,21 +101,85 @@ type backend struct {
 	defaultAWSAccountID string
 
 	resolveArnToUniqueIDFunc func(context.Context, logical.Storage, string) (string, error)
+
+	// describeInstanceExtendedAttributesFunc fetches the attributes of an EC2
+	// instance which are not modeled by the AWS SDK; it can be replaced for
+	// unit testing purposes
+	describeInstanceExtendedAttributesFunc func(context.Context, logical.Storage, string, string, string) (*instanceExtendedAttributes, error)
+
+	// principalTagsFunc fetches the tags of an IAM user or role; it can be
+	// replaced for unit testing purposes
+	principalTagsFunc func(context.Context, logical.Storage, *iamEntity) (map[string]string, error)
+
+	// describeOrganizationMasterAccountFunc fetches the management account of
+	// the organization of an AWS account; it can be replaced for unit testing
+	// purposes
+	describeOrganizationMasterAccountFunc func(context.Context, logical.Storage, string, string) (string, error)
+
+	// describeAccountOrganizationFunc fetches the ID of the organization of
+	// an AWS account; it can be replaced for unit testing purposes
+	describeAccountOrganizationFunc func(context.Context, logical.Storage, string, string) (string, error)
+
+	// clock returns the current time and is used by all the time based
+	// checks; it can be replaced for unit testing purposes
+	clock func() time.Time
+
+	// permissionsBoundaryFunc fetches the ARN of the permissions boundary
+	// attached to an IAM user or role; it can be replaced for unit testing
+	// purposes
+	permissionsBoundaryFunc func(context.Context, logical.Storage, *iamEntity) (string, error)
+
+	// imageOwnerFunc fetches the account ID of the owner of an AMI; it can be
+	// replaced for unit testing purposes
+	imageOwnerFunc func(context.Context, logical.Storage, string, string, string) (string, error)
+
+	// principalExistsFunc reports whether the IAM user or role underlying an
+	// entity still exists; it can be replaced for unit testing purposes
+	principalExistsFunc func(context.Context, logical.Storage, *iamEntity) (bool, error)
+
+	// rolePathFunc fetches the path of the IAM role underlying an entity; it
+	// can be replaced for unit testing purposes
+	rolePathFunc func(context.Context, logical.Storage, *iamEntity) (string, error)
+
+	// loginEventFunc publishes the event of a successful login when
+	// emit_login_events is set; it can be replaced for unit testing purposes
+	loginEventFunc func(context.Context, *loginEvent)
 }
 
 func Backend(conf *logical.BackendConfig) (*backend, error) {
+	ec2Clients, err := lru.New(maxCachedClients)
+	if err != nil {
+		return nil, err
+	}
+	iamClients, err := lru.New(maxCachedClients)
+	if err != nil {
+		return nil, err
+	}
+
 	b := &backend{
 		// Setting the periodic func to be run once in an hour.
 		// If there is a real need, this can be made configurable.
 		tidyCooldownPeriod:    time.Hour,
-		EC2ClientsMap:         make(map[string]map[string]*ec2.EC2),
-		IAMClientsMap:         make(map[string]map[string]*iam.IAM),
-		iamUserIdToArnCache:   cache.New(7*24*time.Hour, 24*time.Hour),
+		EC2ClientsMap:         ec2Clients,
+		IAMClientsMap:         iamClients,
+		lookupCache:           newLookupCache(defaultMaxCacheEntries),
+		view:                  conf.StorageView,
+		roleUpgrades:          make(map[string]*roleUpgradeCall),
 		tidyBlacklistCASGuard: new(uint32),
 		tidyWhitelistCASGuard: new(uint32),
 	}
 
+	b.clock = time.Now
 	b.resolveArnToUniqueIDFunc = b.resolveArnToRealUniqueId
+	b.describeInstanceExtendedAttributesFunc = b.describeInstanceExtendedAttributes
+	b.principalTagsFunc = b.principalTags
+	b.describeOrganizationMasterAccountFunc = b.describeOrganizationMasterAccount
+	b.describeAccountOrganizationFunc = b.describeAccountOrganization
+	b.permissionsBoundaryFunc = b.permissionsBoundary
+	b.imageOwnerFunc = b.imageOwner
+	b.principalExistsFunc = b.principalExists
+	b.rolePathFunc = b.rolePath
+	b.loginEventFunc = b.logLoginEvent
 
 	b.Backend = &framework.Backend{
 		PeriodicFunc: b.periodicFunc,
@@ -102,18 +191,28 @@ func Backend(conf *logical.BackendConfig) (*backend, error) {
 			},
 			LocalStorage: []string{
 				"whitelist/identity/",
+				replayCacheStoragePrefix,
+				accountOrganizationStoragePrefix,
 			},
 			SealWrapStorage: []string{
 				"config/client",
+				// Previous client configurations hold their secret keys
+				clientConfigHistoryPath,
 			},
 		},
 		Paths: []*framework.Path{
 			pathLogin(b),
+			pathLoginValidate(b),
 			pathListRole(b),
 			pathListRoles(b),
 			pathRole(b),
+			pathExportRoles(b),
+			pathImportRoles(b),
+			pathToolsRolesForAccount(b),
 			pathRoleTag(b),
 			pathConfigClient(b),
+			pathConfigClientHistory(b),
+			pathConfigClientRollback(b),
 			pathConfigCertificate(b),
 			pathConfigSts(b),
 			pathListSts(b),
@@ -145,7 +244,7 @@ func Backend(conf *logical.BackendConfig) (*backend, error) {
 func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
 	// Run the tidy operations for the first time. Then run it when current
 	// time matches the nextTidyTime.
-	if b.nextTidyTime.IsZero() || !time.Now().Before(b.nextTidyTime) {
+	if b.nextTidyTime.IsZero() || !b.clock().Before(b.nextTidyTime) {
 		if b.System().LocalMount() || !b.System().ReplicationState().HasState(consts.ReplicationPerformanceSecondary) {
 			// safety_buffer defaults to 180 days for roletag blacklist
 			safety_buffer := 15552000
@@ -165,7 +264,7 @@ func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error
 			}
 			// tidy role tags if explicitly not disabled
 			if !skipBlacklistTidy {
-				b.tidyBlacklistRoleTag(ctx, req, safety_buffer)
+				b.tidyBlacklistRoleTag(ctx, req, safety_buffer, false)
 			}
 		}
 
@@ -189,11 +288,17 @@ func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error
 		}
 		// tidy identities if explicitly not disabled
 		if !skipWhitelistTidy {
-			b.tidyWhitelistIdentity(ctx, req, safety_buffer)
+			b.tidyWhitelistIdentity(ctx, req, safety_buffer, false)
+		}
+
+		// Signed requests of iam logins are only remembered until they
+		// expire, which happens long before the next tidy
+		if err := b.tidyReplayCache(ctx, req.Storage); err != nil {
+			return err
 		}
 
 		// Update the time at which to run the tidy functions again.
-		b.nextTidyTime = time.Now().Add(b.tidyCooldownPeriod)
+		b.nextTidyTime = b.clock().Add(b.tidyCooldownPeriod)
 	}
 	return nil
 }
@@ -203,9 +308,13 @@ func (b *backend) invalidate(ctx context.Context, key string) {
 	case "config/client":
 		b.configMutex.Lock()
 		defer b.configMutex.Unlock()
-		b.flushCachedEC2Clients()
-		b.flushCachedIAMClients()
-		b.defaultAWSAccountID = ""
+		b.flushCachedClients()
+
+		// The configuration was written by another node, so keep the size of
+		// the lookup cache in line with it
+		if err := b.nonLockedResizeLookupCache(ctx, b.view); err != nil {
+			b.Logger().Error("failed to resize the lookup cache", "error", err)
+		}
 	}
 }
 
@@ -269,6 +378,18 @@ func (b *backend) resolveArnToRealUniqueId(ctx context.Context, s logical.Storag
 	}
 }
 
+// getPartitionForRegion returns the ID of the partition holding the region,
+// which is the commercial partition for unknown regions
+func getPartitionForRegion(region string) string {
+	resolver := endpoints.DefaultResolver()
+	partitions := resolver.(endpoints.EnumPartitions).Partitions()
+
+	if partition, ok := endpoints.PartitionForRegion(partitions, region); ok {
+		return partition.ID()
+	}
+	return endpoints.AwsPartitionID
+}
+
 // Adapted from https://docs.aws.amazon.com/sdk-for-go/api/aws/endpoints/
 // the "Enumerating Regions and Endpoint Metadata" section
 func getAnyRegionForAwsPartition(partitionId string) *endpoints.Region {
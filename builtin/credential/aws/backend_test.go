@@ -301,6 +301,75 @@ func TestBackend_ConfigTidyIdentities(t *testing.T) {
 	}
 }
 
+func TestBackend_TidyDryRun(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	entries := map[string]time.Time{
+		"expired": now.Add(-2 * time.Hour),
+		"current": now.Add(time.Hour),
+	}
+	for key, expiration := range entries {
+		entry, err := logical.StorageEntryJSON("whitelist/identity/"+key, &whitelistIdentity{ExpirationTime: expiration})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+		entry, err = logical.StorageEntryJSON("blacklist/roletag/"+key, &roleTagBlacklistEntry{ExpirationTime: expiration})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, prefix := range []string{"whitelist/identity/", "blacklist/roletag/"} {
+		path := "tidy/identity-whitelist"
+		if prefix == "blacklist/roletag/" {
+			path = "tidy/roletag-blacklist"
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data: map[string]interface{}{
+				"safety_buffer": 3600,
+				"dry_run":       true,
+			},
+			Storage: storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("%s: failed to run dry run: resp:%#v err:%v", path, resp, err)
+		}
+		wouldDelete := resp.Data["would_delete"].([]string)
+		if len(wouldDelete) != 1 || wouldDelete[0] != "expired" {
+			t.Fatalf("%s: bad: expected only the expired entry to be listed, got %#v", path, wouldDelete)
+		}
+
+		keys, err := storage.List(context.Background(), prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2 {
+			t.Fatalf("%s: bad: expected no entries to be deleted, got %#v", path, keys)
+		}
+	}
+}
+
 func TestBackend_ConfigTidyRoleTags(t *testing.T) {
 	config := logical.TestBackendConfig()
 	storage := &logical.InmemStorage{}
@@ -1167,13 +1236,13 @@ func TestBackendAcc_LoginWithInstanceIdentityDocAndWhitelistIdentity(t *testing.
 	roleReq.Operation = logical.UpdateOperation
 	// Place the correct AMI ID in one of the values, but make the AccountID wrong
 	data["bound_ami_id"] = []string{"wrong_ami_id_1", amiID, "wrong_ami_id_2"}
-	data["bound_account_id"] = []string{"wrong-account-id", "wrong-account-id-2"}
+	data["bound_account_id"] = []string{"000000000001", "000000000002"}
 	if err := updateRoleExpectLoginFail(roleReq, loginRequest); err != nil {
 		t.Fatal(err)
 	}
 
 	// Place the correct AccountID in one of the values, but make the wrong IAMRoleARN
-	data["bound_account_id"] = []string{"wrong-account-id-1", accountID, "wrong-account-id-2"}
+	data["bound_account_id"] = []string{"000000000001", accountID, "000000000002"}
 	data["bound_iam_role_arn"] = []string{"wrong_iam_role_arn", "wrong_iam_role_arn_2"}
 	if err := updateRoleExpectLoginFail(roleReq, loginRequest); err != nil {
 		t.Fatal(err)
@@ -1310,17 +1379,17 @@ func TestBackend_pathStsConfig(t *testing.T) {
 	stsReq := &logical.Request{
 		Operation: logical.CreateOperation,
 		Storage:   storage,
-		Path:      "config/sts/account1",
+		Path:      "config/sts/111111111111",
 	}
 	checkFound, exists, err := b.HandleExistenceCheck(context.Background(), stsReq)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if !checkFound {
-		t.Fatal("existence check not found for path 'config/sts/account1'")
+		t.Fatal("existence check not found for path 'config/sts/111111111111'")
 	}
 	if exists {
-		t.Fatal("existence check should have returned 'false' for 'config/sts/account1'")
+		t.Fatal("existence check should have returned 'false' for 'config/sts/111111111111'")
 	}
 
 	data := map[string]interface{}{
@@ -1341,10 +1410,10 @@ func TestBackend_pathStsConfig(t *testing.T) {
 		t.Fatal(err)
 	}
 	if !checkFound {
-		t.Fatal("existence check not found for path 'config/sts/account1'")
+		t.Fatal("existence check not found for path 'config/sts/111111111111'")
 	}
 	if !exists {
-		t.Fatal("existence check should have returned 'true' for 'config/sts/account1'")
+		t.Fatal("existence check should have returned 'true' for 'config/sts/111111111111'")
 	}
 
 	stsReq.Operation = logical.ReadOperation
@@ -1359,7 +1428,7 @@ func TestBackend_pathStsConfig(t *testing.T) {
 	}
 
 	stsReq.Operation = logical.CreateOperation
-	stsReq.Path = "config/sts/account2"
+	stsReq.Path = "config/sts/222222222222"
 	stsReq.Data = data
 	// create another entry to test the list operation
 	resp, err = b.HandleRequest(context.Background(), stsReq)
@@ -1383,13 +1452,13 @@ func TestBackend_pathStsConfig(t *testing.T) {
 	}
 
 	stsReq.Operation = logical.DeleteOperation
-	stsReq.Path = "config/sts/account1"
+	stsReq.Path = "config/sts/111111111111"
 	resp, err = b.HandleRequest(context.Background(), stsReq)
 	if err != nil || (resp != nil && resp.IsError()) {
 		t.Fatal(err)
 	}
 
-	stsReq.Path = "config/sts/account2"
+	stsReq.Path = "config/sts/222222222222"
 	resp, err = b.HandleRequest(context.Background(), stsReq)
 	if err != nil || (resp != nil && resp.IsError()) {
 		t.Fatal(err)
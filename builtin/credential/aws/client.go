@@ -2,11 +2,20 @@ package awsauth
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/jsonrpc"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/sts"
@@ -16,6 +25,24 @@ import (
 	"github.com/hashicorp/vault/logical"
 )
 
+type iamEndpointContextKey struct{}
+
+// withIAMEndpoint returns a context in which IAM clients are created for the
+// given endpoint, which takes precedence over the iam_endpoint of the client
+// configuration, such as the iam_endpoint of the role of a login
+func withIAMEndpoint(ctx context.Context, endpoint string) context.Context {
+	if endpoint == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, iamEndpointContextKey{}, endpoint)
+}
+
+// iamEndpointFromContext returns the IAM endpoint set with withIAMEndpoint
+func iamEndpointFromContext(ctx context.Context) string {
+	endpoint, _ := ctx.Value(iamEndpointContextKey{}).(string)
+	return endpoint
+}
+
 // getRawClientConfig creates a aws-sdk-go config, which is used to create client
 // that can interact with AWS API. This builds credentials in the following
 // order of preference:
@@ -45,12 +72,17 @@ func (b *backend) getRawClientConfig(ctx context.Context, s logical.Storage, reg
 			endpoint = aws.String(config.IAMEndpoint)
 		case clientType == "sts" && config.STSEndpoint != "":
 			endpoint = aws.String(config.STSEndpoint)
+		case clientType == "organizations" && config.OrganizationsEndpoint != "":
+			endpoint = aws.String(config.OrganizationsEndpoint)
 		}
 
 		credsConfig.AccessKey = config.AccessKey
 		credsConfig.SecretKey = config.SecretKey
 		maxRetries = config.MaxRetries
 	}
+	if clientType == "iam" && iamEndpointFromContext(ctx) != "" {
+		endpoint = aws.String(iamEndpointFromContext(ctx))
+	}
 
 	credsConfig.HTTPClient = cleanhttp.DefaultClient()
 
@@ -61,6 +93,14 @@ func (b *backend) getRawClientConfig(ctx context.Context, s logical.Storage, reg
 	if creds == nil {
 		return nil, fmt.Errorf("could not compile valid credential providers from static config, environment, shared, or instance metadata")
 	}
+	// Without a client configuration the credentials can only come from the
+	// default chain, whose failure would otherwise surface from the first API
+	// call with an error which does not point at the missing configuration
+	if config == nil {
+		if _, err := creds.Get(); err != nil {
+			return nil, errwrap.Wrap(errors.New(errBackendNotConfigured), err)
+		}
+	}
 
 	// Create a config that can be used to make the API calls.
 	return &aws.Config{
@@ -129,10 +169,7 @@ func (b *backend) getClientConfig(ctx context.Context, s logical.Storage, region
 // the cached EC2 client objects will be flushed. Config mutex lock should be
 // acquired for write operation before calling this method.
 func (b *backend) flushCachedEC2Clients() {
-	// deleting items in map during iteration is safe
-	for region, _ := range b.EC2ClientsMap {
-		delete(b.EC2ClientsMap, region)
-	}
+	b.EC2ClientsMap.Purge()
 }
 
 // flushCachedIAMClients deletes all the cached iam client objects from the
@@ -140,10 +177,18 @@ func (b *backend) flushCachedEC2Clients() {
 // the backend, all the cached IAM client objects will be flushed. Config mutex
 // lock should be acquired for write operation before calling this method.
 func (b *backend) flushCachedIAMClients() {
-	// deleting items in map during iteration is safe
-	for region, _ := range b.IAMClientsMap {
-		delete(b.IAMClientsMap, region)
-	}
+	b.IAMClientsMap.Purge()
+}
+
+// flushCachedClients deletes all the cached ec2 and iam client objects, and
+// the default AWS account ID derived from the client credentials, so that
+// the next login uses the current client configuration. Config mutex lock
+// should be acquired for write operation before calling this method, so that
+// no client is built from the previous configuration in the meantime.
+func (b *backend) flushCachedClients() {
+	b.flushCachedEC2Clients()
+	b.flushCachedIAMClients()
+	b.defaultAWSAccountID = ""
 }
 
 // Gets an entry out of the user ID cache
@@ -151,8 +196,9 @@ func (b *backend) getCachedUserId(userId string) string {
 	if userId == "" {
 		return ""
 	}
-	if entry, ok := b.iamUserIdToArnCache.Get(userId); ok {
-		b.iamUserIdToArnCache.SetDefault(userId, entry)
+	now := b.clock()
+	if entry, ok := b.lookupCache.get(userIdToArnCacheNamespace, userId, now); ok {
+		b.lookupCache.set(userIdToArnCacheNamespace, userId, entry, now.Add(userIdToArnCacheTTL))
 		return entry.(string)
 	}
 	return ""
@@ -161,7 +207,7 @@ func (b *backend) getCachedUserId(userId string) string {
 // Sets an entry in the user ID cache
 func (b *backend) setCachedUserId(userId, arn string) {
 	if userId != "" {
-		b.iamUserIdToArnCache.SetDefault(userId, arn)
+		b.lookupCache.set(userIdToArnCacheNamespace, userId, arn, b.clock().Add(userIdToArnCacheTTL))
 	}
 }
 
@@ -184,11 +230,13 @@ func (b *backend) clientEC2(ctx context.Context, s logical.Storage, region, acco
 	if err != nil {
 		return nil, err
 	}
+	cacheKey := region + "/" + stsRole
+
 	b.configMutex.RLock()
-	if b.EC2ClientsMap[region] != nil && b.EC2ClientsMap[region][stsRole] != nil {
+	if client, ok := b.EC2ClientsMap.Get(cacheKey); ok {
 		defer b.configMutex.RUnlock()
 		// If the client object was already created, return it
-		return b.EC2ClientsMap[region][stsRole], nil
+		return client.(*ec2.EC2), nil
 	}
 
 	// Release the read lock and acquire the write lock
@@ -197,8 +245,8 @@ func (b *backend) clientEC2(ctx context.Context, s logical.Storage, region, acco
 	defer b.configMutex.Unlock()
 
 	// If the client gets created while switching the locks, return it
-	if b.EC2ClientsMap[region] != nil && b.EC2ClientsMap[region][stsRole] != nil {
-		return b.EC2ClientsMap[region][stsRole], nil
+	if client, ok := b.EC2ClientsMap.Get(cacheKey); ok {
+		return client.(*ec2.EC2), nil
 	}
 
 	// Create an AWS config object using a chain of providers
@@ -218,13 +266,9 @@ func (b *backend) clientEC2(ctx context.Context, s logical.Storage, region, acco
 	if client == nil {
 		return nil, fmt.Errorf("could not obtain ec2 client")
 	}
-	if _, ok := b.EC2ClientsMap[region]; !ok {
-		b.EC2ClientsMap[region] = map[string]*ec2.EC2{stsRole: client}
-	} else {
-		b.EC2ClientsMap[region][stsRole] = client
-	}
+	b.EC2ClientsMap.Add(cacheKey, client)
 
-	return b.EC2ClientsMap[region][stsRole], nil
+	return client, nil
 }
 
 // clientIAM creates a client to interact with AWS IAM API
@@ -233,11 +277,19 @@ func (b *backend) clientIAM(ctx context.Context, s logical.Storage, region, acco
 	if err != nil {
 		return nil, err
 	}
+
+	// Clients created for an endpoint overriding the configured one are
+	// cached separately
+	cacheKey := region + "/" + stsRole
+	if endpoint := iamEndpointFromContext(ctx); endpoint != "" {
+		cacheKey = cacheKey + "@" + endpoint
+	}
+
 	b.configMutex.RLock()
-	if b.IAMClientsMap[region] != nil && b.IAMClientsMap[region][stsRole] != nil {
+	if client, ok := b.IAMClientsMap.Get(cacheKey); ok {
 		defer b.configMutex.RUnlock()
 		// If the client object was already created, return it
-		return b.IAMClientsMap[region][stsRole], nil
+		return client.(*iam.IAM), nil
 	}
 
 	// Release the read lock and acquire the write lock
@@ -246,8 +298,8 @@ func (b *backend) clientIAM(ctx context.Context, s logical.Storage, region, acco
 	defer b.configMutex.Unlock()
 
 	// If the client gets created while switching the locks, return it
-	if b.IAMClientsMap[region] != nil && b.IAMClientsMap[region][stsRole] != nil {
-		return b.IAMClientsMap[region][stsRole], nil
+	if client, ok := b.IAMClientsMap.Get(cacheKey); ok {
+		return client.(*iam.IAM), nil
 	}
 
 	// Create an AWS config object using a chain of providers
@@ -267,10 +319,243 @@ func (b *backend) clientIAM(ctx context.Context, s logical.Storage, region, acco
 	if client == nil {
 		return nil, fmt.Errorf("could not obtain iam client")
 	}
-	if _, ok := b.IAMClientsMap[region]; !ok {
-		b.IAMClientsMap[region] = map[string]*iam.IAM{stsRole: client}
-	} else {
-		b.IAMClientsMap[region][stsRole] = client
+	b.IAMClientsMap.Add(cacheKey, client)
+
+	return client, nil
+}
+
+// clientOrganizations creates a client to interact with the AWS Organizations
+// API. The vendored AWS SDK does not include an Organizations client, so a
+// generic JSON-RPC client is built instead. Organizations lookups are cached
+// by the caller, hence the client itself is not.
+func (b *backend) clientOrganizations(ctx context.Context, s logical.Storage, region, accountID string) (*client.Client, error) {
+	stsRole, err := b.stsRoleForAccount(ctx, s, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	b.configMutex.RLock()
+	awsConfig, err := b.getClientConfig(ctx, s, region, stsRole, accountID, "organizations")
+	b.configMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if awsConfig == nil {
+		return nil, fmt.Errorf("could not retrieve valid assumed credentials")
+	}
+
+	return newOrganizationsClient(awsConfig), nil
+}
+
+// clientOrganizationsOwnAccount creates a client to interact with the AWS
+// Organizations API with the credentials of Vault itself, rather than those
+// used for a given account. APIs such as DescribeAccount can only be called
+// from the management account of the organization or a delegated
+// administrator.
+func (b *backend) clientOrganizationsOwnAccount(ctx context.Context, s logical.Storage, region string) (*client.Client, error) {
+	b.configMutex.RLock()
+	awsConfig, err := b.getRawClientConfig(ctx, s, region, "organizations")
+	b.configMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if awsConfig == nil {
+		return nil, fmt.Errorf("could not retrieve valid credentials")
+	}
+
+	return newOrganizationsClient(awsConfig), nil
+}
+
+// newOrganizationsClient builds a JSON-RPC client of the AWS Organizations API
+func newOrganizationsClient(awsConfig *aws.Config) *client.Client {
+	clientConfig := session.New(awsConfig).ClientConfig(organizationsServiceName)
+	signingName := clientConfig.SigningName
+	if clientConfig.SigningNameDerived || signingName == "" {
+		signingName = organizationsServiceName
+	}
+	organizationsClient := client.New(
+		*clientConfig.Config,
+		metadata.ClientInfo{
+			ServiceName:   organizationsServiceName,
+			SigningName:   signingName,
+			SigningRegion: clientConfig.SigningRegion,
+			Endpoint:      clientConfig.Endpoint,
+			APIVersion:    "2016-11-28",
+			JSONVersion:   "1.1",
+			TargetPrefix:  "AWSOrganizationsV20161128",
+		},
+		clientConfig.Handlers,
+	)
+	organizationsClient.Handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	organizationsClient.Handlers.Build.PushBackNamed(jsonrpc.BuildHandler)
+	organizationsClient.Handlers.Unmarshal.PushBackNamed(jsonrpc.UnmarshalHandler)
+	organizationsClient.Handlers.UnmarshalMeta.PushBackNamed(jsonrpc.UnmarshalMetaHandler)
+	organizationsClient.Handlers.UnmarshalError.PushBackNamed(jsonrpc.UnmarshalErrorHandler)
+
+	return organizationsClient
+}
+
+// describeOrganizationMasterAccount returns the ID of the management (master)
+// account of the organization which the given account belongs to
+func (b *backend) describeOrganizationMasterAccount(ctx context.Context, s logical.Storage, region, accountID string) (string, error) {
+	organizationsClient, err := b.clientOrganizations(ctx, s, region, accountID)
+	if err != nil {
+		return "", err
+	}
+
+	output := &describeOrganizationOutput{}
+	req := organizationsClient.NewRequest(&request.Operation{
+		Name:       "DescribeOrganization",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}, &describeOrganizationInput{}, output)
+	if err := req.Send(); err != nil {
+		return "", errwrap.Wrapf(fmt.Sprintf("error describing the organization of account %q: {{err}}", accountID), err)
+	}
+	if output.Organization == nil || output.Organization.MasterAccountId == nil {
+		return "", fmt.Errorf("no master account in the organization description of account %q", accountID)
+	}
+	return *output.Organization.MasterAccountId, nil
+}
+
+// organizationManagementAccount returns the ID of the management account of
+// the organization which the given account belongs to, consulting the cache
+// first and populating it after a successful lookup
+func (b *backend) organizationManagementAccount(ctx context.Context, s logical.Storage, region, accountID string) (string, error) {
+	if entry, ok := b.lookupCache.get(managementAccountCacheNamespace, accountID, b.clock()); ok {
+		return entry.(string), nil
 	}
-	return b.IAMClientsMap[region][stsRole], nil
+	managementAccountID, err := b.describeOrganizationMasterAccountFunc(ctx, s, region, accountID)
+	if err != nil {
+		return "", err
+	}
+	b.lookupCache.set(managementAccountCacheNamespace, accountID, managementAccountID, b.clock().Add(managementAccountCacheTTL))
+	return managementAccountID, nil
+}
+
+// describeAccountOrganization returns the ID of the organization which the
+// given account belongs to, as found in the ARN of the account returned by
+// the Organizations DescribeAccount API
+func (b *backend) describeAccountOrganization(ctx context.Context, s logical.Storage, region, accountID string) (string, error) {
+	organizationsClient, err := b.clientOrganizationsOwnAccount(ctx, s, region)
+	if err != nil {
+		return "", err
+	}
+
+	output := &describeAccountOutput{}
+	req := organizationsClient.NewRequest(&request.Operation{
+		Name:       "DescribeAccount",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}, &describeAccountInput{AccountId: aws.String(accountID)}, output)
+	if err := req.Send(); err != nil {
+		return "", errwrap.Wrapf(fmt.Sprintf("error describing account %q: {{err}}", accountID), err)
+	}
+	if output.Account == nil || output.Account.Arn == nil {
+		return "", fmt.Errorf("no ARN in the description of account %q", accountID)
+	}
+	// The ARN of an account looks like
+	// arn:aws:organizations::111111111111:account/o-exampleorgid/123456789012
+	arnParts := strings.SplitN(*output.Account.Arn, ":", 6)
+	if len(arnParts) != 6 {
+		return "", fmt.Errorf("unrecognized ARN %q of account %q", *output.Account.Arn, accountID)
+	}
+	resourceParts := strings.Split(arnParts[5], "/")
+	if len(resourceParts) != 3 || resourceParts[0] != "account" || resourceParts[2] != accountID || !organizationIDRegex.MatchString(resourceParts[1]) {
+		return "", fmt.Errorf("unrecognized ARN %q of account %q", *output.Account.Arn, accountID)
+	}
+	return resourceParts[1], nil
+}
+
+// accountOrganization returns the ID of the organization which the given
+// account belongs to. Lookups are cached in storage for the
+// organization_cache_ttl of the client configuration, so that they are shared
+// across restarts and do not hit the Organizations API on every login.
+func (b *backend) accountOrganization(ctx context.Context, s logical.Storage, region, accountID string, cacheTTL time.Duration) (string, error) {
+	now := b.clock()
+	entry, err := s.Get(ctx, accountOrganizationStoragePrefix+accountID)
+	if err != nil {
+		return "", err
+	}
+	if entry != nil {
+		var cached accountOrganizationEntry
+		if err := entry.DecodeJSON(&cached); err != nil {
+			return "", err
+		}
+		if now.Before(cached.ExpirationTime) {
+			return cached.OrganizationID, nil
+		}
+	}
+
+	organizationID, err := b.describeAccountOrganizationFunc(ctx, s, region, accountID)
+	if err != nil {
+		return "", err
+	}
+	entry, err = logical.StorageEntryJSON(accountOrganizationStoragePrefix+accountID, &accountOrganizationEntry{
+		OrganizationID: organizationID,
+		ExpirationTime: now.Add(cacheTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return "", err
+	}
+	return organizationID, nil
+}
+
+// Storage prefix of the cached organizations of accounts
+const accountOrganizationStoragePrefix = "organization/account/"
+
+// accountOrganizationEntry caches the organization an account belongs to
+type accountOrganizationEntry struct {
+	OrganizationID string    `json:"organization_id"`
+	ExpirationTime time.Time `json:"expiration_time"`
+}
+
+// IDs of AWS organizations
+var organizationIDRegex = regexp.MustCompile(`^o-[a-z0-9]{10,32}$`)
+
+const organizationsServiceName = "organizations"
+
+// describeOrganizationInput is the input of the Organizations
+// DescribeOrganization API, which takes no parameters
+type describeOrganizationInput struct {
+	_ struct{} `type:"structure"`
+}
+
+// describeOrganizationOutput is the output of the Organizations
+// DescribeOrganization API
+type describeOrganizationOutput struct {
+	_ struct{} `type:"structure"`
+
+	Organization *organizationDescription `type:"structure"`
+}
+
+// describeAccountInput is the input of the Organizations DescribeAccount API
+type describeAccountInput struct {
+	_ struct{} `type:"structure"`
+
+	AccountId *string `type:"string"`
+}
+
+// describeAccountOutput is the output of the Organizations DescribeAccount API
+type describeAccountOutput struct {
+	_ struct{} `type:"structure"`
+
+	Account *organizationAccount `type:"structure"`
+}
+
+type organizationAccount struct {
+	_ struct{} `type:"structure"`
+
+	Id  *string `type:"string"`
+	Arn *string `type:"string"`
+}
+
+type organizationDescription struct {
+	_ struct{} `type:"structure"`
+
+	Id              *string `type:"string"`
+	MasterAccountId *string `type:"string"`
 }
@@ -0,0 +1,114 @@
+package awsauth
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// loginFailureReason classifies why a login was rejected. The values end the
+// error of the response and are logged, so they must stay stable: operators
+// alert and build dashboards on them.
+type loginFailureReason string
+
+const (
+	// The login request, or the signed request it carries, is malformed
+	loginReasonInvalidRequest loginFailureReason = "invalid_request"
+	// The signed request does not carry the server ID header
+	loginReasonMissingServerIDHeader loginFailureReason = "missing_server_id_header"
+	// The server ID header of the signed request has an unexpected value
+	loginReasonInvalidServerIDHeader loginFailureReason = "invalid_server_id_header"
+	// A header which has to be covered by the signature is not
+	loginReasonUnsignedHeader loginFailureReason = "unsigned_header"
+	// The request would be sent to an STS endpoint which is not allowed
+	loginReasonSTSEndpointNotAllowed loginFailureReason = "sts_endpoint_not_allowed"
+	// The X-Amz-Date of the signed request is too old or in the future
+	loginReasonStaleRequest loginFailureReason = "stale_request"
+	// STS could not be reached or rejected the signed request
+	loginReasonSTSError loginFailureReason = "sts_error"
+	// STS answered with a response which cannot be parsed, or which
+	// contradicts itself
+	loginReasonInvalidSTSResponse loginFailureReason = "invalid_sts_response"
+	// The signed request was already used to login
+	loginReasonReplayedRequest loginFailureReason = "replayed_request"
+	// The ARN of the caller returned by STS cannot be parsed
+	loginReasonInvalidCallerARN loginFailureReason = "invalid_caller_arn"
+	// The role does not exist
+	loginReasonRoleNotFound loginFailureReason = "role_not_found"
+	// The role exists but cannot be logged in to with this request
+	loginReasonRoleNotAllowed loginFailureReason = "role_not_allowed"
+	// The ARN of the caller matches none of the bound ARNs of the role
+	loginReasonARNMismatch loginFailureReason = "arn_mismatch"
+	// The caller does not satisfy another constraint of the role on the
+	// principal
+	loginReasonPrincipalNotAllowed loginFailureReason = "principal_not_allowed"
+	// The account of the caller does not satisfy the constraints of the role
+	loginReasonAccountMismatch loginFailureReason = "account_mismatch"
+	// The caller cannot be inferred as an EC2 instance satisfying the role
+	loginReasonInstanceMismatch loginFailureReason = "instance_mismatch"
+	// A lookup required by the role failed
+	loginReasonLookupFailed loginFailureReason = "lookup_failed"
+	// The client configuration was never written
+	loginReasonNotConfigured loginFailureReason = "not_configured"
+	// Policies of the role do not exist and unknown_policy_action rejects
+	// the login
+	loginReasonUnknownPolicies loginFailureReason = "unknown_policies"
+	// The rejection was not classified, as for ec2 logins
+	loginReasonUnclassified loginFailureReason = "unclassified"
+)
+
+// loginFailure is an error which knows the reason the login it fails is
+// rejected for, which is more precise than the one of the check it fails
+type loginFailure struct {
+	reason loginFailureReason
+	err    error
+}
+
+func (f *loginFailure) Error() string {
+	return f.err.Error()
+}
+
+// failLogin wraps err with the reason of the login rejection it causes
+func failLogin(reason loginFailureReason, err error) error {
+	return &loginFailure{
+		reason: reason,
+		err:    err,
+	}
+}
+
+// failureReason returns the reason carried by err, or fallback if there is
+// none
+func failureReason(err error, fallback loginFailureReason) loginFailureReason {
+	if f, ok := err.(*loginFailure); ok {
+		return f.reason
+	}
+	return fallback
+}
+
+// loginFailureReasonRegex matches the reason ending the error of a rejected
+// login
+var loginFailureReasonRegex = regexp.MustCompile(` \(reason: ([a-z_]+)\)$`)
+
+// loginRejected returns the error response of a login rejected for the given
+// reason. The reason is part of the error, as error responses carry nothing
+// else up to the client. The message must never quote the signature or the
+// credentials of the signed request.
+func loginRejected(reason loginFailureReason, message string) *logical.Response {
+	return logical.ErrorResponse(loginRejectionError(reason, message))
+}
+
+// loginRejectionError returns the error of a login rejected for the given
+// reason
+func loginRejectionError(reason loginFailureReason, message string) string {
+	return fmt.Sprintf("%s (reason: %s)", message, reason)
+}
+
+// loginResponseReason returns the reason of a rejected login response
+func loginResponseReason(resp *logical.Response) loginFailureReason {
+	errorMessage, _ := resp.Data["error"].(string)
+	if matches := loginFailureReasonRegex.FindStringSubmatch(errorMessage); matches != nil {
+		return loginFailureReason(matches[1])
+	}
+	return loginReasonUnclassified
+}
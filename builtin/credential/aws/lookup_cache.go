@@ -0,0 +1,120 @@
+package awsauth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+// Default maximum number of entries held by the lookup cache, used when the
+// client configuration does not set max_cache_entries
+const defaultMaxCacheEntries = 10000
+
+// Namespaces of the lookup cache, and how long their entries are kept
+const (
+	userIdToArnCacheNamespace         = "user-id-arn"
+	userIdToArnCacheTTL               = 7 * 24 * time.Hour
+	managementAccountCacheNamespace   = "management-account"
+	managementAccountCacheTTL         = time.Hour
+	permissionsBoundaryCacheNamespace = "permissions-boundary"
+	permissionsBoundaryCacheTTL       = 10 * time.Minute
+	activePrincipalCacheNamespace     = "active-principal"
+	activePrincipalCacheTTL           = time.Minute
+	rolePathCacheNamespace            = "role-path"
+	rolePathCacheTTL                  = 10 * time.Minute
+	uniqueIDCacheNamespace            = "unique-id"
+	uniqueIDCacheTTL                  = time.Hour
+)
+
+// lookupCache is a cache of the results of AWS API lookups which is shared by
+// all the kinds of lookups, each using its own namespace. The total number of
+// entries is bounded, and the least recently used entries are evicted first.
+// Entries also expire individually.
+type lookupCache struct {
+	l    sync.Mutex
+	size int
+	lru  *simplelru.LRU
+}
+
+type lookupCacheEntry struct {
+	value      interface{}
+	expiration time.Time
+}
+
+func newLookupCache(size int) *lookupCache {
+	if size <= 0 {
+		size = defaultMaxCacheEntries
+	}
+	lru, _ := simplelru.NewLRU(size, nil)
+	return &lookupCache{
+		size: size,
+		lru:  lru,
+	}
+}
+
+// get returns the unexpired value cached for the key, marking it as recently
+// used
+func (c *lookupCache) get(namespace, key string, now time.Time) (interface{}, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	cacheKey := namespace + "/" + key
+	raw, ok := c.lru.Get(cacheKey)
+	if !ok {
+		return nil, false
+	}
+	entry := raw.(*lookupCacheEntry)
+	if !now.Before(entry.expiration) {
+		c.lru.Remove(cacheKey)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// set caches the value for the key until the given expiration, evicting the
+// least recently used entry if the cache is full
+func (c *lookupCache) set(namespace, key string, value interface{}, expiration time.Time) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.lru.Add(namespace+"/"+key, &lookupCacheEntry{
+		value:      value,
+		expiration: expiration,
+	})
+}
+
+// resize changes the maximum number of entries, evicting the least recently
+// used entries if the cache shrinks. A size of 0 restores the default.
+func (c *lookupCache) resize(size int) {
+	if size <= 0 {
+		size = defaultMaxCacheEntries
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if size == c.size {
+		return
+	}
+
+	// Keys are returned from the oldest to the newest, so re-adding them in
+	// order keeps the most recently used entries
+	lru, _ := simplelru.NewLRU(size, nil)
+	for _, key := range c.lru.Keys() {
+		if value, ok := c.lru.Peek(key); ok {
+			lru.Add(key, value)
+		}
+	}
+	c.size = size
+	c.lru = lru
+}
+
+// len returns the number of entries in the cache, including expired entries
+// which have not been evicted yet
+func (c *lookupCache) len() int {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return c.lru.Len()
+}
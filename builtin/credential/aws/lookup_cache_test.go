@@ -0,0 +1,152 @@
+package awsauth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestLookupCache(t *testing.T) {
+	now := time.Now()
+	expiration := now.Add(time.Hour)
+
+	c := newLookupCache(2)
+	c.set(userIdToArnCacheNamespace, "a", "arn-a", expiration)
+	c.set(managementAccountCacheNamespace, "b", "account-b", expiration)
+
+	// Using "a" makes "b" the least recently used entry
+	if value, ok := c.get(userIdToArnCacheNamespace, "a", now); !ok || value != "arn-a" {
+		t.Fatalf("bad: expected cached value for a, got %v", value)
+	}
+	c.set(permissionsBoundaryCacheNamespace, "c", "boundary-c", expiration)
+	if c.len() != 2 {
+		t.Fatalf("bad: expected 2 entries, got %d", c.len())
+	}
+	if _, ok := c.get(managementAccountCacheNamespace, "b", now); ok {
+		t.Fatal("expected the least recently used entry to be evicted")
+	}
+	if _, ok := c.get(userIdToArnCacheNamespace, "a", now); !ok {
+		t.Fatal("expected a recently used entry to be kept")
+	}
+
+	// Namespaces do not share keys
+	if _, ok := c.get(managementAccountCacheNamespace, "a", now); ok {
+		t.Fatal("expected a key to be scoped to its namespace")
+	}
+
+	// Shrinking the cache keeps the most recently used entries
+	c.resize(1)
+	if c.len() != 1 {
+		t.Fatalf("bad: expected 1 entry, got %d", c.len())
+	}
+	if _, ok := c.get(userIdToArnCacheNamespace, "a", now); !ok {
+		t.Fatal("expected the most recently used entry to be kept")
+	}
+
+	// Expired entries are not returned
+	if _, ok := c.get(userIdToArnCacheNamespace, "a", expiration); ok {
+		t.Fatal("expected an expired entry not to be returned")
+	}
+}
+
+func TestBackend_pathConfigClient_maxCacheEntries(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/client",
+		Data: map[string]interface{}{
+			"max_cache_entries": 3,
+		},
+		Storage: storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to configure client: resp:%#v err:%v", resp, err)
+	}
+
+	expiration := b.clock().Add(time.Hour)
+	for _, userID := range []string{"AIDA1", "AIDA2", "AIDA3", "AIDA4", "AIDA5"} {
+		b.lookupCache.set(userIdToArnCacheNamespace, userID, "arn:aws:iam::123456789012:user/"+userID, expiration)
+	}
+	if b.lookupCache.len() != 3 {
+		t.Fatalf("bad: expected the cache to be bounded to 3 entries, got %d", b.lookupCache.len())
+	}
+	if b.getCachedUserId("AIDA1") != "" {
+		t.Fatal("expected the least recently used entry to be evicted")
+	}
+	if b.getCachedUserId("AIDA5") == "" {
+		t.Fatal("expected the most recently used entry to be kept")
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/client",
+		Data: map[string]interface{}{
+			"max_cache_entries": -1,
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatal("expected a negative max_cache_entries to be rejected")
+	}
+}
+
+func TestBackend_lookupCache_resizedOnInvalidate(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiration := b.clock().Add(time.Hour)
+	for _, userID := range []string{"AIDA1", "AIDA2", "AIDA3", "AIDA4", "AIDA5"} {
+		b.lookupCache.set(userIdToArnCacheNamespace, userID, "arn:aws:iam::123456789012:user/"+userID, expiration)
+	}
+
+	// A configuration written by another node is not applied when it is
+	// merely read
+	entry, err := logical.StorageEntryJSON("config/client", &clientConfig{
+		MaxCacheEntries: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.lockedClientConfigEntry(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+	if b.lookupCache.len() != 5 {
+		t.Fatalf("bad: expected reading the configuration to leave the cache alone, got %d entries", b.lookupCache.len())
+	}
+
+	// It is applied when the configuration is invalidated
+	b.invalidate(context.Background(), "config/client")
+	if b.lookupCache.len() != 3 {
+		t.Fatalf("bad: expected the cache to be bounded to 3 entries, got %d", b.lookupCache.len())
+	}
+}
@@ -1,14 +1,18 @@
 package awsauth
 
 import (
+	"bytes"
 	"context"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"math/big"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -19,6 +23,14 @@ type dsaSignature struct {
 	R, S *big.Int
 }
 
+// errNoRegisteredCertificates is returned when the signature of an instance
+// identity document could not be verified and no certificate of the needed
+// type is registered, so only the default AWS public certificate of the
+// partition of the instance, if any, was tried. This is usually the case when
+// the certificate of the region of the instance was never registered, or has
+// been deleted.
+var errNoRegisteredCertificates = errors.New("signature could not be verified using the default AWS public certificate of the partition and no AWS public certificates of the needed type are registered; instances in regions using their own certificate require it to be registered using the 'config/certificate/<cert_name>' endpoint")
+
 // This certificate is used to verify the PKCS#7 signature of the instance
 // identity document. As per AWS documentation, this public key is valid for
 // US East (N. Virginia), US West (Oregon), US West (N. California), EU
@@ -69,6 +81,27 @@ C1haGgSI/A1uZUKs/Zfnph0oEI0/hu1IIJ/SKBDtN5lvmZ/IzbOPIJWirlsllQIQ
 7zvWbGd9c9+Rm3p04oTvhup99la7kZqevJK0QRdD/6NpCKsqP/0=
 -----END CERTIFICATE-----`
 
+// awsDefaultCertificates holds the AWS public certificates of a partition
+// which are used when verifying instance identity documents, in addition to
+// the registered certificates
+type awsDefaultCertificates struct {
+	identity string
+	pkcs7    string
+}
+
+// defaultAWSPublicCertificates maps AWS partitions to their default
+// certificates. Documents of instances in partitions which are not listed, or
+// which have no default of the needed type, can only be verified using the
+// certificates registered with the 'config/certificate/<cert_name>' endpoint.
+// This is the case for GovCloud (US) and China instances, whose certificates
+// differ from the commercial ones and must be registered.
+var defaultAWSPublicCertificates = map[string]awsDefaultCertificates{
+	endpoints.AwsPartitionID: {
+		identity: genericAWSPublicCertificateIdentity,
+		pkcs7:    genericAWSPublicCertificatePkcs7,
+	},
+}
+
 // pathListCertificates creates a path that enables listing of all
 // the AWS public certificates registered with Vault.
 func pathListCertificates(b *backend) *framework.Path {
@@ -94,7 +127,7 @@ func pathConfigCertificate(b *backend) *framework.Path {
 			},
 			"aws_public_cert": {
 				Type:        framework.TypeString,
-				Description: "Base64 encoded AWS Public cert required to verify PKCS7 signature of the EC2 instance metadata.",
+				Description: "Base64 encoded AWS Public cert required to verify PKCS7 signature of the EC2 instance metadata. May hold a chain of PEM encoded certificates.",
 			},
 			"type": {
 				Type:    framework.TypeString,
@@ -153,6 +186,9 @@ func (b *backend) pathCertificatesList(ctx context.Context, req *logical.Request
 func decodePEMAndParseCertificate(certificate string) (*x509.Certificate, error) {
 	// Decode the PEM block and error out if a block is not detected in the first attempt
 	decodedPublicCert, rest := pem.Decode([]byte(certificate))
+	if decodedPublicCert == nil {
+		return nil, fmt.Errorf("invalid certificate; failed to decode PEM block")
+	}
 	if len(rest) != 0 {
 		return nil, fmt.Errorf("invalid certificate; should be one PEM block only")
 	}
@@ -168,12 +204,65 @@ func decodePEMAndParseCertificate(certificate string) (*x509.Certificate, error)
 	return publicCert, nil
 }
 
+// decodePEMAndParseCertificates decodes a PEM encoded certificate or chain of
+// certificates and parses each of them into a x509 cert. Anything other than
+// certificate blocks, apart from surrounding whitespace, is rejected.
+func decodePEMAndParseCertificates(certificates string) ([]*x509.Certificate, error) {
+	var publicCerts []*x509.Certificate
+	rest := []byte(strings.TrimSpace(certificates))
+	for len(rest) > 0 {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("invalid certificate; failed to decode PEM block %d", len(publicCerts)+1)
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("invalid certificate; PEM block %d is of type %q, expected \"CERTIFICATE\"", len(publicCerts)+1, block.Type)
+		}
+		publicCert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("invalid certificate; failed to parse PEM block %d: {{err}}", len(publicCerts)+1), err)
+		}
+		publicCerts = append(publicCerts, publicCert)
+		rest = bytes.TrimSpace(rest)
+	}
+	if len(publicCerts) == 0 {
+		return nil, fmt.Errorf("invalid certificate; no PEM block found")
+	}
+	return publicCerts, nil
+}
+
+// checkIdentityCertificateAlgorithm ensures that a certificate can verify the
+// SHA256 RSA signature of identity documents. Any RSA key size is accepted, as
+// AWS signs documents with both 2048 and 4096 bit keys depending on the region.
+func checkIdentityCertificateAlgorithm(publicCert *x509.Certificate) error {
+	if publicCert.PublicKeyAlgorithm != x509.RSA {
+		return fmt.Errorf("unsupported public key algorithm %s; identity certificates must hold an RSA public key", publicCert.PublicKeyAlgorithm)
+	}
+	return nil
+}
+
+// encodeCertificatesPEM returns the canonical PEM encoding of the given
+// certificates, one block after the other
+func encodeCertificatesPEM(publicCerts []*x509.Certificate) string {
+	var buf bytes.Buffer
+	for _, publicCert := range publicCerts {
+		buf.Write(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: publicCert.Raw,
+		}))
+	}
+	return buf.String()
+}
+
 // awsPublicCertificates returns a slice of all the parsed AWS public
 // certificates, which are used to verify either the SHA256 RSA signature, or
 // the PKCS7 signatures of the instance identity documents. This method will
 // append the certificates registered using `config/certificate/<cert_name>`
-// endpoint, along with the default certificate in the backend.
-func (b *backend) awsPublicCertificates(ctx context.Context, s logical.Storage, isPkcs bool) ([]*x509.Certificate, error) {
+// endpoint, along with the default certificate of the partition of the given
+// region. It also returns whether any certificate of the needed type is
+// registered.
+func (b *backend) awsPublicCertificates(ctx context.Context, s logical.Storage, isPkcs bool, region string) ([]*x509.Certificate, bool, error) {
 	// Lock at beginning and use internal method so that we are consistent as
 	// we iterate through
 	b.configMutex.RLock()
@@ -181,45 +270,52 @@ func (b *backend) awsPublicCertificates(ctx context.Context, s logical.Storage,
 
 	var certs []*x509.Certificate
 
-	defaultCert := genericAWSPublicCertificateIdentity
+	defaults := defaultAWSPublicCertificates[getPartitionForRegion(region)]
+	defaultCert := defaults.identity
 	if isPkcs {
-		defaultCert = genericAWSPublicCertificatePkcs7
+		defaultCert = defaults.pkcs7
 	}
 
-	// Append the generic certificate provided in the AWS EC2 instance metadata documentation
-	decodedCert, err := decodePEMAndParseCertificate(defaultCert)
-	if err != nil {
-		return nil, err
+	// Append the default certificate of the partition provided in the AWS EC2
+	// instance metadata documentation
+	if defaultCert != "" {
+		decodedCert, err := decodePEMAndParseCertificate(defaultCert)
+		if err != nil {
+			return nil, false, err
+		}
+		certs = append(certs, decodedCert)
 	}
-	certs = append(certs, decodedCert)
 
 	// Get the list of all the registered certificates
 	registeredCerts, err := s.List(ctx, "config/certificate/")
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
+	registered := false
+
 	// Iterate through each certificate, parse and append it to a slice
 	for _, cert := range registeredCerts {
 		certEntry, err := b.nonLockedAWSPublicCertificateEntry(ctx, s, cert)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 		if certEntry == nil {
-			return nil, fmt.Errorf("certificate storage has a nil entry under the name: %q", cert)
+			return nil, false, fmt.Errorf("certificate storage has a nil entry under the name: %q", cert)
 		}
 		// Append relevant certificates only
 		if (isPkcs && certEntry.Type == "pkcs7") ||
 			(!isPkcs && certEntry.Type == "identity") {
-			decodedCert, err := decodePEMAndParseCertificate(certEntry.AWSPublicCert)
+			decodedCerts, err := decodePEMAndParseCertificates(certEntry.AWSPublicCert)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
-			certs = append(certs, decodedCert)
+			certs = append(certs, decodedCerts...)
+			registered = true
 		}
 	}
 
-	return certs, nil
+	return certs, registered, nil
 }
 
 // lockedSetAWSPublicCertificateEntry is used to store the AWS public key in
@@ -398,14 +494,20 @@ func (b *backend) pathConfigCertificateCreateUpdate(ctx context.Context, req *lo
 		return logical.ErrorResponse("invalid aws_public_cert"), nil
 	}
 
-	// Verify the certificate by decoding it and parsing it
-	publicCert, err := decodePEMAndParseCertificate(certEntry.AWSPublicCert)
+	// Verify the certificate, or chain of certificates, by decoding and
+	// parsing it, and store it in its canonical PEM encoding
+	publicCerts, err := decodePEMAndParseCertificates(certEntry.AWSPublicCert)
 	if err != nil {
-		return nil, err
+		return logical.ErrorResponse(fmt.Sprintf("invalid aws_public_cert: %v", err)), nil
 	}
-	if publicCert == nil {
-		return logical.ErrorResponse("invalid certificate; failed to decode and parse certificate"), nil
+	if certEntry.Type == "identity" {
+		for _, publicCert := range publicCerts {
+			if err := checkIdentityCertificateAlgorithm(publicCert); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid aws_public_cert: %v", err)), nil
+			}
+		}
 	}
+	certEntry.AWSPublicCert = encodeCertificatesPEM(publicCerts)
 
 	// If none of the checks fail, save the provided certificate
 	if err := b.nonLockedSetAWSPublicCertificateEntry(ctx, req.Storage, certName, certEntry); err != nil {
@@ -0,0 +1,212 @@
+package awsauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestBackend_pathConfigCertificate_validatePEM(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeCert := func(awsPublicCert string) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/certificate/testcert",
+			Data: map[string]interface{}{
+				"aws_public_cert": awsPublicCert,
+				"type":            "pkcs7",
+			},
+			Storage: storage,
+		})
+	}
+	readCert := func() string {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config/certificate/testcert",
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("failed to read certificate: resp:%#v err:%v", resp, err)
+		}
+		return resp.Data["aws_public_cert"].(string)
+	}
+
+	// A single certificate, with CRLF line endings and surrounding whitespace,
+	// is stored in its canonical encoding
+	untidyCert := "\n  " + strings.Replace(genericAWSPublicCertificatePkcs7, "\n", "\r\n", -1) + "\n\n"
+	resp, err := writeCert(untidyCert)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write certificate: resp:%#v err:%v", resp, err)
+	}
+	if readCert() != strings.TrimLeft(genericAWSPublicCertificatePkcs7, "\n") {
+		t.Fatalf("bad: expected the certificate to be normalized, got:\n%s", readCert())
+	}
+
+	// A base64 encoded chain of certificates is accepted as a whole
+	chain := genericAWSPublicCertificatePkcs7 + genericAWSPublicCertificateIdentity
+	resp, err = writeCert(base64.StdEncoding.EncodeToString([]byte(chain)))
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write certificate chain: resp:%#v err:%v", resp, err)
+	}
+	if strings.Count(readCert(), "-----BEGIN CERTIFICATE-----") != 2 {
+		t.Fatalf("bad: expected the chain to be stored, got:\n%s", readCert())
+	}
+	publicCerts, _, err := b.awsPublicCertificates(context.Background(), storage, true, "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(publicCerts) != 3 {
+		t.Fatalf("bad: expected the generic certificate and the chain, got %d certificates", len(publicCerts))
+	}
+
+	malformedCerts := map[string]string{
+		"not PEM":          "not a certificate",
+		"corrupt contents": "-----BEGIN CERTIFICATE-----\nbm90IGEgY2VydGlmaWNhdGU=\n-----END CERTIFICATE-----\n",
+		"wrong block type": strings.Replace(genericAWSPublicCertificatePkcs7, "CERTIFICATE", "PUBLIC KEY", -1),
+		"trailing data":    genericAWSPublicCertificatePkcs7 + "trailing data",
+	}
+	for name, malformedCert := range malformedCerts {
+		resp, err = writeCert(malformedCert)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if resp == nil || !resp.IsError() || !strings.HasPrefix(resp.Data["error"].(string), "invalid aws_public_cert") {
+			t.Fatalf("%s: expected the certificate to be rejected, got resp:%#v", name, resp)
+		}
+	}
+
+	// Rejected writes leave the stored certificate alone
+	if strings.Count(readCert(), "-----BEGIN CERTIFICATE-----") != 2 {
+		t.Fatalf("bad: expected the chain to be kept, got:\n%s", readCert())
+	}
+}
+
+func TestBackend_awsPublicCertificates_partitionDefaults(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test govcloud certificate"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+
+	sign := func(region string) ([]byte, []byte) {
+		identityBytes, err := json.Marshal(&identityDocument{
+			InstanceID: "i-1234567890abcdef0",
+			AccountID:  "123456789012",
+			Region:     region,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := sha256.Sum256(identityBytes)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return identityBytes, signature
+	}
+
+	// Only the commercial partition has default certificates
+	for _, isPkcs := range []bool{false, true} {
+		publicCerts, _, err := b.awsPublicCertificates(context.Background(), storage, isPkcs, "us-east-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(publicCerts) != 1 {
+			t.Fatalf("bad: expected the commercial default certificate, got %d certificates", len(publicCerts))
+		}
+
+		publicCerts, _, err = b.awsPublicCertificates(context.Background(), storage, isPkcs, "us-gov-west-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(publicCerts) != 0 {
+			t.Fatalf("bad: expected no GovCloud default certificates, got %d certificates", len(publicCerts))
+		}
+	}
+
+	// A GovCloud document cannot be verified before its certificate is
+	// registered
+	identityBytes, signature := sign("us-gov-west-1")
+	_, err = b.verifyInstanceIdentitySignature(context.Background(), storage, identityBytes, signature)
+	if err != errNoRegisteredCertificates {
+		t.Fatalf("bad: expected the no registered certificates error, got %v", err)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/certificate/govcloud",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"aws_public_cert": string(certPEM),
+			"type":            "identity",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp:%#v err:%v", resp, err)
+	}
+
+	identityDoc, err := b.verifyInstanceIdentitySignature(context.Background(), storage, identityBytes, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identityDoc.signingCert == nil || !identityDoc.signingCert.Equal(&x509.Certificate{Raw: certDER}) {
+		t.Fatalf("bad: expected the registered certificate to verify the document, got %#v", identityDoc.signingCert)
+	}
+
+	// The commercial default is used along with the registered certificate
+	publicCerts, _, err := b.awsPublicCertificates(context.Background(), storage, false, "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(publicCerts) != 2 {
+		t.Fatalf("bad: expected the commercial default and the registered certificate, got %d certificates", len(publicCerts))
+	}
+}
@@ -2,8 +2,16 @@ package awsauth
 
 import (
 	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -39,19 +47,169 @@ func pathConfigClient(b *backend) *framework.Path {
 			"sts_endpoint": &framework.FieldSchema{
 				Type:        framework.TypeString,
 				Default:     "",
-				Description: "URL to override the default generated endpoint for making AWS STS API calls.",
+				Description: "URL to override the default generated endpoint for making AWS STS API calls. If not set, iam logins are verified against the STS endpoint of the region their request was signed for.",
 			},
 
 			"iam_server_id_header_value": &framework.FieldSchema{
-				Type:        framework.TypeString,
-				Default:     "",
-				Description: "Value to require in the X-Vault-AWS-IAM-Server-ID request header",
+				Type:        framework.TypeCommaStringSlice,
+				Default:     []string{},
+				Description: "Values to accept in the X-Vault-AWS-IAM-Server-ID request header, one of which is required if any is set. Can be a comma-separated string or a list.",
 			},
 			"max_retries": &framework.FieldSchema{
 				Type:        framework.TypeInt,
 				Default:     aws.UseServiceDefaultRetries,
 				Description: "Maximum number of retries for recoverable exceptions of AWS APIs",
 			},
+			"allow_insecure_endpoints": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "If set, allows the EC2, IAM and STS endpoints to use plain HTTP. This should only be used with test servers.",
+			},
+			"max_request_body_size": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Default:     0,
+				Description: "Maximum size, in bytes, of the signed request body accepted by the iam auth method. Roles may override it. Defaults to 0, meaning no limit.",
+			},
+			"max_cache_entries": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Default:     0,
+				Description: "Maximum number of entries of the in-memory cache of AWS API lookups made during logins, beyond which the least recently used entries are evicted. Defaults to 0, meaning 10000.",
+			},
+			"max_bound_iam_principal_arns": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Default:     0,
+				Description: "Maximum number of entries of the bound_iam_principal_arn of a role, checked when the role is written. Defaults to 0, meaning 1000.",
+			},
+			"default_resolve_aws_unique_ids": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     true,
+				Description: "Default of the resolve_aws_unique_ids of roles created without setting it. Existing roles are not affected.",
+			},
+			"default_ttl": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Default:     0,
+				Description: "Default TTL of the tokens issued by roles which do not set a ttl. Defaults to 0, meaning the system default TTL is used.",
+			},
+			"default_max_ttl": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Default:     0,
+				Description: "Default maximum TTL of the tokens issued by roles which do not set a max_ttl. Defaults to 0, meaning the system maximum TTL is used.",
+			},
+			"organizations_endpoint": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "URL to override the default generated endpoint for making AWS Organizations API calls.",
+			},
+			"organization_cache_ttl": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Default:     int(defaultOrganizationCacheTTL / time.Second),
+				Description: "Duration for which the organization an account belongs to is cached, once looked up for the bound_organization_id of a role. Defaults to 1 hour.",
+			},
+			"iam_request_max_age": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Default:     int(defaultIAMRequestMaxAge / time.Second),
+				Description: "Maximum age of the X-Amz-Date of the signed GetCallerIdentity requests of iam logins. Older requests are rejected without being sent to STS. Defaults to 15 minutes.",
+			},
+			"unknown_policy_action": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     unknownPolicyActionIgnore,
+				Description: "Action taken on logins to roles whose policies do not exist in Vault: 'ignore', 'warn' to add a warning to the login response, or 'reject' to fail the login. Defaults to 'ignore'.",
+			},
+			"lookup_failure_policy": &framework.FieldSchema{
+				Type:        framework.TypeKVPairs,
+				Description: "Map of the optional AWS API lookups made during logins to the action taken when they fail: 'fail_closed' to fail the login, or 'fail_open' to proceed with a warning. The lookups are 'management_account', which defaults to 'fail_closed', and 'team_tag', 'matched_bound_arns' and 'unique_id', which default to 'fail_open'.",
+			},
+			"require_signed_host_header": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "If set, iam logins are rejected unless the Host header is listed in the SignedHeaders of the Authorization header of the signed GetCallerIdentity request.",
+			},
+			"max_signed_headers": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Default:     0,
+				Description: "Maximum number of headers listed in the SignedHeaders of the Authorization header of the signed GetCallerIdentity request of iam logins. Logins exceeding it are rejected before the request is forwarded to STS. Defaults to 0, which means no limit.",
+			},
+			"max_caller_arn_length": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Default:     0,
+				Description: "Maximum length of the ARN of the caller returned by STS on iam logins, which are rejected before the ARN is processed any further when it is longer. Defaults to 0, which means the maximum length of IAM ARNs of 2048 characters; it can only be lowered.",
+			},
+			"require_form_content_type": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "If set, iam logins are rejected unless the signed GetCallerIdentity request declares the 'application/x-www-form-urlencoded' Content-Type, and the Content-Type header is listed in the SignedHeaders of its Authorization header.",
+			},
+			"correlation_header": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "Name of a request header, such as 'X-Request-Id', whose value is echoed back in the 'correlation_id' field of the data of successful login responses and logged along with the login. The header must be listed in the 'passthrough_request_headers' of the mount for Vault to pass it to the auth method.",
+			},
+			"issuer": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Default:     "",
+				Description: "Identifier of this Vault and mount, such as 'vault-us-east-1/aws', stamped into the 'issuer' metadata of the tokens issued by all logins so that downstream systems know where they were minted.",
+			},
+			"redact_arns_in_errors": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "If set, ARNs in the error responses of logins are replaced with a hash of the ARN. The full ARNs are logged at debug level.",
+			},
+			"allowed_sts_endpoints": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separated list of host names, optionally with a port, of the STS endpoints which iam logins may be forwarded to. If set, logins whose STS endpoint, whether configured or derived from the signed request, is not listed are rejected.",
+			},
+			"denied_ec2_instance_ids": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separated list of EC2 instance IDs which are not allowed to login or renew using the ec2 auth method, regardless of the role.",
+			},
+			"auto_create_roles": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "If set, an iam login naming a role which does not exist creates the role from auto_create_role_template, provided that the caller matches auto_create_principal_pattern and that the role is named after the caller.",
+			},
+			"auto_create_role_template": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the iam role copied to create roles on login when auto_create_roles is set.",
+			},
+			"auto_create_principal_pattern": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "ARN of the IAM users or roles, possibly ending with a wildcard, for which roles can be created on login when auto_create_roles is set. It must name a single account.",
+			},
+
+			"role_name_pattern": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "If set, iam logins are rejected unless the name of the role matches this pattern, in which {{friendly_name}} and {{account_id}} are replaced with those of the caller and '*' matches any characters, such as 'app-{{friendly_name}}'.",
+			},
+
+			"reject_replays": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "If set, iam logins presenting a signed GetCallerIdentity request which was already used to login are rejected for as long as its signature is valid. Only a hash of the signed request is stored.",
+			},
+
+			"validate_caller_identity": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "If set, iam logins are rejected unless the account of the caller identity returned by STS matches the account in its ARN, and its user ID is consistent with the ARN for root and assumed role principals.",
+			},
+
+			"global_instance_nonce": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "If set, an EC2 instance must present the same nonce on logins to any role, even to roles which allow instance migration, instead of only to the role it first logged in to.",
+			},
+
+			"emit_login_events": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "If set, a structured event holding the role, auth type, canonical ARN and time of every successful login is emitted to the server log.",
+			},
+
+			"redact_login_event_arns": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "If set, the canonical ARNs in login events are replaced with a stable hash which does not reveal them.",
+			},
 		},
 
 		ExistenceCheck: b.pathConfigClientExistenceCheck,
@@ -100,9 +258,38 @@ func (b *backend) nonLockedClientConfigEntry(ctx context.Context, s logical.Stor
 	if err := entry.DecodeJSON(&result); err != nil {
 		return nil, err
 	}
+	result.upgrade()
+
 	return &result, nil
 }
 
+// resizeLookupCache sizes the lookup cache according to the stored client
+// configuration, after acquiring an exclusive lock.
+func (b *backend) resizeLookupCache(ctx context.Context, s logical.Storage) error {
+	b.configMutex.Lock()
+	defer b.configMutex.Unlock()
+
+	return b.nonLockedResizeLookupCache(ctx, s)
+}
+
+// nonLockedResizeLookupCache sizes the lookup cache according to the stored
+// client configuration. The default size is used when there is none.
+func (b *backend) nonLockedResizeLookupCache(ctx context.Context, s logical.Storage) error {
+	if s == nil {
+		return nil
+	}
+	config, err := b.nonLockedClientConfigEntry(ctx, s)
+	if err != nil {
+		return err
+	}
+	maxEntries := defaultMaxCacheEntries
+	if config != nil {
+		maxEntries = config.MaxCacheEntries
+	}
+	b.lookupCache.resize(maxEntries)
+	return nil
+}
+
 func (b *backend) pathConfigClientRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	clientConfig, err := b.lockedClientConfigEntry(ctx, req.Storage)
 	if err != nil {
@@ -113,34 +300,41 @@ func (b *backend) pathConfigClientRead(ctx context.Context, req *logical.Request
 		return nil, nil
 	}
 
-	return &logical.Response{
-		Data: map[string]interface{}{
-			"access_key":                 clientConfig.AccessKey,
-			"endpoint":                   clientConfig.Endpoint,
-			"iam_endpoint":               clientConfig.IAMEndpoint,
-			"sts_endpoint":               clientConfig.STSEndpoint,
-			"iam_server_id_header_value": clientConfig.IAMServerIdHeaderValue,
-			"max_retries":                clientConfig.MaxRetries,
-		},
-	}, nil
+	// The secret key is never returned. The endpoint iam logins are
+	// actually verified against, and the region used when no endpoint is
+	// configured, are returned to help debugging endpoint overrides.
+	resp := &logical.Response{
+		Data: clientConfig.ToResponseData(),
+	}
+	resp.Data["effective_sts_endpoint"] = clientConfig.effectiveSTSEndpoint()
+	resp.Data["fallback_region"] = defaultSTSRegion
+	return resp, nil
 }
 
 func (b *backend) pathConfigClientDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	b.configMutex.Lock()
 	defer b.configMutex.Unlock()
 
+	configEntry, err := b.nonLockedClientConfigEntry(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := req.Storage.Delete(ctx, "config/client"); err != nil {
 		return nil, err
 	}
 
-	// Remove all the cached EC2 client objects in the backend.
-	b.flushCachedEC2Clients()
+	// Keep the deleted configuration around so that it can be rolled back to
+	if configEntry != nil {
+		if err := b.nonLockedPushClientConfigHistory(ctx, req.Storage, configEntry); err != nil {
+			return nil, err
+		}
+	}
 
-	// Remove all the cached EC2 client objects in the backend.
-	b.flushCachedIAMClients()
+	// Remove all the cached client objects in the backend
+	b.flushCachedClients()
 
-	// unset the cached default AWS account ID
-	b.defaultAWSAccountID = ""
+	b.lookupCache.resize(defaultMaxCacheEntries)
 
 	return nil, nil
 }
@@ -155,8 +349,14 @@ func (b *backend) pathConfigClientCreateUpdate(ctx context.Context, req *logical
 	if err != nil {
 		return nil, err
 	}
+	// Remember the stored configuration, if any, so that it can be added to
+	// the history of client configurations once the update is persisted
+	var previousConfig *clientConfig
 	if configEntry == nil {
 		configEntry = &clientConfig{}
+	} else {
+		previous := *configEntry
+		previousConfig = &previous
 	}
 
 	// changedCreds is whether we need to flush the cached AWS clients and store in the backend
@@ -221,25 +421,408 @@ func (b *backend) pathConfigClientCreateUpdate(ctx context.Context, req *logical
 		configEntry.STSEndpoint = data.Get("sts_endpoint").(string)
 	}
 
-	headerValStr, ok := data.GetOk("iam_server_id_header_value")
+	headerValsRaw, ok := data.GetOk("iam_server_id_header_value")
 	if ok {
-		if configEntry.IAMServerIdHeaderValue != headerValStr.(string) {
+		headerVals := strutil.RemoveDuplicates(headerValsRaw.([]string), false)
+		if !strutil.EquivalentSlices(configEntry.IAMServerIdHeaderValues, headerVals) {
 			// NOT setting changedCreds here, since this isn't really cached
-			configEntry.IAMServerIdHeaderValue = headerValStr.(string)
+			configEntry.IAMServerIdHeaderValues = headerVals
 			changedOtherConfig = true
 		}
 	} else if req.Operation == logical.CreateOperation {
-		configEntry.IAMServerIdHeaderValue = data.Get("iam_server_id_header_value").(string)
+		configEntry.IAMServerIdHeaderValues = data.Get("iam_server_id_header_value").([]string)
 	}
 
 	maxRetriesInt, ok := data.GetOk("max_retries")
 	if ok {
-		configEntry.MaxRetries = maxRetriesInt.(int)
-		changedOtherConfig = true
+		if configEntry.MaxRetries != maxRetriesInt.(int) {
+			// The cached clients are built with the number of retries, so
+			// they need to be flushed for the change to take effect
+			changedCreds = true
+			configEntry.MaxRetries = maxRetriesInt.(int)
+		}
 	} else if req.Operation == logical.CreateOperation {
 		configEntry.MaxRetries = data.Get("max_retries").(int)
 	}
 
+	allowInsecureEndpointsBool, ok := data.GetOk("allow_insecure_endpoints")
+	if ok {
+		if configEntry.AllowInsecureEndpoints != allowInsecureEndpointsBool.(bool) {
+			configEntry.AllowInsecureEndpoints = allowInsecureEndpointsBool.(bool)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.AllowInsecureEndpoints = data.Get("allow_insecure_endpoints").(bool)
+	}
+
+	maxRequestBodySizeInt, ok := data.GetOk("max_request_body_size")
+	if ok {
+		if maxRequestBodySizeInt.(int) < 0 {
+			return logical.ErrorResponse("max_request_body_size cannot be negative"), nil
+		}
+		if configEntry.MaxRequestBodySize != maxRequestBodySizeInt.(int) {
+			configEntry.MaxRequestBodySize = maxRequestBodySizeInt.(int)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.MaxRequestBodySize = data.Get("max_request_body_size").(int)
+	}
+
+	maxCacheEntriesInt, ok := data.GetOk("max_cache_entries")
+	if ok {
+		if maxCacheEntriesInt.(int) < 0 {
+			return logical.ErrorResponse("max_cache_entries cannot be negative"), nil
+		}
+		if configEntry.MaxCacheEntries != maxCacheEntriesInt.(int) {
+			configEntry.MaxCacheEntries = maxCacheEntriesInt.(int)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.MaxCacheEntries = data.Get("max_cache_entries").(int)
+	}
+
+	maxBoundIamPrincipalARNsInt, ok := data.GetOk("max_bound_iam_principal_arns")
+	if ok {
+		if maxBoundIamPrincipalARNsInt.(int) < 0 {
+			return logical.ErrorResponse("max_bound_iam_principal_arns cannot be negative"), nil
+		}
+		if configEntry.MaxBoundIamPrincipalARNs != maxBoundIamPrincipalARNsInt.(int) {
+			configEntry.MaxBoundIamPrincipalARNs = maxBoundIamPrincipalARNsInt.(int)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.MaxBoundIamPrincipalARNs = data.Get("max_bound_iam_principal_arns").(int)
+	}
+
+	defaultResolveAWSUniqueIDsBool, ok := data.GetOk("default_resolve_aws_unique_ids")
+	if ok {
+		if configEntry.NoDefaultResolveAWSUniqueIDs != !defaultResolveAWSUniqueIDsBool.(bool) {
+			configEntry.NoDefaultResolveAWSUniqueIDs = !defaultResolveAWSUniqueIDsBool.(bool)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.NoDefaultResolveAWSUniqueIDs = !data.Get("default_resolve_aws_unique_ids").(bool)
+	}
+
+	defaultTTLInt, ok := data.GetOk("default_ttl")
+	if ok {
+		defaultTTL := time.Duration(defaultTTLInt.(int)) * time.Second
+		if defaultTTL < 0 {
+			return logical.ErrorResponse("default_ttl cannot be negative"), nil
+		}
+		if configEntry.DefaultTTL != defaultTTL {
+			configEntry.DefaultTTL = defaultTTL
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.DefaultTTL = time.Duration(data.Get("default_ttl").(int)) * time.Second
+	}
+
+	defaultMaxTTLInt, ok := data.GetOk("default_max_ttl")
+	if ok {
+		defaultMaxTTL := time.Duration(defaultMaxTTLInt.(int)) * time.Second
+		if defaultMaxTTL < 0 {
+			return logical.ErrorResponse("default_max_ttl cannot be negative"), nil
+		}
+		if configEntry.DefaultMaxTTL != defaultMaxTTL {
+			configEntry.DefaultMaxTTL = defaultMaxTTL
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.DefaultMaxTTL = time.Duration(data.Get("default_max_ttl").(int)) * time.Second
+	}
+
+	organizationsEndpointStr, ok := data.GetOk("organizations_endpoint")
+	if ok {
+		if configEntry.OrganizationsEndpoint != organizationsEndpointStr.(string) {
+			configEntry.OrganizationsEndpoint = organizationsEndpointStr.(string)
+			// Organizations clients are not cached, so there is nothing to flush
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.OrganizationsEndpoint = data.Get("organizations_endpoint").(string)
+	}
+
+	organizationCacheTTLInt, ok := data.GetOk("organization_cache_ttl")
+	if ok {
+		organizationCacheTTL := time.Duration(organizationCacheTTLInt.(int)) * time.Second
+		if organizationCacheTTL <= 0 {
+			return logical.ErrorResponse("organization_cache_ttl must be positive"), nil
+		}
+		if configEntry.OrganizationCacheTTL != organizationCacheTTL {
+			configEntry.OrganizationCacheTTL = organizationCacheTTL
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.OrganizationCacheTTL = time.Duration(data.Get("organization_cache_ttl").(int)) * time.Second
+	}
+
+	iamRequestMaxAgeInt, ok := data.GetOk("iam_request_max_age")
+	if ok {
+		iamRequestMaxAge := time.Duration(iamRequestMaxAgeInt.(int)) * time.Second
+		if iamRequestMaxAge <= 0 {
+			return logical.ErrorResponse("iam_request_max_age must be positive"), nil
+		}
+		if configEntry.IAMRequestMaxAge != iamRequestMaxAge {
+			configEntry.IAMRequestMaxAge = iamRequestMaxAge
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.IAMRequestMaxAge = time.Duration(data.Get("iam_request_max_age").(int)) * time.Second
+	}
+
+	if configEntry.DefaultMaxTTL > 0 && configEntry.DefaultTTL > configEntry.DefaultMaxTTL {
+		return logical.ErrorResponse("default_ttl should be shorter than default_max_ttl"), nil
+	}
+
+	requireSignedHostHeaderBool, ok := data.GetOk("require_signed_host_header")
+	if ok {
+		if configEntry.RequireSignedHostHeader != requireSignedHostHeaderBool.(bool) {
+			configEntry.RequireSignedHostHeader = requireSignedHostHeaderBool.(bool)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.RequireSignedHostHeader = data.Get("require_signed_host_header").(bool)
+	}
+
+	maxSignedHeadersInt, ok := data.GetOk("max_signed_headers")
+	if ok {
+		if maxSignedHeadersInt.(int) < 0 {
+			return logical.ErrorResponse("max_signed_headers cannot be negative"), nil
+		}
+		if configEntry.MaxSignedHeaders != maxSignedHeadersInt.(int) {
+			configEntry.MaxSignedHeaders = maxSignedHeadersInt.(int)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.MaxSignedHeaders = data.Get("max_signed_headers").(int)
+	}
+
+	maxCallerARNLengthInt, ok := data.GetOk("max_caller_arn_length")
+	if ok {
+		if maxCallerARNLengthInt.(int) < 0 || maxCallerARNLengthInt.(int) > maxCallerARNLength {
+			return logical.ErrorResponse(fmt.Sprintf("max_caller_arn_length must be between 0 and %d", maxCallerARNLength)), nil
+		}
+		if configEntry.MaxCallerARNLength != maxCallerARNLengthInt.(int) {
+			configEntry.MaxCallerARNLength = maxCallerARNLengthInt.(int)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.MaxCallerARNLength = data.Get("max_caller_arn_length").(int)
+	}
+
+	requireFormContentTypeBool, ok := data.GetOk("require_form_content_type")
+	if ok {
+		if configEntry.RequireFormContentType != requireFormContentTypeBool.(bool) {
+			configEntry.RequireFormContentType = requireFormContentTypeBool.(bool)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.RequireFormContentType = data.Get("require_form_content_type").(bool)
+	}
+
+	correlationHeaderStr, ok := data.GetOk("correlation_header")
+	if ok {
+		if configEntry.CorrelationHeader != correlationHeaderStr.(string) {
+			configEntry.CorrelationHeader = correlationHeaderStr.(string)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.CorrelationHeader = data.Get("correlation_header").(string)
+	}
+
+	issuerStr, ok := data.GetOk("issuer")
+	if ok {
+		if configEntry.Issuer != issuerStr.(string) {
+			configEntry.Issuer = issuerStr.(string)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.Issuer = data.Get("issuer").(string)
+	}
+
+	redactARNsInErrorsBool, ok := data.GetOk("redact_arns_in_errors")
+	if ok {
+		if configEntry.RedactARNsInErrors != redactARNsInErrorsBool.(bool) {
+			configEntry.RedactARNsInErrors = redactARNsInErrorsBool.(bool)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.RedactARNsInErrors = data.Get("redact_arns_in_errors").(bool)
+	}
+
+	unknownPolicyActionStr, ok := data.GetOk("unknown_policy_action")
+	if ok {
+		if configEntry.UnknownPolicyAction != strings.ToLower(unknownPolicyActionStr.(string)) {
+			configEntry.UnknownPolicyAction = strings.ToLower(unknownPolicyActionStr.(string))
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.UnknownPolicyAction = data.Get("unknown_policy_action").(string)
+	}
+	switch configEntry.UnknownPolicyAction {
+	case "", unknownPolicyActionIgnore:
+	case unknownPolicyActionWarn, unknownPolicyActionReject:
+		if _, ok := b.System().(logical.PolicyLookupSystemView); !ok {
+			return logical.ErrorResponse("unknown_policy_action requires looking up policies, which is not supported by this mount"), nil
+		}
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid unknown_policy_action %q; expected 'ignore', 'warn' or 'reject'", configEntry.UnknownPolicyAction)), nil
+	}
+
+	lookupFailurePolicyRaw, ok := data.GetOk("lookup_failure_policy")
+	if ok {
+		lookupFailurePolicy := make(map[string]string)
+		for lookup, action := range lookupFailurePolicyRaw.(map[string]string) {
+			lookup = strings.ToLower(lookup)
+			action = strings.ToLower(action)
+			if _, ok := defaultLookupFailurePolicy[lookup]; !ok {
+				return logical.ErrorResponse(fmt.Sprintf("invalid lookup %q in lookup_failure_policy; expected 'management_account', 'team_tag', 'matched_bound_arns' or 'unique_id'", lookup)), nil
+			}
+			if action != lookupFailClosed && action != lookupFailOpen {
+				return logical.ErrorResponse(fmt.Sprintf("invalid action %q for lookup %q in lookup_failure_policy; expected 'fail_closed' or 'fail_open'", action, lookup)), nil
+			}
+			lookupFailurePolicy[lookup] = action
+		}
+		if !reflect.DeepEqual(configEntry.LookupFailurePolicy, lookupFailurePolicy) {
+			configEntry.LookupFailurePolicy = lookupFailurePolicy
+			changedOtherConfig = true
+		}
+	}
+
+	allowedSTSEndpointsRaw, ok := data.GetOk("allowed_sts_endpoints")
+	if ok {
+		allowedSTSEndpoints := strutil.RemoveDuplicates(allowedSTSEndpointsRaw.([]string), true)
+		for _, allowedSTSEndpoint := range allowedSTSEndpoints {
+			if strings.ContainsAny(allowedSTSEndpoint, "/@?#") {
+				return logical.ErrorResponse(fmt.Sprintf("invalid allowed_sts_endpoints entry %q; expected a host name, optionally with a port", allowedSTSEndpoint)), nil
+			}
+		}
+		if !strutil.EquivalentSlices(configEntry.AllowedSTSEndpoints, allowedSTSEndpoints) {
+			configEntry.AllowedSTSEndpoints = allowedSTSEndpoints
+			changedOtherConfig = true
+		}
+	}
+
+	deniedEC2InstanceIDsRaw, ok := data.GetOk("denied_ec2_instance_ids")
+	if ok {
+		deniedEC2InstanceIDs := strutil.RemoveDuplicates(deniedEC2InstanceIDsRaw.([]string), true)
+		if !strutil.EquivalentSlices(configEntry.DeniedEC2InstanceIDs, deniedEC2InstanceIDs) {
+			configEntry.DeniedEC2InstanceIDs = deniedEC2InstanceIDs
+			changedOtherConfig = true
+		}
+	}
+
+	autoCreateRolesBool, ok := data.GetOk("auto_create_roles")
+	if ok {
+		if configEntry.AutoCreateRoles != autoCreateRolesBool.(bool) {
+			configEntry.AutoCreateRoles = autoCreateRolesBool.(bool)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.AutoCreateRoles = data.Get("auto_create_roles").(bool)
+	}
+
+	autoCreateRoleTemplateStr, ok := data.GetOk("auto_create_role_template")
+	if ok {
+		if configEntry.AutoCreateRoleTemplate != strings.ToLower(autoCreateRoleTemplateStr.(string)) {
+			configEntry.AutoCreateRoleTemplate = strings.ToLower(autoCreateRoleTemplateStr.(string))
+			changedOtherConfig = true
+		}
+	}
+
+	autoCreatePrincipalPatternStr, ok := data.GetOk("auto_create_principal_pattern")
+	if ok {
+		if configEntry.AutoCreatePrincipalPattern != autoCreatePrincipalPatternStr.(string) {
+			configEntry.AutoCreatePrincipalPattern = autoCreatePrincipalPatternStr.(string)
+			changedOtherConfig = true
+		}
+	}
+
+	roleNamePatternStr, ok := data.GetOk("role_name_pattern")
+	if ok {
+		roleNamePattern := strings.ToLower(roleNamePatternStr.(string))
+		if err := validateRoleNamePattern(roleNamePattern); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid role_name_pattern: %v", err)), nil
+		}
+		if configEntry.RoleNamePattern != roleNamePattern {
+			configEntry.RoleNamePattern = roleNamePattern
+			changedOtherConfig = true
+		}
+	}
+
+	rejectReplaysBool, ok := data.GetOk("reject_replays")
+	if ok {
+		if configEntry.RejectReplays != rejectReplaysBool.(bool) {
+			configEntry.RejectReplays = rejectReplaysBool.(bool)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.RejectReplays = data.Get("reject_replays").(bool)
+	}
+
+	globalInstanceNonceBool, ok := data.GetOk("global_instance_nonce")
+	if ok {
+		if configEntry.GlobalInstanceNonce != globalInstanceNonceBool.(bool) {
+			configEntry.GlobalInstanceNonce = globalInstanceNonceBool.(bool)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.GlobalInstanceNonce = data.Get("global_instance_nonce").(bool)
+	}
+
+	validateCallerIdentityBool, ok := data.GetOk("validate_caller_identity")
+	if ok {
+		if configEntry.ValidateCallerIdentity != validateCallerIdentityBool.(bool) {
+			configEntry.ValidateCallerIdentity = validateCallerIdentityBool.(bool)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.ValidateCallerIdentity = data.Get("validate_caller_identity").(bool)
+	}
+
+	emitLoginEventsBool, ok := data.GetOk("emit_login_events")
+	if ok {
+		if configEntry.EmitLoginEvents != emitLoginEventsBool.(bool) {
+			configEntry.EmitLoginEvents = emitLoginEventsBool.(bool)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.EmitLoginEvents = data.Get("emit_login_events").(bool)
+	}
+
+	redactLoginEventARNsBool, ok := data.GetOk("redact_login_event_arns")
+	if ok {
+		if configEntry.RedactLoginEventARNs != redactLoginEventARNsBool.(bool) {
+			configEntry.RedactLoginEventARNs = redactLoginEventARNsBool.(bool)
+			changedOtherConfig = true
+		}
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.RedactLoginEventARNs = data.Get("redact_login_event_arns").(bool)
+	}
+
+	if configEntry.AutoCreateRoles {
+		if configEntry.AutoCreateRoleTemplate == "" || configEntry.AutoCreatePrincipalPattern == "" {
+			return logical.ErrorResponse("auto_create_roles requires auto_create_role_template and auto_create_principal_pattern to be set"), nil
+		}
+	}
+	if configEntry.AutoCreatePrincipalPattern != "" && !autoCreatePrincipalPatternRegex.MatchString(configEntry.AutoCreatePrincipalPattern) {
+		return logical.ErrorResponse("auto_create_principal_pattern must be the ARN of IAM users or roles of a single account, such as 'arn:aws:iam::123456789012:role/ci-*'"), nil
+	}
+
+	// Signed requests are forwarded to these endpoints, so they must not be
+	// sent in the clear unless explicitly allowed
+	for field, endpoint := range map[string]string{
+		"endpoint":               configEntry.Endpoint,
+		"iam_endpoint":           configEntry.IAMEndpoint,
+		"sts_endpoint":           configEntry.STSEndpoint,
+		"organizations_endpoint": configEntry.OrganizationsEndpoint,
+	} {
+		if err := validateEndpointScheme(endpoint, configEntry.AllowInsecureEndpoints); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid %s: %v", field, err)), nil
+		}
+	}
+
 	// Since this endpoint supports both create operation and update operation,
 	// the error checks for access_key and secret_key not being set are not present.
 	// This allows calling this endpoint multiple times to provide the values.
@@ -254,27 +837,365 @@ func (b *backend) pathConfigClientCreateUpdate(ctx context.Context, req *logical
 		if err := req.Storage.Put(ctx, entry); err != nil {
 			return nil, err
 		}
+		if previousConfig != nil {
+			if err := b.nonLockedPushClientConfigHistory(ctx, req.Storage, previousConfig); err != nil {
+				return nil, err
+			}
+		}
 	}
 
+	// The clients are flushed while holding the config lock, so that the
+	// next login builds them from the configuration just stored
 	if changedCreds {
-		b.flushCachedEC2Clients()
-		b.flushCachedIAMClients()
-		b.defaultAWSAccountID = ""
+		b.flushCachedClients()
 	}
 
+	b.lookupCache.resize(configEntry.MaxCacheEntries)
+
 	return nil, nil
 }
 
 // Struct to hold 'aws_access_key' and 'aws_secret_key' that are required to
 // interact with the AWS EC2 API.
 type clientConfig struct {
-	AccessKey              string `json:"access_key"`
-	SecretKey              string `json:"secret_key"`
-	Endpoint               string `json:"endpoint"`
-	IAMEndpoint            string `json:"iam_endpoint"`
-	STSEndpoint            string `json:"sts_endpoint"`
-	IAMServerIdHeaderValue string `json:"iam_server_id_header_value"`
-	MaxRetries             int    `json:"max_retries"`
+	AccessKey                string   `json:"access_key"`
+	SecretKey                string   `json:"secret_key"`
+	Endpoint                 string   `json:"endpoint"`
+	IAMEndpoint              string   `json:"iam_endpoint"`
+	STSEndpoint              string   `json:"sts_endpoint"`
+	IAMServerIdHeaderValues  []string `json:"iam_server_id_header_value_list"`
+	MaxRetries               int      `json:"max_retries"`
+	AllowInsecureEndpoints   bool     `json:"allow_insecure_endpoints"`
+	MaxRequestBodySize       int      `json:"max_request_body_size"`
+	MaxCacheEntries          int      `json:"max_cache_entries"`
+	MaxBoundIamPrincipalARNs int      `json:"max_bound_iam_principal_arns"`
+	// Stored inverted, so that configurations written before the option
+	// existed keep resolving unique IDs by default
+	NoDefaultResolveAWSUniqueIDs bool              `json:"no_default_resolve_aws_unique_ids"`
+	DefaultTTL                   time.Duration     `json:"default_ttl"`
+	DefaultMaxTTL                time.Duration     `json:"default_max_ttl"`
+	IAMRequestMaxAge             time.Duration     `json:"iam_request_max_age"`
+	OrganizationsEndpoint        string            `json:"organizations_endpoint"`
+	OrganizationCacheTTL         time.Duration     `json:"organization_cache_ttl"`
+	RequireSignedHostHeader      bool              `json:"require_signed_host_header"`
+	MaxSignedHeaders             int               `json:"max_signed_headers"`
+	MaxCallerARNLength           int               `json:"max_caller_arn_length"`
+	RequireFormContentType       bool              `json:"require_form_content_type"`
+	CorrelationHeader            string            `json:"correlation_header"`
+	Issuer                       string            `json:"issuer"`
+	RedactARNsInErrors           bool              `json:"redact_arns_in_errors"`
+	AllowedSTSEndpoints          []string          `json:"allowed_sts_endpoints"`
+	DeniedEC2InstanceIDs         []string          `json:"denied_ec2_instance_ids"`
+	UnknownPolicyAction          string            `json:"unknown_policy_action"`
+	LookupFailurePolicy          map[string]string `json:"lookup_failure_policy"`
+	AutoCreateRoles              bool              `json:"auto_create_roles"`
+	AutoCreateRoleTemplate       string            `json:"auto_create_role_template"`
+	AutoCreatePrincipalPattern   string            `json:"auto_create_principal_pattern"`
+	RoleNamePattern              string            `json:"role_name_pattern"`
+	RejectReplays                bool              `json:"reject_replays"`
+	EmitLoginEvents              bool              `json:"emit_login_events"`
+	ValidateCallerIdentity       bool              `json:"validate_caller_identity"`
+	RedactLoginEventARNs         bool              `json:"redact_login_event_arns"`
+	GlobalInstanceNonce          bool              `json:"global_instance_nonce"`
+	// DEPRECATED -- the single value of the server ID header accepted before
+	// lists were supported, only read to upgrade older configurations
+	IAMServerIdHeaderValue string `json:"iam_server_id_header_value,omitempty"`
+}
+
+// upgrade converts the fields of a configuration stored by an older version
+// to their current form
+func (c *clientConfig) upgrade() {
+	// Only a single value of the server ID header used to be accepted
+	if c.IAMServerIdHeaderValue != "" {
+		if len(c.IAMServerIdHeaderValues) == 0 {
+			c.IAMServerIdHeaderValues = []string{c.IAMServerIdHeaderValue}
+		}
+		c.IAMServerIdHeaderValue = ""
+	}
+	// The age of signed requests used not to be bounded
+	if c.IAMRequestMaxAge == 0 {
+		c.IAMRequestMaxAge = defaultIAMRequestMaxAge
+	}
+	if c.OrganizationCacheTTL == 0 {
+		c.OrganizationCacheTTL = defaultOrganizationCacheTTL
+	}
+	// Endpoints used not to be required to use https, so configurations
+	// already sending requests in the clear keep doing so
+	if !c.AllowInsecureEndpoints {
+		for _, endpoint := range []string{c.Endpoint, c.IAMEndpoint, c.STSEndpoint, c.OrganizationsEndpoint} {
+			if parsedEndpoint, err := url.Parse(endpoint); err == nil && strings.EqualFold(parsedEndpoint.Scheme, "http") {
+				c.AllowInsecureEndpoints = true
+				break
+			}
+		}
+	}
+}
+
+const (
+	// Default maximum age of the signed requests of iam logins, which is
+	// how long AWS accepts a signed request for
+	defaultIAMRequestMaxAge = maxSignedRequestAge
+
+	// How far ahead of the clock of this node the date of a signed request
+	// may be, to allow for clock skew
+	maxIAMRequestClockSkew = 5 * time.Minute
+
+	// Default duration for which the organization of an account is cached
+	defaultOrganizationCacheTTL = time.Hour
+)
+
+// Actions taken on logins to roles whose policies do not exist
+const (
+	unknownPolicyActionIgnore = "ignore"
+	unknownPolicyActionWarn   = "warn"
+	unknownPolicyActionReject = "reject"
+)
+
+// Optional AWS API lookups made during logins, whose failure is handled
+// according to the lookup_failure_policy of the client configuration
+const (
+	managementAccountLookup = "management_account"
+	teamTagLookup           = "team_tag"
+	matchedBoundARNsLookup  = "matched_bound_arns"
+	uniqueIDLookup          = "unique_id"
+)
+
+// Actions taken when an optional lookup fails
+const (
+	lookupFailClosed = "fail_closed"
+	lookupFailOpen   = "fail_open"
+)
+
+// defaultLookupFailurePolicy holds the actions taken when optional lookups
+// fail, unless the client configuration overrides them. Lookups enforcing a
+// role constraint fail closed, and those only enriching the login metadata
+// fail open.
+var defaultLookupFailurePolicy = map[string]string{
+	managementAccountLookup: lookupFailClosed,
+	teamTagLookup:           lookupFailOpen,
+	matchedBoundARNsLookup:  lookupFailOpen,
+	uniqueIDLookup:          lookupFailOpen,
+}
+
+// lookupFailurePolicy returns the action taken when each of the optional
+// lookups fails
+func (c *clientConfig) lookupFailurePolicy() map[string]string {
+	policy := make(map[string]string, len(defaultLookupFailurePolicy))
+	for lookup, action := range defaultLookupFailurePolicy {
+		policy[lookup] = action
+	}
+	for lookup, action := range c.LookupFailurePolicy {
+		policy[lookup] = action
+	}
+	return policy
+}
+
+// lookupFailed handles the failure of an optional lookup made during a login.
+// If the lookup fails closed, the error is returned to fail the login;
+// otherwise it is logged, a warning is added to the given warnings, and nil
+// is returned so that the login proceeds.
+func (b *backend) lookupFailed(ctx context.Context, s logical.Storage, lookup string, lookupErr error, warnings *[]string) error {
+	config, err := b.lockedClientConfigEntry(ctx, s)
+	if err != nil {
+		return err
+	}
+	action := defaultLookupFailurePolicy[lookup]
+	if config != nil {
+		action = config.lookupFailurePolicy()[lookup]
+	}
+	if action != lookupFailOpen {
+		return lookupErr
+	}
+
+	b.Logger().Warn("optional lookup failed; proceeding with the login", "lookup", lookup, "error", lookupErr)
+	*warnings = append(*warnings, fmt.Sprintf("%s lookup failed and was skipped: %v", lookup, lookupErr))
+	return nil
+}
+
+// unknownPolicies returns the policies which do not exist in Vault, if the
+// client configuration asks for them to be checked
+func (b *backend) unknownPolicies(ctx context.Context, config *clientConfig, policies []string) ([]string, error) {
+	if config == nil {
+		return nil, nil
+	}
+	switch config.UnknownPolicyAction {
+	case unknownPolicyActionWarn, unknownPolicyActionReject:
+	default:
+		return nil, nil
+	}
+
+	policyLookup, ok := b.System().(logical.PolicyLookupSystemView)
+	if !ok {
+		return nil, fmt.Errorf("looking up policies is not supported by this mount")
+	}
+
+	var unknown []string
+	for _, policy := range policies {
+		exists, err := policyLookup.PolicyExists(ctx, policy)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("error looking up policy %q: {{err}}", policy), err)
+		}
+		if !exists {
+			unknown = append(unknown, policy)
+		}
+	}
+	return unknown, nil
+}
+
+// Default maximum number of entries of the bound_iam_principal_arn of a role,
+// used when the client configuration does not set max_bound_iam_principal_arns
+const defaultMaxBoundIamPrincipalARNs = 1000
+
+// maxBoundIamPrincipalARNs returns the maximum number of entries of the
+// bound_iam_principal_arn of a role
+func (b *backend) maxBoundIamPrincipalARNs(ctx context.Context, s logical.Storage) (int, error) {
+	config, err := b.lockedClientConfigEntry(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	if config == nil || config.MaxBoundIamPrincipalARNs == 0 {
+		return defaultMaxBoundIamPrincipalARNs, nil
+	}
+	return config.MaxBoundIamPrincipalARNs, nil
+}
+
+// STS endpoint to which iam logins are submitted when the client configuration
+// does not set sts_endpoint and the request was not signed for another region,
+// and the region it is in
+const (
+	defaultSTSEndpoint = "https://sts.amazonaws.com"
+	defaultSTSRegion   = "us-east-1"
+)
+
+// effectiveSTSEndpoint returns the STS endpoint to which iam logins signed
+// for the fallback region are submitted
+func (c *clientConfig) effectiveSTSEndpoint() string {
+	if c.STSEndpoint != "" {
+		return c.STSEndpoint
+	}
+	return defaultSTSEndpoint
+}
+
+// defaultResolveAWSUniqueIDs returns whether roles created without setting
+// resolve_aws_unique_ids resolve the ARNs they are bound to into unique IDs
+func (b *backend) defaultResolveAWSUniqueIDs(ctx context.Context, s logical.Storage) (bool, error) {
+	config, err := b.lockedClientConfigEntry(ctx, s)
+	if err != nil {
+		return false, err
+	}
+	return config == nil || !config.NoDefaultResolveAWSUniqueIDs, nil
+}
+
+// applyDefaultTTLs sets the TTL and max TTL of a role loaded for a login or a
+// renewal to the defaults of the client configuration, if the role leaves
+// them unset. The role is not updated in storage.
+func (b *backend) applyDefaultTTLs(ctx context.Context, s logical.Storage, roleEntry *awsRoleEntry) error {
+	config, err := b.lockedClientConfigEntry(ctx, s)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+	if roleEntry.TTL == 0 {
+		roleEntry.TTL = config.DefaultTTL
+	}
+	if roleEntry.MaxTTL == 0 {
+		roleEntry.MaxTTL = config.DefaultMaxTTL
+	}
+	return nil
+}
+
+// autoCreatePrincipalPatternRegex matches the principal patterns allowed for
+// auto-created roles, which must name the account and the type of principal
+// without wildcards, so that a pattern cannot cover every caller
+var autoCreatePrincipalPatternRegex = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:(user|role)/[^*]`)
+
+// ToResponseData returns the non-sensitive fields of the client configuration
+func (c *clientConfig) ToResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"access_key":                     c.AccessKey,
+		"endpoint":                       c.Endpoint,
+		"iam_endpoint":                   c.IAMEndpoint,
+		"sts_endpoint":                   c.STSEndpoint,
+		"iam_server_id_header_value":     c.IAMServerIdHeaderValues,
+		"max_retries":                    c.MaxRetries,
+		"allow_insecure_endpoints":       c.AllowInsecureEndpoints,
+		"max_request_body_size":          c.MaxRequestBodySize,
+		"max_cache_entries":              c.MaxCacheEntries,
+		"max_bound_iam_principal_arns":   c.MaxBoundIamPrincipalARNs,
+		"default_resolve_aws_unique_ids": !c.NoDefaultResolveAWSUniqueIDs,
+		"default_ttl":                    c.DefaultTTL / time.Second,
+		"default_max_ttl":                c.DefaultMaxTTL / time.Second,
+		"iam_request_max_age":            c.IAMRequestMaxAge / time.Second,
+		"organizations_endpoint":         c.OrganizationsEndpoint,
+		"organization_cache_ttl":         c.OrganizationCacheTTL / time.Second,
+		"require_signed_host_header":     c.RequireSignedHostHeader,
+		"max_signed_headers":             c.MaxSignedHeaders,
+		"max_caller_arn_length":          c.MaxCallerARNLength,
+		"require_form_content_type":      c.RequireFormContentType,
+		"correlation_header":             c.CorrelationHeader,
+		"issuer":                         c.Issuer,
+		"redact_arns_in_errors":          c.RedactARNsInErrors,
+		"allowed_sts_endpoints":          c.AllowedSTSEndpoints,
+		"denied_ec2_instance_ids":        c.DeniedEC2InstanceIDs,
+		"unknown_policy_action":          c.UnknownPolicyAction,
+		"lookup_failure_policy":          c.lookupFailurePolicy(),
+		"auto_create_roles":              c.AutoCreateRoles,
+		"auto_create_role_template":      c.AutoCreateRoleTemplate,
+		"auto_create_principal_pattern":  c.AutoCreatePrincipalPattern,
+		"role_name_pattern":              c.RoleNamePattern,
+		"reject_replays":                 c.RejectReplays,
+		"emit_login_events":              c.EmitLoginEvents,
+		"validate_caller_identity":       c.ValidateCallerIdentity,
+		"redact_login_event_arns":        c.RedactLoginEventARNs,
+		"global_instance_nonce":          c.GlobalInstanceNonce,
+	}
+}
+
+// validateEndpointScheme ensures that an endpoint override uses HTTPS. An
+// empty endpoint or one without a scheme is accepted, as the AWS SDK defaults
+// to HTTPS; plain HTTP is only accepted when allowInsecure is set.
+func validateEndpointScheme(endpoint string, allowInsecure bool) error {
+	if endpoint == "" {
+		return nil
+	}
+	parsedEndpoint, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("error parsing endpoint %q", endpoint)
+	}
+	switch strings.ToLower(parsedEndpoint.Scheme) {
+	case "", "https":
+		return nil
+	case "http":
+		if allowInsecure {
+			return nil
+		}
+		return fmt.Errorf("endpoint %q does not use https; set allow_insecure_endpoints to permit it", endpoint)
+	default:
+		return fmt.Errorf("endpoint %q has unsupported scheme %q", endpoint, parsedEndpoint.Scheme)
+	}
+}
+
+// validateRoleEndpoint ensures that an endpoint override of a role is an
+// absolute URL, without credentials, query or fragment, using an allowed
+// scheme. Unlike the endpoints of the client configuration, the scheme cannot
+// be omitted.
+func validateRoleEndpoint(endpoint string, allowInsecure bool) error {
+	if endpoint == "" {
+		return nil
+	}
+	parsedEndpoint, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("error parsing endpoint %q", endpoint)
+	}
+	if parsedEndpoint.Scheme == "" || parsedEndpoint.Host == "" {
+		return fmt.Errorf("endpoint %q is not an absolute URL", endpoint)
+	}
+	if parsedEndpoint.User != nil || parsedEndpoint.RawQuery != "" || parsedEndpoint.Fragment != "" {
+		return fmt.Errorf("endpoint %q must not hold credentials, a query or a fragment", endpoint)
+	}
+	return validateEndpointScheme(endpoint, allowInsecure)
 }
 
 const pathConfigClientHelpSyn = `
@@ -0,0 +1,201 @@
+package awsauth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	clientConfigHistoryPath = "config/client/history"
+
+	// Number of previous client configurations retained for rollback
+	clientConfigHistoryLimit = 10
+)
+
+func pathConfigClientHistory(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/client/history$",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathConfigClientHistoryRead,
+		},
+
+		HelpSynopsis:    pathConfigClientHistoryHelpSyn,
+		HelpDescription: pathConfigClientHistoryHelpDesc,
+	}
+}
+
+func pathConfigClientRollback(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/client/rollback$",
+		Fields: map[string]*framework.FieldSchema{
+			"version": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Default:     0,
+				Description: "Index of the previous client configuration to restore, as listed by 'config/client/history'. Defaults to 0, the most recent one.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathConfigClientRollbackUpdate,
+		},
+
+		HelpSynopsis:    pathConfigClientRollbackHelpSyn,
+		HelpDescription: pathConfigClientRollbackHelpDesc,
+	}
+}
+
+// nonLockedClientConfigHistory returns the previous client configurations,
+// most recent first. This method does not acquire the config lock.
+func (b *backend) nonLockedClientConfigHistory(ctx context.Context, s logical.Storage) ([]*clientConfig, error) {
+	entry, err := s.Get(ctx, clientConfigHistoryPath)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result clientConfigHistory
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+	for _, config := range result.Configs {
+		config.upgrade()
+	}
+	return result.Configs, nil
+}
+
+// nonLockedSetClientConfigHistory stores the given previous client
+// configurations. This method does not acquire the config lock.
+func (b *backend) nonLockedSetClientConfigHistory(ctx context.Context, s logical.Storage, configs []*clientConfig) error {
+	entry, err := logical.StorageEntryJSON(clientConfigHistoryPath, &clientConfigHistory{
+		Configs: configs,
+	})
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// nonLockedPushClientConfigHistory records a client configuration which is
+// being replaced or deleted, discarding the oldest entries beyond the limit.
+// This method does not acquire the config lock.
+func (b *backend) nonLockedPushClientConfigHistory(ctx context.Context, s logical.Storage, config *clientConfig) error {
+	if config == nil {
+		return fmt.Errorf("nil client config")
+	}
+
+	configs, err := b.nonLockedClientConfigHistory(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	configs = append([]*clientConfig{config}, configs...)
+	if len(configs) > clientConfigHistoryLimit {
+		configs = configs[:clientConfigHistoryLimit]
+	}
+
+	return b.nonLockedSetClientConfigHistory(ctx, s, configs)
+}
+
+// pathConfigClientHistoryRead lists the previous client configurations,
+// without their secret keys
+func (b *backend) pathConfigClientHistoryRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.configMutex.RLock()
+	defer b.configMutex.RUnlock()
+
+	configs, err := b.nonLockedClientConfigHistory(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]map[string]interface{}, 0, len(configs))
+	for i, config := range configs {
+		version := config.ToResponseData()
+		version["version"] = i
+		versions = append(versions, version)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"versions": versions,
+		},
+	}, nil
+}
+
+// pathConfigClientRollbackUpdate restores a previous client configuration.
+// The configuration being replaced is itself added to the history so that the
+// rollback can be undone.
+func (b *backend) pathConfigClientRollbackUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.configMutex.Lock()
+	defer b.configMutex.Unlock()
+
+	version := data.Get("version").(int)
+
+	configs, err := b.nonLockedClientConfigHistory(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if version < 0 || version >= len(configs) {
+		return logical.ErrorResponse(fmt.Sprintf("client config version %d not found; %d previous versions are available", version, len(configs))), nil
+	}
+
+	currentConfig, err := b.nonLockedClientConfigEntry(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	restoredConfig := configs[version]
+	configs = append(configs[:version], configs[version+1:]...)
+	if currentConfig != nil {
+		configs = append([]*clientConfig{currentConfig}, configs...)
+	}
+
+	entry, err := logical.StorageEntryJSON("config/client", restoredConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	if err := b.nonLockedSetClientConfigHistory(ctx, req.Storage, configs); err != nil {
+		return nil, err
+	}
+
+	b.flushCachedClients()
+	b.lookupCache.resize(restoredConfig.MaxCacheEntries)
+
+	return nil, nil
+}
+
+// clientConfigHistory holds the previous client configurations, most recent
+// first
+type clientConfigHistory struct {
+	Configs []*clientConfig `json:"configs"`
+}
+
+const pathConfigClientHistoryHelpSyn = `
+Lists the previous client configurations that can be rolled back to.
+`
+
+const pathConfigClientHistoryHelpDesc = `
+Each time the client configuration at 'config/client' is updated or deleted,
+the configuration being replaced is retained. Up to 10 previous configurations
+are kept, most recent first. Secret keys are not returned by this endpoint.
+`
+
+const pathConfigClientRollbackHelpSyn = `
+Restores a previous client configuration.
+`
+
+const pathConfigClientRollbackHelpDesc = `
+Replaces the client configuration at 'config/client' with the previous
+configuration at the given 'version' index, as listed by the
+'config/client/history' endpoint. The configuration being replaced is itself
+added to the history, so a rollback can be undone by rolling back again.
+`
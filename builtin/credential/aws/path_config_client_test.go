@@ -2,8 +2,11 @@ package awsauth
 
 import (
 	"context"
+	"reflect"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
 )
 
@@ -71,7 +74,7 @@ func TestBackend_pathConfigClient(t *testing.T) {
 	if resp == nil || resp.IsError() {
 		t.Fatal("failed to read the client config entry")
 	}
-	if resp.Data["iam_server_id_header_value"] != data["iam_server_id_header_value"] {
+	if !reflect.DeepEqual(resp.Data["iam_server_id_header_value"], []string{data["iam_server_id_header_value"].(string)}) {
 		t.Fatalf("expected iam_server_id_header_value: '%#v'; returned iam_server_id_header_value: '%#v'",
 			data["iam_server_id_header_value"], resp.Data["iam_server_id_header_value"])
 	}
@@ -104,8 +107,431 @@ func TestBackend_pathConfigClient(t *testing.T) {
 	if resp == nil || resp.IsError() {
 		t.Fatal("failed to read the client config entry")
 	}
-	if resp.Data["iam_server_id_header_value"] != data["iam_server_id_header_value"] {
+	if !reflect.DeepEqual(resp.Data["iam_server_id_header_value"], []string{data["iam_server_id_header_value"].(string)}) {
 		t.Fatalf("expected iam_server_id_header_value: '%#v'; returned iam_server_id_header_value: '%#v'",
 			data["iam_server_id_header_value"], resp.Data["iam_server_id_header_value"])
 	}
 }
+
+func TestBackend_pathConfigClientRollback(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig := func(op logical.Operation, data map[string]interface{}) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      "config/client",
+			Data:      data,
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp != nil && resp.IsError() {
+			t.Fatalf("failed to write the client config entry: %#v", resp)
+		}
+	}
+
+	writeConfig(logical.CreateOperation, map[string]interface{}{
+		"sts_endpoint": "https://sts.us-east-1.amazonaws.com",
+		"iam_endpoint": "https://iam.amazonaws.com",
+	})
+	writeConfig(logical.UpdateOperation, map[string]interface{}{
+		"sts_endpoint": "https://sts.bad-endpoint.example.com",
+	})
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/client/history",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatal("failed to read the client config history")
+	}
+	versions := resp.Data["versions"].([]map[string]interface{})
+	if len(versions) != 1 {
+		t.Fatalf("expected 1 previous client config version, got %d", len(versions))
+	}
+	if versions[0]["sts_endpoint"] != "https://sts.us-east-1.amazonaws.com" {
+		t.Fatalf("bad: previous sts_endpoint: %#v", versions[0]["sts_endpoint"])
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/client/rollback",
+		Data: map[string]interface{}{
+			"version": 1,
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatal("expected rollback to a non-existent version to fail")
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/client/rollback",
+		Data: map[string]interface{}{
+			"version": 0,
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatalf("failed to roll back the client config: %#v", resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/client",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatal("failed to read the client config entry")
+	}
+	if resp.Data["sts_endpoint"] != "https://sts.us-east-1.amazonaws.com" {
+		t.Fatalf("bad: expected rolled back sts_endpoint, got %#v", resp.Data["sts_endpoint"])
+	}
+	if resp.Data["iam_endpoint"] != "https://iam.amazonaws.com" {
+		t.Fatalf("bad: expected rolled back iam_endpoint, got %#v", resp.Data["iam_endpoint"])
+	}
+
+	// Previous configurations hold secret keys, so they are seal wrapped as
+	// the current one is
+	if !strutil.StrListContains(b.SpecialPaths().SealWrapStorage, clientConfigHistoryPath) {
+		t.Fatal("expected the client config history to be seal wrapped")
+	}
+}
+
+func TestBackend_pathConfigClient_insecureEndpoints(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string]interface{}{
+		"sts_endpoint": "http://localhost:8080",
+	}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/client",
+		Data:      data,
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatal("expected an http sts_endpoint to be rejected")
+	}
+
+	data["allow_insecure_endpoints"] = true
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/client",
+		Data:      data,
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatalf("expected an http sts_endpoint to be allowed with allow_insecure_endpoints: %#v", resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/client",
+		Data: map[string]interface{}{
+			"allow_insecure_endpoints": false,
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatal("expected unsetting allow_insecure_endpoints with an http sts_endpoint to be rejected")
+	}
+}
+
+func TestBackend_pathConfigClient_legacyInsecureEndpoints(t *testing.T) {
+	const principalARN = "arn:aws:iam::123456789012:user/Bob"
+	b, storage, cleanup := testIamLoginBackend(t, principalARN, nil)
+	defer cleanup()
+
+	// Store the configuration as a version which did not require https
+	// endpoints would have
+	clientConfig, err := b.lockedClientConfigEntry(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig.AllowInsecureEndpoints = false
+	entry, err := logical.StorageEntryJSON("config/client", clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Data:      testIamLoginData("iamrole"),
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("expected the http sts_endpoint of a legacy configuration to keep working: resp:%#v err:%v", resp, err)
+	}
+
+	// Unrelated updates of the configuration keep working too
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/client",
+		Data: map[string]interface{}{
+			"max_retries": 3,
+		},
+		Storage: storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to update the legacy configuration: resp:%#v err:%v", resp, err)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/client",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("failed to read the configuration: resp:%#v err:%v", resp, err)
+	}
+	if resp.Data["allow_insecure_endpoints"] != true {
+		t.Fatalf("bad: expected allow_insecure_endpoints to be set, got %#v", resp.Data["allow_insecure_endpoints"])
+	}
+}
+
+func TestBackend_pathConfigClient_updateTakesEffect(t *testing.T) {
+	const principalARN = "arn:aws:iam::123456789012:user/Bob"
+	b, storage, cleanup := testIamLoginBackend(t, principalARN, nil)
+	defer cleanup()
+
+	login := func() *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Data:      testIamLoginData("iamrole"),
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("failed to login: resp:%#v err:%v", resp, err)
+		}
+		return resp
+	}
+	updateConfig := func(data map[string]interface{}) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/client",
+			Data:      data,
+			Storage:   storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("failed to update client configuration: resp:%#v err:%v", resp, err)
+		}
+	}
+
+	if resp := login(); resp.Auth.Alias.Name != "AIDAEXAMPLE" {
+		t.Fatalf("bad: expected the login to be answered by the first STS server, got alias %q", resp.Auth.Alias.Name)
+	}
+
+	// The very next login uses the updated STS endpoint
+	otherSTS := testFakeSTSServer(principalARN, "AIDAOTHER", "123456789012")
+	defer otherSTS.Close()
+	updateConfig(map[string]interface{}{
+		"sts_endpoint": otherSTS.URL,
+	})
+	if resp := login(); resp.Auth.Alias.Name != "AIDAOTHER" {
+		t.Fatalf("bad: expected the login to be answered by the updated STS server, got alias %q", resp.Auth.Alias.Name)
+	}
+
+	// Updates of settings the cached clients are built with flush them
+	b.EC2ClientsMap.Add("us-east-1/", &ec2.EC2{})
+	b.defaultAWSAccountID = "123456789012"
+	updateConfig(map[string]interface{}{
+		"max_retries": 5,
+	})
+	if b.EC2ClientsMap.Len() != 0 || b.defaultAWSAccountID != "" {
+		t.Fatalf("bad: expected the cached clients to be flushed, got %d clients and default account %q", b.EC2ClientsMap.Len(), b.defaultAWSAccountID)
+	}
+}
+
+func TestBackend_pathConfigClient_readRedactsSecretKey(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string]interface{}{
+		"access_key":                 "AKIAEXAMPLE",
+		"secret_key":                 "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY",
+		"endpoint":                   "https://ec2.us-west-2.amazonaws.com",
+		"iam_endpoint":               "https://iam.example.com/path",
+		"iam_server_id_header_value": "vault.example.com",
+	}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/client",
+		Data:      data,
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to create the client config entry: resp:%#v err:%v", resp, err)
+	}
+
+	read := func() map[string]interface{} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config/client",
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("failed to read the client config entry: resp:%#v err:%v", resp, err)
+		}
+		return resp.Data
+	}
+
+	respData := read()
+	if secretKey, ok := respData["secret_key"]; ok {
+		t.Fatalf("bad: expected the secret key to be absent, got %#v", secretKey)
+	}
+	for _, field := range []string{"access_key", "endpoint", "iam_endpoint"} {
+		if respData[field] != data[field] {
+			t.Fatalf("bad: expected %s %q, got %#v", field, data[field], respData[field])
+		}
+	}
+	if !reflect.DeepEqual(respData["iam_server_id_header_value"], []string{"vault.example.com"}) {
+		t.Fatalf("bad: expected iam_server_id_header_value %q, got %#v", "vault.example.com", respData["iam_server_id_header_value"])
+	}
+	if respData["sts_endpoint"] != "" || respData["effective_sts_endpoint"] != defaultSTSEndpoint || respData["fallback_region"] != defaultSTSRegion {
+		t.Fatalf("bad: expected the default STS endpoint to be effective, got %#v", respData)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/client",
+		Data: map[string]interface{}{
+			"sts_endpoint": "https://sts.eu-west-1.amazonaws.com",
+		},
+		Storage: storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to update the client config entry: resp:%#v err:%v", resp, err)
+	}
+	respData = read()
+	if respData["sts_endpoint"] != "https://sts.eu-west-1.amazonaws.com" || respData["effective_sts_endpoint"] != "https://sts.eu-west-1.amazonaws.com" {
+		t.Fatalf("bad: expected the configured STS endpoint to be effective, got %#v", respData)
+	}
+	if _, ok := respData["secret_key"]; ok {
+		t.Fatalf("bad: expected the secret key to be absent after an update")
+	}
+}
+
+func TestBackend_pathConfigClient_iamServerIdHeaderValues(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Configurations stored before lists were accepted hold a single value
+	entry, err := logical.StorageEntryJSON("config/client", map[string]interface{}{
+		"iam_server_id_header_value": "vault-old.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, err := b.lockedClientConfigEntry(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(clientConfig.IAMServerIdHeaderValues, []string{"vault-old.example.com"}) {
+		t.Fatalf("bad: expected the stored value to be upgraded to a list, got %q", clientConfig.IAMServerIdHeaderValues)
+	}
+
+	for _, value := range []interface{}{
+		"vault-old.example.com,vault-new.example.com",
+		[]string{"vault-new.example.com", "vault-old.example.com", "vault-new.example.com"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/client",
+			Data: map[string]interface{}{
+				"iam_server_id_header_value": value,
+			},
+			Storage: storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("failed to update the client config entry: resp:%#v err:%v", resp, err)
+		}
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config/client",
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("failed to read the client config entry: resp:%#v err:%v", resp, err)
+		}
+		expected := []string{"vault-new.example.com", "vault-old.example.com"}
+		if !reflect.DeepEqual(resp.Data["iam_server_id_header_value"], expected) {
+			t.Fatalf("bad: expected iam_server_id_header_value %q for %#v, got %#v", expected, value, resp.Data["iam_server_id_header_value"])
+		}
+	}
+}
@@ -60,7 +60,7 @@ The Vault server must have permissions to assume this role.`,
 // Establishes dichotomy of request operation between CreateOperation and UpdateOperation.
 // Returning 'true' forces an UpdateOperation, CreateOperation otherwise.
 func (b *backend) pathConfigStsExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
-	accountID := data.Get("account_id").(string)
+	accountID := stsConfigAccountID(data)
 	if accountID == "" {
 		return false, fmt.Errorf("missing account_id")
 	}
@@ -153,9 +153,21 @@ func (b *backend) lockedAwsStsEntry(ctx context.Context, s logical.Storage, acco
 	return b.nonLockedAwsStsEntry(ctx, s, accountID)
 }
 
+// stsConfigAccountID returns the normalized account ID of an STS
+// configuration path. Account IDs which cannot be normalized are returned as
+// is, so that entries written before account IDs were validated remain
+// reachable.
+func stsConfigAccountID(data *framework.FieldData) string {
+	accountID := data.Get("account_id").(string)
+	if normalized, err := normalizeAccountID(accountID); err == nil {
+		return normalized
+	}
+	return accountID
+}
+
 // pathConfigStsRead is used to return information about an STS role/AWS accountID association
 func (b *backend) pathConfigStsRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	accountID := data.Get("account_id").(string)
+	accountID := stsConfigAccountID(data)
 	if accountID == "" {
 		return logical.ErrorResponse("missing account id"), nil
 	}
@@ -181,6 +193,10 @@ func (b *backend) pathConfigStsCreateUpdate(ctx context.Context, req *logical.Re
 	if accountID == "" {
 		return logical.ErrorResponse("missing AWS account ID"), nil
 	}
+	accountID, err := normalizeAccountID(accountID)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 
 	b.configMutex.Lock()
 	defer b.configMutex.Unlock()
@@ -219,7 +235,7 @@ func (b *backend) pathConfigStsDelete(ctx context.Context, req *logical.Request,
 	b.configMutex.Lock()
 	defer b.configMutex.Unlock()
 
-	accountID := data.Get("account_id").(string)
+	accountID := stsConfigAccountID(data)
 	if accountID == "" {
 		return logical.ErrorResponse("missing account id"), nil
 	}
@@ -3,6 +3,7 @@ package awsauth
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
@@ -27,6 +28,24 @@ expiration, before it is removed from the backend storage.`,
 				Default:     false,
 				Description: "If set to 'true', disables the periodic tidying of the 'identity-whitelist/<instance_id>' entries.",
 			},
+			"max_entries": &framework.FieldSchema{
+				Type:    framework.TypeInt,
+				Default: 0,
+				Description: `The maximum number of entries in the identity whitelist. When it is
+reached, the first logins of new instances are handled according to
+max_entries_action. When set, the first login of each new instance lists the
+whole whitelist, and with 'evict' a full whitelist also has every entry read,
+so these logins take time proportional to the number of entries. Defaults to
+0, meaning unlimited.`,
+			},
+			"max_entries_action": &framework.FieldSchema{
+				Type:    framework.TypeString,
+				Default: whitelistCapActionFail,
+				Description: `Action taken on the first login of a new instance when the identity
+whitelist holds max_entries entries: 'fail' rejects the login, and 'evict'
+removes the entry which expired first to make room for the new one, or rejects
+the login if no entry has expired. Defaults to 'fail'.`,
+			},
 		},
 
 		ExistenceCheck: b.pathConfigTidyIdentityWhitelistExistenceCheck,
@@ -100,6 +119,28 @@ func (b *backend) pathConfigTidyIdentityWhitelistCreateUpdate(ctx context.Contex
 		configEntry.DisablePeriodicTidy = data.Get("disable_periodic_tidy").(bool)
 	}
 
+	maxEntriesInt, ok := data.GetOk("max_entries")
+	if ok {
+		if maxEntriesInt.(int) < 0 {
+			return logical.ErrorResponse("max_entries cannot be negative"), nil
+		}
+		configEntry.MaxEntries = maxEntriesInt.(int)
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.MaxEntries = data.Get("max_entries").(int)
+	}
+
+	maxEntriesActionStr, ok := data.GetOk("max_entries_action")
+	if ok {
+		configEntry.MaxEntriesAction = strings.ToLower(maxEntriesActionStr.(string))
+	} else if req.Operation == logical.CreateOperation {
+		configEntry.MaxEntriesAction = data.Get("max_entries_action").(string)
+	}
+	switch configEntry.MaxEntriesAction {
+	case "", whitelistCapActionFail, whitelistCapActionEvict:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid max_entries_action %q; expected %q or %q", configEntry.MaxEntriesAction, whitelistCapActionFail, whitelistCapActionEvict)), nil
+	}
+
 	entry, err := logical.StorageEntryJSON(identityWhitelistConfigPath, configEntry)
 	if err != nil {
 		return nil, err
@@ -125,6 +166,8 @@ func (b *backend) pathConfigTidyIdentityWhitelistRead(ctx context.Context, req *
 		Data: map[string]interface{}{
 			"safety_buffer":         clientConfig.SafetyBuffer,
 			"disable_periodic_tidy": clientConfig.DisablePeriodicTidy,
+			"max_entries":           clientConfig.MaxEntries,
+			"max_entries_action":    clientConfig.maxEntriesAction(),
 		},
 	}, nil
 }
@@ -137,8 +180,26 @@ func (b *backend) pathConfigTidyIdentityWhitelistDelete(ctx context.Context, req
 }
 
 type tidyWhitelistIdentityConfig struct {
-	SafetyBuffer        int  `json:"safety_buffer"`
-	DisablePeriodicTidy bool `json:"disable_periodic_tidy"`
+	SafetyBuffer        int    `json:"safety_buffer"`
+	DisablePeriodicTidy bool   `json:"disable_periodic_tidy"`
+	MaxEntries          int    `json:"max_entries"`
+	MaxEntriesAction    string `json:"max_entries_action"`
+}
+
+// Actions taken on the first login of a new instance when the identity
+// whitelist is full
+const (
+	whitelistCapActionFail  = "fail"
+	whitelistCapActionEvict = "evict"
+)
+
+// maxEntriesAction returns the action taken when the identity whitelist is
+// full, which configurations stored before it existed leave empty
+func (c *tidyWhitelistIdentityConfig) maxEntriesAction() string {
+	if c.MaxEntriesAction == "" {
+		return whitelistCapActionFail
+	}
+	return c.MaxEntriesAction
 }
 
 const pathConfigTidyIdentityWhitelistHelpSyn = `
@@ -2,6 +2,9 @@ package awsauth
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/logical"
@@ -69,6 +72,10 @@ func whitelistIdentityEntry(ctx context.Context, s logical.Storage, instanceID s
 	return &result, nil
 }
 
+// errWhitelistReadOnly is returned when the identity whitelist cannot be
+// written because the storage is read-only, as it is on standby nodes
+var errWhitelistReadOnly = errors.New("cannot authenticate on standby: the identity whitelist is read-only on this node; retry the request against the active node")
+
 // Stores an instance ID and the information required to validate further login/renewal attempts from
 // the same instance ID.
 func setWhitelistIdentityEntry(ctx context.Context, s logical.Storage, instanceID string, identity *whitelistIdentity) error {
@@ -78,6 +85,9 @@ func setWhitelistIdentityEntry(ctx context.Context, s logical.Storage, instanceI
 	}
 
 	if err := s.Put(ctx, entry); err != nil {
+		if strings.Contains(err.Error(), logical.ErrReadOnly.Error()) {
+			return errWhitelistReadOnly
+		}
 		return err
 	}
 	return nil
@@ -121,6 +131,53 @@ func (b *backend) pathIdentityWhitelistRead(ctx context.Context, req *logical.Re
 	}, nil
 }
 
+// ensureWhitelistCapacity makes room for the entry of a new instance in the
+// identity whitelist when it holds the max_entries of its tidy configuration,
+// either by evicting the entry which expired first or by failing, according
+// to max_entries_action
+func (b *backend) ensureWhitelistCapacity(ctx context.Context, s logical.Storage) error {
+	config, err := b.lockedConfigTidyIdentities(ctx, s)
+	if err != nil {
+		return err
+	}
+	if config == nil || config.MaxEntries <= 0 {
+		return nil
+	}
+
+	identities, err := s.List(ctx, "whitelist/identity/")
+	if err != nil {
+		return err
+	}
+	if len(identities) < config.MaxEntries {
+		return nil
+	}
+	if config.maxEntriesAction() != whitelistCapActionEvict {
+		return fmt.Errorf("identity whitelist is full with %d entries", len(identities))
+	}
+
+	now := b.clock()
+	evictedInstanceID := ""
+	var evictedExpirationTime time.Time
+	for _, instanceID := range identities {
+		identity, err := whitelistIdentityEntry(ctx, s, instanceID)
+		if err != nil {
+			return err
+		}
+		if identity == nil || !now.After(identity.ExpirationTime) {
+			continue
+		}
+		if evictedInstanceID == "" || identity.ExpirationTime.Before(evictedExpirationTime) {
+			evictedInstanceID = instanceID
+			evictedExpirationTime = identity.ExpirationTime
+		}
+	}
+	if evictedInstanceID == "" {
+		return fmt.Errorf("identity whitelist is full with %d entries, none of which has expired", len(identities))
+	}
+	b.Logger().Info("evicting expired identity whitelist entry", "instance_id", evictedInstanceID, "expiration_time", evictedExpirationTime)
+	return s.Delete(ctx, "whitelist/identity/"+evictedInstanceID)
+}
+
 // Struct to represent each item in the identity whitelist.
 type whitelistIdentity struct {
 	Role                     string    `json:"role"`
@@ -130,6 +187,10 @@ type whitelistIdentity struct {
 	PendingTime              string    `json:"pending_time"`
 	ExpirationTime           time.Time `json:"expiration_time"`
 	LastUpdatedTime          time.Time `json:"last_updated_time"`
+	// Start of the current login_rate_window of the role, and the number of
+	// logins counted in it
+	LoginWindowStart time.Time `json:"login_window_start,omitempty"`
+	LoginWindowCount int       `json:"login_window_count,omitempty"`
 }
 
 const pathIdentityWhitelistSyn = `
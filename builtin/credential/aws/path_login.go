@@ -1,23 +1,33 @@
 package awsauth
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/subtle"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/fullsailor/pkcs7"
@@ -30,11 +40,22 @@ import (
 	"github.com/hashicorp/vault/logical/framework"
 )
 
+// Version of the set of metadata keys of the tokens issued by logins, recorded
+// in their metadata as 'metadata_schema_version'. It is to be bumped whenever
+// keys are renamed or removed, or change meaning, for either auth type.
+const loginMetadataSchemaVersion = "1"
+
+// errBackendNotConfigured is returned when the client configuration was never
+// written and the default AWS credential chain provides no credentials
+const errBackendNotConfigured = "backend not configured: no AWS credentials were found through the default credential chain; write them using the 'config/client' endpoint"
+
 const (
 	reauthenticationDisabledNonce = "reauthentication-disabled-nonce"
 	iamAuthType                   = "iam"
 	ec2AuthType                   = "ec2"
 	ec2EntityType                 = "ec2_instance"
+	serviceLinkedRolePath         = "aws-service-role"
+	serviceLinkedRoleNamePrefix   = "AWSServiceRoleFor"
 )
 
 func pathLogin(b *backend) *framework.Path {
@@ -74,7 +95,7 @@ significance.`,
 				Type: framework.TypeString,
 				Description: `HTTP method to use for the AWS request when auth_type is
 iam. This must match what has been signed in the
-presigned request. Currently, POST is the only supported value`,
+presigned request. Currently, POST is the only supported value, in any case`,
 			},
 
 			"iam_request_url": {
@@ -156,6 +177,17 @@ func (b *backend) instanceIamRoleARN(iamClient *iam.IAM, instanceProfileName str
 // validateInstance queries the status of the EC2 instance using AWS EC2 API
 // and checks if the instance is running and is healthy
 func (b *backend) validateInstance(ctx context.Context, s logical.Storage, instanceID, region, accountID string) (*ec2.Instance, error) {
+	reservation, err := b.validateInstanceReservation(ctx, s, instanceID, region, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return reservation.Instances[0], nil
+}
+
+// validateInstanceReservation is like validateInstance, but returns the
+// reservation holding the instance, which also carries the ID of the account
+// owning the instance
+func (b *backend) validateInstanceReservation(ctx context.Context, s logical.Storage, instanceID, region, accountID string) (*ec2.Reservation, error) {
 	// Create an EC2 client to pull the instance information
 	ec2Client, err := b.clientEC2(ctx, s, region, accountID)
 	if err != nil {
@@ -189,7 +221,42 @@ func (b *backend) validateInstance(ctx context.Context, s logical.Storage, insta
 	if *status.Reservations[0].Instances[0].State.Name != "running" {
 		return nil, fmt.Errorf("instance is not in 'running' state")
 	}
-	return status.Reservations[0].Instances[0], nil
+	return status.Reservations[0], nil
+}
+
+// describeInstanceExtendedAttributes queries the EC2 DescribeInstances API for
+// the given instance and decodes the attributes which are not modeled on
+// ec2.Instance by the vendored AWS SDK, such as the instance metadata options.
+func (b *backend) describeInstanceExtendedAttributes(ctx context.Context, s logical.Storage, instanceID, region, accountID string) (*instanceExtendedAttributes, error) {
+	ec2Client, err := b.clientEC2(ctx, s, region, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &describeInstancesExtendedOutput{}
+	req := ec2Client.NewRequest(&request.Operation{
+		Name:       "DescribeInstances",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}, &ec2.DescribeInstancesInput{
+		InstanceIds: []*string{
+			aws.String(instanceID),
+		},
+	}, output)
+	if err := req.Send(); err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("error fetching extended description for instance ID %q: {{err}}", instanceID), err)
+	}
+	if len(output.Reservations) == 0 {
+		return nil, fmt.Errorf("no reservations found in instance description")
+	}
+	if len(output.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("no instance details found in reservations")
+	}
+	attributes := output.Reservations[0].Instances[0]
+	if attributes.InstanceId == nil || *attributes.InstanceId != instanceID {
+		return nil, fmt.Errorf("expected instance ID not matching the instance ID in the instance description")
+	}
+	return attributes, nil
 }
 
 // validateMetadata matches the given client nonce and pending time with the
@@ -266,32 +333,50 @@ func (b *backend) verifyInstanceIdentitySignature(ctx context.Context, s logical
 		return nil, fmt.Errorf("missing SHA256 RSA signature of the instance identity document")
 	}
 
+	// The document is decoded before its signature is verified only to
+	// select the default certificate of the partition of its region
+	var identityDoc identityDocument
+	if err := jsonutil.DecodeJSON(identityBytes, &identityDoc); err != nil {
+		return nil, err
+	}
+
 	// Get the public certificates that are used to verify the signature.
 	// This returns a slice of certificates containing the default
-	// certificate and all the registered certificates via
+	// certificate of the partition and all the registered certificates via
 	// 'config/certificate/<cert_name>' endpoint, for verifying the RSA
 	// digest.
-	publicCerts, err := b.awsPublicCertificates(ctx, s, false)
+	publicCerts, registered, err := b.awsPublicCertificates(ctx, s, false, identityDoc.Region)
 	if err != nil {
 		return nil, err
 	}
-	if publicCerts == nil || len(publicCerts) == 0 {
-		return nil, fmt.Errorf("certificates to verify the signature are not found")
+	if len(publicCerts) == 0 {
+		return nil, errNoRegisteredCertificates
 	}
 
 	// Check if any of the certs registered at the backend can verify the
-	// signature
+	// signature. Certificates which cannot hold RSA keys, which may have been
+	// registered before their algorithm was checked, are skipped.
+	var unsupportedErr error
 	for _, cert := range publicCerts {
+		if err := checkIdentityCertificateAlgorithm(cert); err != nil {
+			unsupportedErr = err
+			continue
+		}
 		err := cert.CheckSignature(x509.SHA256WithRSA, identityBytes, signatureBytes)
 		if err == nil {
-			var identityDoc identityDocument
-			if decErr := jsonutil.DecodeJSON(identityBytes, &identityDoc); decErr != nil {
-				return nil, decErr
-			}
+			identityDoc.signingCert = cert
 			return &identityDoc, nil
 		}
 	}
 
+	// Only the default certificate was tried when no certificates are
+	// registered
+	if !registered {
+		return nil, errNoRegisteredCertificates
+	}
+	if unsupportedErr != nil {
+		return nil, errwrap.Wrapf("instance identity verification using SHA256 RSA signature is unsuccessful; some registered certificates cannot be used: {{err}}", unsupportedErr)
+	}
 	return nil, fmt.Errorf("instance identity verification using SHA256 RSA signature is unsuccessful")
 }
 
@@ -314,15 +399,28 @@ func (b *backend) parseIdentityDocument(ctx context.Context, s logical.Storage,
 		return nil, errwrap.Wrapf("failed to parse the BER encoded PKCS#7 signature: {{err}}", err)
 	}
 
+	// Check if the signature has content inside of it
+	if len(pkcs7Data.Content) == 0 {
+		return nil, fmt.Errorf("instance identity document could not be found in the signature")
+	}
+
+	// The document is decoded before its signature is verified only to
+	// select the default certificate of the partition of its region
+	var identityDoc identityDocument
+	if err := jsonutil.DecodeJSON(pkcs7Data.Content, &identityDoc); err != nil {
+		return nil, err
+	}
+
 	// Get the public certificates that are used to verify the signature.
 	// This returns a slice of certificates containing the default certificate
-	// and all the registered certificates via 'config/certificate/<cert_name>' endpoint
-	publicCerts, err := b.awsPublicCertificates(ctx, s, true)
+	// of the partition and all the registered certificates via
+	// 'config/certificate/<cert_name>' endpoint
+	publicCerts, registered, err := b.awsPublicCertificates(ctx, s, true, identityDoc.Region)
 	if err != nil {
 		return nil, err
 	}
-	if publicCerts == nil || len(publicCerts) == 0 {
-		return nil, fmt.Errorf("certificates to verify the signature are not found")
+	if len(publicCerts) == 0 {
+		return nil, errNoRegisteredCertificates
 	}
 
 	// Before calling Verify() on the PKCS#7 struct, set the certificates to be used
@@ -332,39 +430,323 @@ func (b *backend) parseIdentityDocument(ctx context.Context, s logical.Storage,
 	// Verify extracts the authenticated attributes in the PKCS#7 signature, and verifies
 	// the authenticity of the content using 'dsa.PublicKey' embedded in the public certificate.
 	if pkcs7Data.Verify() != nil {
+		// Only the default certificate was tried when no certificates are
+		// registered
+		if !registered {
+			return nil, errNoRegisteredCertificates
+		}
 		return nil, fmt.Errorf("failed to verify the signature")
 	}
-
-	// Check if the signature has content inside of it
-	if len(pkcs7Data.Content) == 0 {
-		return nil, fmt.Errorf("instance identity document could not be found in the signature")
-	}
-
-	var identityDoc identityDocument
-	if err := jsonutil.DecodeJSON(pkcs7Data.Content, &identityDoc); err != nil {
-		return nil, err
-	}
+	identityDoc.signingCert = pkcs7Data.GetOnlySigner()
 
 	return &identityDoc, nil
 }
 
-func (b *backend) pathLoginUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+// loginAuthType returns the auth type of a login from the values supplied
+// with it
+func loginAuthType(data *framework.FieldData) (string, error) {
 	anyEc2, allEc2 := hasValuesForEc2Auth(data)
 	anyIam, allIam := hasValuesForIamAuth(data)
+
 	switch {
 	case anyEc2 && anyIam:
-		return logical.ErrorResponse("supplied auth values for both ec2 and iam auth types"), nil
+		return "", fmt.Errorf("supplied auth values for both ec2 and iam auth types")
 	case anyEc2 && !allEc2:
-		return logical.ErrorResponse("supplied some of the auth values for the ec2 auth type but not all"), nil
+		return "", fmt.Errorf("supplied some of the auth values for the ec2 auth type but not all")
 	case anyEc2:
-		return b.pathLoginUpdateEc2(ctx, req, data)
+		return ec2AuthType, nil
 	case anyIam && !allIam:
-		return logical.ErrorResponse("supplied some of the auth values for the iam auth type but not all"), nil
+		return "", fmt.Errorf("supplied some of the auth values for the iam auth type but not all")
 	case anyIam:
-		return b.pathLoginUpdateIam(ctx, req, data)
+		return iamAuthType, nil
 	default:
-		return logical.ErrorResponse("didn't supply required authentication values"), nil
+		return "", fmt.Errorf("didn't supply required authentication values")
+	}
+}
+
+type autoCreatedRoleContextKey struct{}
+
+// autoCreatedRole holds the role auto-created by an iam login, until the login
+// succeeds and the role can be stored
+type autoCreatedRole struct {
+	name     string
+	template string
+	entry    *awsRoleEntry
+}
+
+// autoCreatedRoleFromContext returns the autoCreatedRole of the login
+// performed with the given context, or nil if auto-created roles are not to be
+// stored
+func autoCreatedRoleFromContext(ctx context.Context) *autoCreatedRole {
+	autoCreated, _ := ctx.Value(autoCreatedRoleContextKey{}).(*autoCreatedRole)
+	return autoCreated
+}
+
+func (b *backend) pathLoginUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.lockedClientConfigEntry(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	authType, err := loginAuthType(data)
+	if err != nil {
+		b.logLoginRejected(req, config, "", data.Get("role").(string), loginReasonInvalidRequest)
+		return loginRejected(loginReasonInvalidRequest, err.Error()), nil
+	}
+
+	// A role auto-created by an iam login is only stored once the login
+	// passed every check
+	autoCreated := &autoCreatedRole{}
+	ctx = context.WithValue(ctx, autoCreatedRoleContextKey{}, autoCreated)
+
+	var resp *logical.Response
+	var roleName string
+	switch authType {
+	case ec2AuthType:
+		resp, err = b.pathLoginUpdateEc2(ctx, req, data)
+		if resp != nil && resp.Auth != nil {
+			roleName = resp.Auth.Metadata["role"]
+		}
+	case iamAuthType:
+		resp, err = b.pathLoginUpdateIam(ctx, req, data)
+		if resp != nil && resp.Auth != nil {
+			roleName, _ = resp.Auth.InternalData["role_name"].(string)
+		}
+	}
+	if isNotConfiguredError(err) {
+		b.logLoginRejected(req, config, authType, data.Get("role").(string), loginReasonNotConfigured)
+		return loginRejected(loginReasonNotConfigured, errBackendNotConfigured), nil
+	}
+	if err == nil && resp != nil && resp.IsError() {
+		if err := b.redactErrorResponseARNs(ctx, req.Storage, resp); err != nil {
+			return nil, err
+		}
+		b.logLoginRejected(req, config, authType, data.Get("role").(string), loginResponseReason(resp))
+	}
+	if err != nil || resp == nil || resp.Auth == nil || req.Operation == logical.AliasLookaheadOperation {
+		return resp, err
+	}
+
+	unknownPolicies, err := b.unknownPolicies(ctx, config, resp.Auth.Policies)
+	if err != nil {
+		return nil, err
+	}
+	if len(unknownPolicies) > 0 {
+		if config != nil && config.UnknownPolicyAction == unknownPolicyActionReject {
+			b.logLoginRejected(req, config, authType, roleName, loginReasonUnknownPolicies)
+			return loginRejected(loginReasonUnknownPolicies, fmt.Sprintf("policies %q of the role do not exist", unknownPolicies)), nil
+		}
+		resp.AddWarning(fmt.Sprintf("policies %q of the role do not exist", unknownPolicies))
+	}
+
+	if autoCreated.entry != nil {
+		if err := b.storeAutoCreatedIamRole(ctx, req.Storage, autoCreated); err != nil {
+			return nil, err
+		}
+	}
+
+	// Stamp every successful login with a unique ID, which is also logged,
+	// so that the issued token can be correlated with external logs
+	loginID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to generate login ID: {{err}}", err)
+	}
+	if resp.Auth.Metadata == nil {
+		resp.Auth.Metadata = make(map[string]string)
+	}
+	resp.Auth.Metadata["login_id"] = loginID
+	resp.Auth.Metadata["metadata_schema_version"] = loginMetadataSchemaVersion
+	if config != nil && config.Issuer != "" {
+		resp.Auth.Metadata["issuer"] = config.Issuer
+	}
+
+	// Echo the correlation header of the request, so that the login can be
+	// traced across systems
+	logArgs := []interface{}{"login_id", loginID}
+	if config != nil {
+		if correlationID := requestHeaderValue(req.Headers, config.CorrelationHeader); correlationID != "" {
+			if resp.Data == nil {
+				resp.Data = make(map[string]interface{})
+			}
+			resp.Data["correlation_id"] = correlationID
+			logArgs = append(logArgs, "correlation_id", correlationID)
+		}
+	}
+	logArgs = append(logArgs, "alias", resp.Auth.Alias.Name, "account_id", resp.Auth.Metadata["account_id"])
+	b.Logger().Info("login succeeded", logArgs...)
+
+	if config != nil && config.EmitLoginEvents {
+		event := &loginEvent{
+			Type:         loginEventType,
+			LoginID:      loginID,
+			Role:         roleName,
+			AuthType:     authType,
+			CanonicalARN: resp.Auth.Metadata["canonical_arn"],
+			Timestamp:    b.clock().UTC(),
+		}
+		if config.RedactLoginEventARNs && event.CanonicalARN != "" {
+			event.CanonicalARN = redactARN(event.CanonicalARN)
+		}
+		b.loginEventFunc(ctx, event)
+	}
+
+	return resp, nil
+}
+
+// isNotConfiguredError returns whether err comes from an AWS client which
+// found no credentials, neither in a client configuration, which was never
+// written, nor through the default credential chain
+func isNotConfiguredError(err error) bool {
+	return err != nil && errwrap.Contains(err, errBackendNotConfigured)
+}
+
+// logLoginRejected logs the reason a login was rejected for. The error
+// message is left out: it may quote ARNs which are redacted from it, or what
+// STS answered, which can include the canonical form of the signed request.
+func (b *backend) logLoginRejected(req *logical.Request, config *clientConfig, authType, roleName string, reason loginFailureReason) {
+	logArgs := []interface{}{"auth_type", authType, "role", roleName, "reason", string(reason)}
+	if config != nil {
+		if correlationID := requestHeaderValue(req.Headers, config.CorrelationHeader); correlationID != "" {
+			logArgs = append(logArgs, "correlation_id", correlationID)
+		}
+	}
+	b.Logger().Info("login rejected", logArgs...)
+}
+
+// Type of the events emitted on successful logins
+const loginEventType = "auth.aws.login"
+
+// loginEvent describes a successful login, for consumption by external
+// systems. EC2 logins have no canonical ARN.
+type loginEvent struct {
+	Type         string    `json:"type"`
+	LoginID      string    `json:"login_id"`
+	Role         string    `json:"role"`
+	AuthType     string    `json:"auth_type"`
+	CanonicalARN string    `json:"canonical_arn,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// logLoginEvent emits the login event as a JSON document in the server log,
+// where it can be collected along with the other logs of Vault
+func (b *backend) logLoginEvent(ctx context.Context, event *loginEvent) {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		b.Logger().Error("failed to encode login event", "login_id", event.LoginID, "error", err)
+		return
+	}
+	b.Logger().Named("events").Info("login event", "event", string(eventJSON))
+}
+
+// requestHeaderValue returns the first value of the named header, whose name
+// is matched case-insensitively, or "" if the header is not set
+func requestHeaderValue(headers map[string][]string, name string) string {
+	if name == "" {
+		return ""
+	}
+	for headerName, values := range headers {
+		if strings.EqualFold(headerName, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// instanceDenied returns whether the instance is listed in the
+// denied_ec2_instance_ids of the client configuration
+func (b *backend) instanceDenied(ctx context.Context, s logical.Storage, instanceID string) (bool, error) {
+	config, err := b.lockedClientConfigEntry(ctx, s)
+	if err != nil {
+		return false, err
+	}
+	if config == nil {
+		return false, nil
+	}
+	return strutil.StrListContains(config.DeniedEC2InstanceIDs, strings.ToLower(instanceID)), nil
+}
+
+// verifyGlobalNonce ensures that an instance which was whitelisted on login to
+// another role presents the same nonce, when global_instance_nonce is set in
+// the client configuration. Otherwise a role allowing instance migration
+// accepts any nonce from an instance with a newer pending time.
+func (b *backend) verifyGlobalNonce(ctx context.Context, s logical.Storage, clientNonce, roleName string, storedIdentity *whitelistIdentity) error {
+	if storedIdentity.Role == roleName || storedIdentity.DisallowReauthentication {
+		return nil
+	}
+	config, err := b.lockedClientConfigEntry(ctx, s)
+	if err != nil {
+		return err
+	}
+	if config == nil || !config.GlobalInstanceNonce {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(clientNonce), []byte(storedIdentity.ClientNonce)) != 1 {
+		return fmt.Errorf("client nonce mismatch with the nonce presented on login to another role")
+	}
+	return nil
+}
+
+// imageOwner queries the EC2 DescribeImages API for the account ID of the
+// owner of the AMI
+func (b *backend) imageOwner(ctx context.Context, s logical.Storage, imageID, region, accountID string) (string, error) {
+	ec2Client, err := b.clientEC2(ctx, s, region, accountID)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := ec2Client.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []*string{
+			aws.String(imageID),
+		},
+	})
+	if err != nil {
+		return "", errwrap.Wrapf(fmt.Sprintf("error fetching description for AMI ID %q: {{err}}", imageID), err)
+	}
+	if len(output.Images) == 0 {
+		return "", fmt.Errorf("no image details found for AMI ID %q", imageID)
+	}
+	image := output.Images[0]
+	if image.ImageId == nil || *image.ImageId != imageID {
+		return "", fmt.Errorf("expected AMI ID not matching the AMI ID in the image description")
+	}
+	if image.OwnerId == nil {
+		return "", fmt.Errorf("owner of AMI ID %q is not set in the image description", imageID)
+	}
+	return *image.OwnerId, nil
+}
+
+// arnRegex matches the ARNs quoted in error messages
+var arnRegex = regexp.MustCompile(`arn:aws[a-z-]*:[a-z0-9-]*:[a-z0-9-]*:[0-9]*:[^\s"']+`)
+
+// redactErrorResponseARNs replaces the ARNs in the error message of the
+// response with a hash of each ARN, if the client configuration asks for it.
+// The full ARNs are logged at debug level, along with their hashes, so that
+// operators can still tell which principal a failed login came from.
+func (b *backend) redactErrorResponseARNs(ctx context.Context, s logical.Storage, resp *logical.Response) error {
+	config, err := b.lockedClientConfigEntry(ctx, s)
+	if err != nil {
+		return err
+	}
+	if config == nil || !config.RedactARNsInErrors {
+		return nil
+	}
+
+	errorMessage, ok := resp.Data["error"].(string)
+	if !ok {
+		return nil
 	}
+	resp.Data["error"] = arnRegex.ReplaceAllStringFunc(errorMessage, func(arn string) string {
+		redacted := redactARN(arn)
+		b.Logger().Debug("redacted ARN in login error", "arn", arn, "redacted", redacted)
+		return redacted
+	})
+	return nil
+}
+
+// redactARN returns a stable replacement for the ARN which does not reveal it
+func redactARN(arn string) string {
+	sum := sha256.Sum256([]byte(arn))
+	return "arn-sha256:" + hex.EncodeToString(sum[:8])
 }
 
 // Returns whether the EC2 instance meets the requirements of the particular
@@ -424,6 +806,19 @@ func (b *backend) verifyInstanceMeetsRoleRequirements(ctx context.Context,
 		}
 	}
 
+	// Validate the security groups if corresponding bound was set on the role
+	if len(roleEntry.BoundSecurityGroupIDs) > 0 {
+		var groupIDs []string
+		for _, group := range instance.SecurityGroups {
+			if group != nil && group.GroupId != nil {
+				groupIDs = append(groupIDs, *group.GroupId)
+			}
+		}
+		if !matchesSecurityGroups(roleEntry.BoundSecurityGroupIDs, roleEntry.BoundSecurityGroupMatch, groupIDs) {
+			return fmt.Errorf("security groups %q do not satisfy the constraint on role %q", groupIDs, roleName), nil
+		}
+	}
+
 	// Validate the VpcID if corresponding bound was set on the role
 	if len(roleEntry.BoundVpcIDs) > 0 {
 		if instance.VpcId == nil {
@@ -434,6 +829,78 @@ func (b *backend) verifyInstanceMeetsRoleRequirements(ctx context.Context,
 		}
 	}
 
+	// Reject instances running without an IAM instance profile if the role
+	// requires one, regardless of any instance profile or role ARN binds
+	if roleEntry.RequireInstanceProfile && (instance.IamInstanceProfile == nil || instance.IamInstanceProfile.Arn == nil || *instance.IamInstanceProfile.Arn == "") {
+		return fmt.Errorf("instance %q has no IAM instance profile attached, which is required by role %q", *instance.InstanceId, roleName), nil
+	}
+
+	// Validate the detailed monitoring state if corresponding bound was set
+	// on the role
+	if roleEntry.BoundMonitoringState != "" {
+		if instance.Monitoring == nil || instance.Monitoring.State == nil {
+			return nil, fmt.Errorf("monitoring state in the instance description is nil")
+		}
+		if *instance.Monitoring.State != roleEntry.BoundMonitoringState {
+			return fmt.Errorf("monitoring state %q does not satisfy the constraint on role %q", *instance.Monitoring.State, roleName), nil
+		}
+	}
+
+	// Validate the placement tenancy if corresponding bound was set on the
+	// role
+	if roleEntry.BoundTenancy != "" {
+		if instance.Placement == nil || instance.Placement.Tenancy == nil {
+			return nil, fmt.Errorf("tenancy in the instance description is nil")
+		}
+		if *instance.Placement.Tenancy != roleEntry.BoundTenancy {
+			return fmt.Errorf("tenancy %q does not satisfy the constraint on role %q", *instance.Placement.Tenancy, roleName), nil
+		}
+	}
+
+	// Validate the EBS optimization flag if corresponding bound was set on
+	// the role
+	if roleEntry.BoundEBSOptimized != "" {
+		if instance.EbsOptimized == nil {
+			return nil, fmt.Errorf("EBS optimization flag in the instance description is nil")
+		}
+		if strconv.FormatBool(*instance.EbsOptimized) != roleEntry.BoundEBSOptimized {
+			return fmt.Errorf("EBS optimization flag %t does not satisfy the constraint on role %q", *instance.EbsOptimized, roleName), nil
+		}
+	}
+
+	// Validate the private DNS name if corresponding bound was set on the role
+	if roleEntry.BoundPrivateDNSPattern != "" {
+		re, err := compilePrivateDNSPattern(roleEntry.BoundPrivateDNSPattern)
+		if err != nil {
+			return nil, errwrap.Wrapf("invalid bound_private_dns_pattern: {{err}}", err)
+		}
+		privateDNSName := aws.StringValue(instance.PrivateDnsName)
+		if privateDNSName == "" {
+			return fmt.Errorf("instance %q has no private DNS name, which is required by role %q", *instance.InstanceId, roleName), nil
+		}
+		if !re.MatchString(privateDNSName) {
+			return fmt.Errorf("private DNS name %q does not satisfy the constraint on role %q", privateDNSName, roleName), nil
+		}
+	}
+
+	// Validate the placement group if corresponding bound was set on the role
+	if len(roleEntry.BoundPlacementGroups) > 0 {
+		if instance.Placement == nil || aws.StringValue(instance.Placement.GroupName) == "" {
+			return fmt.Errorf("instance %q is not in a placement group, which is required by role %q", *instance.InstanceId, roleName), nil
+		}
+		if !strutil.StrListContains(roleEntry.BoundPlacementGroups, *instance.Placement.GroupName) {
+			return fmt.Errorf("placement group %q does not satisfy the constraint on role %q", *instance.Placement.GroupName, roleName), nil
+		}
+	}
+
+	// Validate the instance type if corresponding bound was set on the role
+	if len(roleEntry.BoundInstanceTypes) > 0 {
+		instanceType := aws.StringValue(instance.InstanceType)
+		if !instanceTypeMatches(roleEntry.BoundInstanceTypes, instanceType) {
+			return fmt.Errorf("instance type %q does not satisfy the constraint on role %q", instanceType, roleName), nil
+		}
+	}
+
 	// Check if the IAM instance profile ARN of the instance trying to
 	// login, matches the IAM instance profile ARN specified as a constraint
 	// on the role
@@ -530,14 +997,123 @@ func (b *backend) verifyInstanceMeetsRoleRequirements(ctx context.Context,
 		}
 	}
 
+	// The metadata options and the capacity reservation are not part of the
+	// instance description returned by the SDK, so they are fetched separately
+	var attributes *instanceExtendedAttributes
+	if roleEntry.RequireIMDSv2 || len(roleEntry.BoundCapacityReservationIDs) > 0 {
+		var err error
+		attributes, err = b.describeInstanceExtendedAttributesFunc(ctx, s, *instance.InstanceId, identityDoc.Region, identityDoc.AccountID)
+		if err != nil {
+			return nil, errwrap.Wrapf("unable to fetch extended instance description: {{err}}", err)
+		}
+	}
+
+	// Check if the instance requires session tokens (IMDSv2) to access its
+	// instance metadata service
+	if roleEntry.RequireIMDSv2 {
+		if attributes == nil || attributes.MetadataOptions == nil || attributes.MetadataOptions.HttpTokens == nil {
+			return fmt.Errorf("instance %q does not report its metadata options; IMDSv2 is required by role %q", *instance.InstanceId, roleName), nil
+		}
+		if *attributes.MetadataOptions.HttpTokens != "required" {
+			return fmt.Errorf("instance %q does not enforce IMDSv2 as required by role %q", *instance.InstanceId, roleName), nil
+		}
+	}
+
+	// Verify that the instance runs in one of the capacity reservations bound
+	// on the role
+	if len(roleEntry.BoundCapacityReservationIDs) > 0 {
+		if attributes == nil || attributes.CapacityReservationId == nil || *attributes.CapacityReservationId == "" {
+			return fmt.Errorf("instance %q is not part of a capacity reservation, which is required by role %q", *instance.InstanceId, roleName), nil
+		}
+		if !strutil.StrListContains(roleEntry.BoundCapacityReservationIDs, *attributes.CapacityReservationId) {
+			return fmt.Errorf("capacity reservation %q does not belong to the role %q", *attributes.CapacityReservationId, roleName), nil
+		}
+	}
+
+	// Check if the AMI of the instance is owned by the account of the instance
+	if roleEntry.RequireSelfOwnedAMI {
+		if instance.ImageId == nil || *instance.ImageId == "" {
+			return nil, fmt.Errorf("AMI ID in the instance description is empty")
+		}
+		imageOwner, err := b.imageOwnerFunc(ctx, s, *instance.ImageId, identityDoc.Region, identityDoc.AccountID)
+		if err != nil {
+			return nil, errwrap.Wrapf("unable to fetch AMI owner: {{err}}", err)
+		}
+		if imageOwner != identityDoc.AccountID {
+			return fmt.Errorf("AMI %q of instance %q is not owned by account %q as required by role %q", *instance.ImageId, *instance.InstanceId, identityDoc.AccountID, roleName), nil
+		}
+	}
+
 	return nil, nil
 }
 
+// matchesSecurityGroups returns whether the security groups of an instance
+// include all the bound groups if match is 'all', or one of them otherwise
+func matchesSecurityGroups(boundGroupIDs []string, match string, groupIDs []string) bool {
+	for _, boundGroupID := range boundGroupIDs {
+		contained := strutil.StrListContains(groupIDs, boundGroupID)
+		switch {
+		case contained && match != "all":
+			return true
+		case !contained && match == "all":
+			return false
+		}
+	}
+	return match == "all"
+}
+
+// validateReservationOwner checks the owner of the reservation holding the
+// instance against the bound_reservation_owner_id constraint of the role
+func validateReservationOwner(reservation *ec2.Reservation, roleEntry *awsRoleEntry, roleName string) error {
+	if len(roleEntry.BoundReservationOwnerIDs) == 0 {
+		return nil
+	}
+	if reservation.OwnerId == nil || !strutil.StrListContains(roleEntry.BoundReservationOwnerIDs, *reservation.OwnerId) {
+		return fmt.Errorf("reservation owner ID %q does not satisfy the constraint on role %q", aws.StringValue(reservation.OwnerId), roleName)
+	}
+	return nil
+}
+
+// crossCheckInstance ensures that the identity document is consistent with the
+// current description of the instance, by comparing the instance ID, the
+// account ID and the AMI ID of both.
+func crossCheckInstance(identityDoc *identityDocument, reservation *ec2.Reservation) error {
+	if reservation == nil || len(reservation.Instances) == 0 {
+		return fmt.Errorf("no instance details found in reservation")
+	}
+	instance := reservation.Instances[0]
+
+	if instance.InstanceId == nil || *instance.InstanceId != identityDoc.InstanceID {
+		return fmt.Errorf("instance ID %q does not match the instance description", identityDoc.InstanceID)
+	}
+	if reservation.OwnerId == nil || *reservation.OwnerId != identityDoc.AccountID {
+		return fmt.Errorf("account ID %q does not match the owner of the instance", identityDoc.AccountID)
+	}
+	if instance.ImageId == nil || *instance.ImageId != identityDoc.AmiID {
+		return fmt.Errorf("AMI ID %q does not match the instance description", identityDoc.AmiID)
+	}
+	return nil
+}
+
+// validateIdentityDocumentAccount ensures that the account ID in the identity
+// document satisfies the bound_account_id constraint of the role
+func validateIdentityDocumentAccount(identityDoc *identityDocument, roleEntry *awsRoleEntry, roleName string) error {
+	if len(roleEntry.BoundAccountIDs) == 0 {
+		return nil
+	}
+	if !strutil.StrListContains(roleEntry.BoundAccountIDs, identityDoc.AccountID) {
+		return fmt.Errorf("account ID %q of the instance identity document is not in the bound_account_id of role %q", identityDoc.AccountID, roleName)
+	}
+	return nil
+}
+
 // pathLoginUpdateEc2 is used to create a Vault token by the EC2 instances
 // by providing the pkcs7 signature of the instance identity document
 // and a client created nonce. Client nonce is optional if 'disallow_reauthentication'
 // option is enabled on the registered role.
 func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	validation := loginValidationFromContext(ctx)
+
 	identityDocB64 := data.Get("identity").(string)
 	var identityDocBytes []byte
 	var err error
@@ -572,6 +1148,9 @@ func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request,
 	var identityDocParsed *identityDocument
 	if pkcs7B64 != "" {
 		identityDocParsed, err = b.parseIdentityDocument(ctx, req.Storage, pkcs7B64)
+		if err == errNoRegisteredCertificates {
+			return logical.ErrorResponse(err.Error()), nil
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -580,6 +1159,9 @@ func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request,
 		}
 	} else {
 		identityDocParsed, err = b.verifyInstanceIdentitySignature(ctx, req.Storage, identityDocBytes, signatureBytes)
+		if err == errNoRegisteredCertificates {
+			return logical.ErrorResponse(err.Error()), nil
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -599,12 +1181,23 @@ func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request,
 		}, nil
 	}
 
+	// Denied instances are rejected before anything else is looked up
+	denied, err := b.instanceDenied(ctx, req.Storage, identityDocParsed.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	if denied {
+		return logical.ErrorResponse(fmt.Sprintf("instance %q is denied by the client configuration", identityDocParsed.InstanceID)), nil
+	}
+	validation.pass(loginCheckIdentityDoc)
+
 	roleName := data.Get("role").(string)
 
 	// If roleName is not supplied, a role in the name of the instance's AMI ID will be looked for
 	if roleName == "" {
 		roleName = identityDocParsed.AmiID
 	}
+	validation.resolveRole(roleName)
 
 	// Get the entry for the role used by the instance
 	roleEntry, err := b.lockedAWSRole(ctx, req.Storage, roleName)
@@ -614,18 +1207,52 @@ func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request,
 	if roleEntry == nil {
 		return logical.ErrorResponse(fmt.Sprintf("entry for role %q not found", roleName)), nil
 	}
+	if err := b.applyDefaultTTLs(ctx, req.Storage, roleEntry); err != nil {
+		return nil, err
+	}
 
 	if roleEntry.AuthType != ec2AuthType {
 		return logical.ErrorResponse(fmt.Sprintf("auth method ec2 not allowed for role %s", roleName)), nil
 	}
+	validation.pass(loginCheckRole)
+
+	if err := validateLoginWindow(roleEntry, roleName, b.clock()); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := validateBoundConstraintCount(roleEntry, roleName); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Check the account of the identity document before making any AWS API
+	// calls, which would fail with a less specific error if no client is
+	// configured for a disallowed account
+	if err := validateIdentityDocumentAccount(identityDocParsed, roleEntry, roleName); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 
 	// Validate the instance ID by making a call to AWS EC2 DescribeInstances API
 	// and fetching the instance description. Validation succeeds only if the
 	// instance is in 'running' state.
-	instance, err := b.validateInstance(ctx, req.Storage, identityDocParsed.InstanceID, identityDocParsed.Region, identityDocParsed.AccountID)
+	reservation, err := b.validateInstanceReservation(ctx, req.Storage, identityDocParsed.InstanceID, identityDocParsed.Region, identityDocParsed.AccountID)
+	if isNotConfiguredError(err) {
+		return loginRejected(loginReasonNotConfigured, errBackendNotConfigured), nil
+	}
 	if err != nil {
 		return logical.ErrorResponse(fmt.Sprintf("failed to verify instance ID: %v", err)), nil
 	}
+	instance := reservation.Instances[0]
+	validation.pass(loginCheckInstance)
+
+	if roleEntry.CrossCheckInstance {
+		if err := crossCheckInstance(identityDocParsed, reservation); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("instance identity document is inconsistent with the instance description: %v", err)), nil
+		}
+	}
+
+	if err := validateReservationOwner(reservation, roleEntry, roleName); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("Error validating instance: %v", err)), nil
+	}
 
 	// Verify that the `Region` of the instance trying to login matches the
 	// `Region` specified as a constraint on role
@@ -641,6 +1268,21 @@ func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request,
 		return logical.ErrorResponse(fmt.Sprintf("Error validating instance: %v", validationError)), nil
 	}
 
+	team := ""
+	if roleEntry.TeamTagKey != "" {
+		team, err = teamTagValue(instanceTags(instance), roleEntry.TeamTagKey)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("instance %q: %v", identityDocParsed.InstanceID, err)), nil
+		}
+	}
+
+	var warnings []string
+	if roleEntry.RequireManagementAccount {
+		if err := b.verifyManagementAccount(ctx, req.Storage, identityDocParsed.Region, identityDocParsed.AccountID, &warnings); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("error validating account of instance %q: %v", identityDocParsed.InstanceID, err)), nil
+		}
+	}
+
 	// Get the entry from the identity whitelist, if there is one
 	storedIdentity, err := whitelistIdentityEntry(ctx, req.Storage, identityDocParsed.InstanceID)
 	if err != nil {
@@ -676,6 +1318,10 @@ func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request,
 
 	// This is NOT a first login attempt from the client
 	if storedIdentity != nil {
+		if err := b.verifyGlobalNonce(ctx, req.Storage, clientNonce, roleName, storedIdentity); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
 		// Check if the client nonce match the cached nonce and if the pending time
 		// of the identity document is not before the pending time of the document
 		// with which previous login was made. If 'allow_instance_migration' is
@@ -762,9 +1408,28 @@ func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request,
 		}
 	}
 
+	// Cap the max TTL to the remaining validity of the certificate which
+	// verified the identity document, if the role asks for it. The cap is
+	// also set as the explicit max TTL of the token, so that neither a
+	// period nor renewals extend the token past the certificate expiry.
+	var certificateMaxTTL time.Duration
+	if roleEntry.CapTTLToCertificateExpiry {
+		certificateMaxTTL, err = b.certificateRemainingValidity(identityDocParsed.signingCert)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if certificateMaxTTL < shortestMaxTTL {
+			shortestMaxTTL = certificateMaxTTL
+		}
+	}
+
 	// Save the login attempt in the identity whitelist
-	currentTime := time.Now()
+	currentTime := b.clock()
 	if storedIdentity == nil {
+		if err := b.ensureWhitelistCapacity(ctx, req.Storage); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("unable to whitelist instance %q: %v", identityDocParsed.InstanceID, err)), nil
+		}
+
 		// Role, ClientNonce and CreationTime of the identity entry,
 		// once set, should never change.
 		storedIdentity = &whitelistIdentity{
@@ -774,6 +1439,10 @@ func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request,
 		}
 	}
 
+	if err := countInstanceLogin(storedIdentity, roleEntry, currentTime); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("instance %q: %v", identityDocParsed.InstanceID, err)), nil
+	}
+
 	// DisallowReauthentication, PendingTime, LastUpdatedTime and
 	// ExpirationTime may change.
 	storedIdentity.LastUpdatedTime = currentTime
@@ -792,12 +1461,15 @@ func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request,
 	}
 
 	if err = setWhitelistIdentityEntry(ctx, req.Storage, identityDocParsed.InstanceID, storedIdentity); err != nil {
+		if err == errWhitelistReadOnly {
+			return logical.ErrorResponse(err.Error()), nil
+		}
 		return nil, err
 	}
 
 	resp := &logical.Response{
 		Auth: &logical.Auth{
-			Period:   roleEntry.Period,
+			Period:   renewalPeriod(roleEntry, 0),
 			Policies: policies,
 			Metadata: map[string]string{
 				"instance_id":      identityDocParsed.InstanceID,
@@ -806,6 +1478,7 @@ func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request,
 				"role_tag_max_ttl": rTagMaxTTL.String(),
 				"role":             roleName,
 				"ami_id":           identityDocParsed.AmiID,
+				"partition":        getPartitionForRegion(identityDocParsed.Region),
 			},
 			LeaseOptions: logical.LeaseOptions{
 				Renewable: true,
@@ -818,6 +1491,25 @@ func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request,
 		},
 	}
 
+	if certificateMaxTTL > 0 {
+		resp.Auth.ExplicitMaxTTL = certificateMaxTTL
+		if resp.Auth.TTL > certificateMaxTTL {
+			resp.Auth.TTL = certificateMaxTTL
+		}
+	}
+
+	if team != "" {
+		resp.Auth.Alias.Metadata = map[string]string{
+			"team": team,
+		}
+	}
+
+	addRoleAliasMetadata(resp.Auth.Alias, roleEntry, roleName)
+
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+
 	// Return the nonce only if reauthentication is allowed and if the nonce
 	// was not supplied by the user.
 	if !disallowReauthentication && !clientNonceSupplied {
@@ -828,9 +1520,70 @@ func (b *backend) pathLoginUpdateEc2(ctx context.Context, req *logical.Request,
 		resp.Auth.Metadata["nonce"] = clientNonce
 	}
 
+	if err := addInstanceDocumentMetadata(resp.Auth.Metadata, roleEntry, identityDocParsed); err != nil {
+		return nil, err
+	}
+
+	addLaunchTimeMetadata(resp.Auth.Metadata, roleEntry, instance)
+
+	if roleEntry.ForwardCertFingerprint && identityDocParsed.signingCert != nil {
+		resp.Auth.Metadata["cert_fingerprint"] = certificateFingerprint(identityDocParsed.signingCert)
+	}
+
 	return resp, nil
 }
 
+// certificateFingerprint returns the hex encoded SHA-256 fingerprint of the
+// DER encoding of the certificate
+func certificateFingerprint(cert *x509.Certificate) string {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// certificateRemainingValidity returns how long the certificate which verified
+// an instance identity document remains valid
+func (b *backend) certificateRemainingValidity(cert *x509.Certificate) (time.Duration, error) {
+	if cert == nil {
+		return 0, fmt.Errorf("unable to determine the certificate which verified the instance identity document")
+	}
+	remaining := cert.NotAfter.Sub(b.clock())
+	if remaining <= 0 {
+		return 0, fmt.Errorf("certificate %q which verified the instance identity document has expired", cert.Subject.CommonName)
+	}
+	return remaining, nil
+}
+
+// addLaunchTimeMetadata adds the launch time of the instance, as returned by
+// DescribeInstances, to the login metadata if the role asks for it
+func addLaunchTimeMetadata(metadata map[string]string, roleEntry *awsRoleEntry, instance *ec2.Instance) {
+	if !roleEntry.ForwardLaunchTime || instance.LaunchTime == nil {
+		return
+	}
+	metadata["launch_time"] = instance.LaunchTime.UTC().Format(time.RFC3339)
+}
+
+// addInstanceDocumentMetadata adds the JSON encoded fields of the verified
+// instance identity document to the login metadata, if the role asks for it.
+// The tags of the document are not forwarded.
+func addInstanceDocumentMetadata(metadata map[string]string, roleEntry *awsRoleEntry, identityDoc *identityDocument) error {
+	if !roleEntry.ForwardInstanceDocument || identityDoc == nil {
+		return nil
+	}
+
+	documentJSON, err := json.Marshal(&identityDocument{
+		InstanceID:  identityDoc.InstanceID,
+		AmiID:       identityDoc.AmiID,
+		AccountID:   identityDoc.AccountID,
+		Region:      identityDoc.Region,
+		PendingTime: identityDoc.PendingTime,
+	})
+	if err != nil {
+		return errwrap.Wrapf("failed to encode the instance identity document: {{err}}", err)
+	}
+	metadata["instance_document"] = string(documentJSON)
+	return nil
+}
+
 // handleRoleTagLogin is used to fetch the role tag of the instance and
 // verifies it to be correct.  Then the policies for the login request will be
 // set off of the role tag, if certain criteria satisfies.
@@ -941,6 +1694,60 @@ func (b *backend) pathLoginRenewIam(ctx context.Context, req *logical.Request, d
 	if roleEntry == nil {
 		return nil, fmt.Errorf("role entry not found")
 	}
+	if err := b.applyDefaultTTLs(ctx, req.Storage, roleEntry); err != nil {
+		return nil, err
+	}
+	ctx = withIAMEndpoint(ctx, roleEntry.IAMEndpoint)
+
+	if roleEntry.DenyRootPrincipal {
+		entity, err := parseIamArn(req.Auth.Metadata["client_arn"])
+		if err != nil {
+			return nil, errwrap.Wrapf("error parsing client ARN during renewal: {{err}}", err)
+		}
+		if entity.Type == rootEntityType {
+			return nil, fmt.Errorf("root principal %q no longer allowed to login to role %q", req.Auth.Metadata["client_arn"], roleName)
+		}
+	}
+
+	if roleEntry.DenyServiceLinkedRoles {
+		entity, err := parseIamArn(req.Auth.Metadata["client_arn"])
+		if err != nil {
+			return nil, errwrap.Wrapf("error parsing client ARN during renewal: {{err}}", err)
+		}
+		if entity.isServiceLinkedRole() {
+			return nil, fmt.Errorf("service-linked role %q no longer allowed to login to role %q", req.Auth.Metadata["client_arn"], roleName)
+		}
+	}
+
+	if len(roleEntry.BoundPermissionsBoundaryARNs) > 0 {
+		entity, err := parseIamArn(req.Auth.Metadata["client_arn"])
+		if err != nil {
+			return nil, errwrap.Wrapf("error parsing client ARN during renewal: {{err}}", err)
+		}
+		if err := b.verifyPermissionsBoundary(ctx, req.Storage, roleEntry, entity); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("IAM principal %q no longer bound to role %q: {{err}}", req.Auth.Metadata["client_arn"], roleName), err)
+		}
+	}
+
+	if roleEntry.BoundSourceRolePath != "" {
+		entity, err := parseIamArn(req.Auth.Metadata["client_arn"])
+		if err != nil {
+			return nil, errwrap.Wrapf("error parsing client ARN during renewal: {{err}}", err)
+		}
+		if err := b.verifySourceRolePath(ctx, req.Storage, roleEntry, entity); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("IAM principal %q no longer bound to role %q: {{err}}", req.Auth.Metadata["client_arn"], roleName), err)
+		}
+	}
+
+	if roleEntry.RequireActivePrincipal {
+		entity, err := parseIamArn(req.Auth.Metadata["client_arn"])
+		if err != nil {
+			return nil, errwrap.Wrapf("error parsing client ARN during renewal: {{err}}", err)
+		}
+		if err := b.verifyActivePrincipal(ctx, req.Storage, entity); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("IAM principal %q no longer bound to role %q: {{err}}", req.Auth.Metadata["client_arn"], roleName), err)
+		}
+	}
 
 	// we don't really care what the inferred entity type was when the role was initially created. We
 	// care about what the role currently requires. However, the metadata's inferred_entity_id is only
@@ -984,7 +1791,7 @@ func (b *backend) pathLoginRenewIam(ctx context.Context, req *logical.Request, d
 		// 1: clientUserId is in roleEntry.BoundIamPrincipalIDs (entries in roleEntry.BoundIamPrincipalIDs
 		//    implies that roleEntry.ResolveAWSUniqueIDs is true)
 		// 2: roleEntry.ResolveAWSUniqueIDs is false and canonical_arn is in roleEntry.BoundIamPrincipalARNs
-		// 3: Full ARN matches one of the wildcard globs in roleEntry.BoundIamPrincipalARNs
+		// 3: Full ARN matches one of the wildcard or regex patterns in roleEntry.BoundIamPrincipalARNs
 		clientUserId, ok := req.Auth.Metadata["client_user_id"]
 		switch {
 		case ok && strutil.StrListContains(roleEntry.BoundIamPrincipalIDs, clientUserId): // check 1 passed
@@ -1010,7 +1817,7 @@ func (b *backend) pathLoginRenewIam(ctx context.Context, req *logical.Request, d
 			}
 			matchedWildcardBind := false
 			for _, principalARN := range roleEntry.BoundIamPrincipalARNs {
-				if strings.HasSuffix(principalARN, "*") && strutil.GlobbedStringsMatch(principalARN, fullArn) {
+				if isBoundPrincipalARNPattern(principalARN) && boundPrincipalPatternMatches(principalARN, roleEntry.boundIamPrincipalARNPatterns, fullArn) {
 					matchedWildcardBind = true
 					break
 				}
@@ -1024,7 +1831,7 @@ func (b *backend) pathLoginRenewIam(ctx context.Context, req *logical.Request, d
 	resp := &logical.Response{Auth: req.Auth}
 	resp.Auth.TTL = roleEntry.TTL
 	resp.Auth.MaxTTL = roleEntry.MaxTTL
-	resp.Auth.Period = roleEntry.Period
+	resp.Auth.Period = renewalPeriod(roleEntry, req.Auth.Increment)
 	return resp, nil
 }
 
@@ -1047,8 +1854,16 @@ func (b *backend) pathLoginRenewEc2(ctx context.Context, req *logical.Request, d
 		}
 	}
 
+	denied, err := b.instanceDenied(ctx, req.Storage, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if denied {
+		return nil, fmt.Errorf("instance %q is denied by the client configuration", instanceID)
+	}
+
 	// Cross check that the instance is still in 'running' state
-	_, err := b.validateInstance(ctx, req.Storage, instanceID, region, accountID)
+	_, err = b.validateInstance(ctx, req.Storage, instanceID, region, accountID)
 	if err != nil {
 		return nil, errwrap.Wrapf(fmt.Sprintf("failed to verify instance ID %q: {{err}}", instanceID), err)
 	}
@@ -1069,6 +1884,9 @@ func (b *backend) pathLoginRenewEc2(ctx context.Context, req *logical.Request, d
 	if roleEntry == nil {
 		return nil, fmt.Errorf("role entry not found")
 	}
+	if err := b.applyDefaultTTLs(ctx, req.Storage, roleEntry); err != nil {
+		return nil, err
+	}
 
 	// If the login was made using the role tag, then max_ttl from tag
 	// is cached in internal data during login and used here to cap the
@@ -1095,7 +1913,7 @@ func (b *backend) pathLoginRenewEc2(ctx context.Context, req *logical.Request, d
 	}
 
 	// Only LastUpdatedTime and ExpirationTime change and all other fields remain the same
-	currentTime := time.Now()
+	currentTime := b.clock()
 	storedIdentity.LastUpdatedTime = currentTime
 	storedIdentity.ExpirationTime = currentTime.Add(longestMaxTTL)
 
@@ -1108,32 +1926,50 @@ func (b *backend) pathLoginRenewEc2(ctx context.Context, req *logical.Request, d
 	resp := &logical.Response{Auth: req.Auth}
 	resp.Auth.TTL = roleEntry.TTL
 	resp.Auth.MaxTTL = shortestMaxTTL
-	resp.Auth.Period = roleEntry.Period
+	resp.Auth.Period = renewalPeriod(roleEntry, req.Auth.Increment)
 	return resp, nil
 }
 
-func (b *backend) pathLoginUpdateIam(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	method := data.Get("iam_http_request_method").(string)
-	if method == "" {
-		return logical.ErrorResponse("missing iam_http_request_method"), nil
+// renewalPeriod returns the period of a token generated using the role, when
+// it is issued or renewed by the requested increment, which is zero on login.
+// A periodic token is extended by its period whatever the increment requested,
+// so if the role sets max_renewal_increment, the requested increment, bounded
+// by the period of the role, is clamped to it and returned as the period.
+func renewalPeriod(roleEntry *awsRoleEntry, increment time.Duration) time.Duration {
+	if roleEntry.MaxRenewalIncrement <= 0 || roleEntry.Period <= 0 {
+		return roleEntry.Period
+	}
+	if increment <= 0 || increment > roleEntry.Period {
+		increment = roleEntry.Period
 	}
+	if increment > roleEntry.MaxRenewalIncrement {
+		increment = roleEntry.MaxRenewalIncrement
+	}
+	return increment
+}
 
-	// In the future, might consider supporting GET
-	if method != "POST" {
-		return logical.ErrorResponse("invalid iam_http_request_method; currently only 'POST' is supported"), nil
+func (b *backend) pathLoginUpdateIam(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	validation := loginValidationFromContext(ctx)
+
+	method, err := validateRequestMethod(data.Get("iam_http_request_method").(string))
+	if err != nil {
+		return loginRejected(loginReasonInvalidRequest, err.Error()), nil
 	}
 
 	rawUrlB64 := data.Get("iam_request_url").(string)
 	if rawUrlB64 == "" {
-		return logical.ErrorResponse("missing iam_request_url"), nil
+		return loginRejected(loginReasonInvalidRequest, "missing iam_request_url"), nil
 	}
 	rawUrl, err := base64.StdEncoding.DecodeString(rawUrlB64)
 	if err != nil {
-		return logical.ErrorResponse("failed to base64 decode iam_request_url"), nil
+		return loginRejected(loginReasonInvalidRequest, "failed to base64 decode iam_request_url"), nil
 	}
 	parsedUrl, err := url.Parse(string(rawUrl))
 	if err != nil {
-		return logical.ErrorResponse("error parsing iam_request_url"), nil
+		return loginRejected(loginReasonInvalidRequest, "error parsing iam_request_url"), nil
+	}
+	if err := validateRequestURLQuery(parsedUrl); err != nil {
+		return loginRejected(loginReasonInvalidRequest, fmt.Sprintf("invalid iam_request_url: %v", err)), nil
 	}
 
 	// TODO: There are two potentially valid cases we're not yet supporting that would
@@ -1141,48 +1977,120 @@ func (b *backend) pathLoginUpdateIam(ctx context.Context, req *logical.Request,
 	// Second if we support presigned POST requests
 	bodyB64 := data.Get("iam_request_body").(string)
 	if bodyB64 == "" {
-		return logical.ErrorResponse("missing iam_request_body"), nil
+		return loginRejected(loginReasonInvalidRequest, "missing iam_request_body"), nil
 	}
 	bodyRaw, err := base64.StdEncoding.DecodeString(bodyB64)
 	if err != nil {
-		return logical.ErrorResponse("failed to base64 decode iam_request_body"), nil
+		return loginRejected(loginReasonInvalidRequest, "failed to base64 decode iam_request_body"), nil
 	}
 	body := string(bodyRaw)
 
 	headersB64 := data.Get("iam_request_headers").(string)
 	if headersB64 == "" {
-		return logical.ErrorResponse("missing iam_request_headers"), nil
+		return loginRejected(loginReasonInvalidRequest, "missing iam_request_headers"), nil
 	}
 	headers, err := parseIamRequestHeaders(headersB64)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("Error parsing iam_request_headers: %v", err)), nil
+		return loginRejected(loginReasonInvalidRequest, fmt.Sprintf("Error parsing iam_request_headers: %v", err)), nil
 	}
 	if headers == nil {
-		return logical.ErrorResponse("nil response when parsing iam_request_headers"), nil
+		return loginRejected(loginReasonInvalidRequest, "nil response when parsing iam_request_headers"), nil
+	}
+
+	// Reject requests signed with an unexpected algorithm before doing any
+	// further work, rather than relying on STS to do so
+	if authzHeaders := headerValues(headers, "Authorization"); len(authzHeaders) > 0 {
+		if err := validateAuthorizationAlgorithm(strings.Join(authzHeaders, ",")); err != nil {
+			return loginRejected(loginReasonInvalidRequest, fmt.Sprintf("error validating Authorization header: %v", err)), nil
+		}
 	}
+	validation.pass(loginCheckRequest)
 
 	config, err := b.lockedClientConfigEntry(ctx, req.Storage)
 	if err != nil {
 		return logical.ErrorResponse("error getting configuration"), nil
 	}
 
-	endpoint := "https://sts.amazonaws.com"
+	// The region the request was signed for selects the regional STS
+	// endpoint, unless an endpoint is configured
+	signingRegion, signingRegionErr := authorizationSigningRegion(headers)
+	endpoint := defaultSTSEndpoint
+	if signingRegionErr == nil {
+		if endpoint, err = regionalSTSEndpoint(signingRegion); err != nil {
+			return loginRejected(loginReasonInvalidRequest, fmt.Sprintf("error deriving the STS endpoint: %v", err)), nil
+		}
+	}
 
 	if config != nil {
-		if config.IAMServerIdHeaderValue != "" {
-			err = validateVaultHeaderValue(headers, parsedUrl, config.IAMServerIdHeaderValue)
+		if len(config.IAMServerIdHeaderValues) > 0 {
+			err = validateVaultHeaderValue(headers, parsedUrl, config.IAMServerIdHeaderValues, config.RequireSignedHostHeader)
 			if err != nil {
-				return logical.ErrorResponse(fmt.Sprintf("error validating %s header: %v", iamServerIdHeader, err)), nil
+				return loginRejected(failureReason(err, loginReasonInvalidRequest), fmt.Sprintf("error validating %s header: %v", iamServerIdHeader, err)), nil
+			}
+		} else if config.RequireSignedHostHeader {
+			if err := validateHostHeaderSigned(headers); err != nil {
+				return loginRejected(failureReason(err, loginReasonInvalidRequest), fmt.Sprintf("error validating Host header: %v", err)), nil
+			}
+		}
+		if config.RequireFormContentType {
+			if err := validateFormContentType(headers); err != nil {
+				return loginRejected(failureReason(err, loginReasonInvalidRequest), fmt.Sprintf("error validating Content-Type header: %v", err)), nil
+			}
+		}
+		if config.MaxSignedHeaders > 0 {
+			if err := validateSignedHeaderCount(headers, config.MaxSignedHeaders); err != nil {
+				return loginRejected(loginReasonInvalidRequest, fmt.Sprintf("error validating Authorization header: %v", err)), nil
 			}
 		}
 		if config.STSEndpoint != "" {
+			// The endpoint was validated when it was written, but re-check it
+			// here as it may predate that validation
+			if err := validateEndpointScheme(config.STSEndpoint, config.AllowInsecureEndpoints); err != nil {
+				return loginRejected(loginReasonSTSEndpointNotAllowed, fmt.Sprintf("invalid sts_endpoint: %v", err)), nil
+			}
 			endpoint = config.STSEndpoint
 		}
 	}
+	validation.pass(loginCheckServerIDHeader)
+
+	// The role named in the request may override the STS endpoint. A role
+	// defaulting to the name of the caller cannot, as the caller is unknown
+	// until STS answered.
+	if requestedRoleName := data.Get("role").(string); requestedRoleName != "" {
+		requestedRole, err := b.lockedAWSRole(ctx, req.Storage, requestedRoleName)
+		if err != nil {
+			return nil, err
+		}
+		if requestedRole != nil && requestedRole.AuthType == iamAuthType && requestedRole.STSEndpoint != "" {
+			// The endpoint was validated when the role was written, but the
+			// client configuration may have changed since
+			if err := validateEndpointScheme(requestedRole.STSEndpoint, config != nil && config.AllowInsecureEndpoints); err != nil {
+				return loginRejected(loginReasonSTSEndpointNotAllowed, fmt.Sprintf("invalid sts_endpoint of role %q: %v", requestedRoleName, err)), nil
+			}
+			endpoint = requestedRole.STSEndpoint
+		}
+	}
+
+	if config != nil && len(config.AllowedSTSEndpoints) > 0 {
+		if err := validateSTSEndpointAllowed(endpoint, config.AllowedSTSEndpoints); err != nil {
+			return loginRejected(loginReasonSTSEndpointNotAllowed, fmt.Sprintf("error validating STS endpoint: %v", err)), nil
+		}
+	}
+	validation.pass(loginCheckSTSEndpoint)
+
+	// A stale request is rejected without a round trip to STS
+	maxAge := defaultIAMRequestMaxAge
+	if config != nil {
+		maxAge = config.IAMRequestMaxAge
+	}
+	if err := validateRequestDate(headers, maxAge, b.clock()); err != nil {
+		return loginRejected(failureReason(err, loginReasonStaleRequest), fmt.Sprintf("error validating X-Amz-Date header: %v", err)), nil
+	}
+	validation.pass(loginCheckRequestDate)
 
-	callerID, err := submitCallerIdentityRequest(method, endpoint, parsedUrl, body, headers)
+	callerID, stsRequestID, err := submitCallerIdentityRequest(method, endpoint, parsedUrl, body, headers)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("error making upstream request: %v", err)), nil
+		return loginRejected(failureReason(err, loginReasonSTSError), fmt.Sprintf("error making upstream request: %v", err)), nil
 	}
 	// This could either be a "userID:SessionID" (in the case of an assumed role) or just a "userID"
 	// (in the case of an IAM user).
@@ -1199,26 +2107,130 @@ func (b *backend) pathLoginUpdateIam(ctx context.Context, req *logical.Request,
 		}, nil
 	}
 
+	// The request is only recorded once STS accepted it, and after the alias
+	// lookahead which precedes the login with the same request
+	if config != nil && config.RejectReplays {
+		if err := b.checkAndRecordSignedRequest(ctx, req.Storage, headers); err != nil {
+			return loginRejected(failureReason(err, loginReasonReplayedRequest), fmt.Sprintf("error checking for a replayed request: %v", err)), nil
+		}
+	}
+	validation.pass(loginCheckSTSRequest)
+
+	maxARNLength := 0
+	if config != nil {
+		maxARNLength = config.MaxCallerARNLength
+	}
+	if err := validateCallerARNLength(callerID.Arn, maxARNLength); err != nil {
+		return loginRejected(loginReasonInvalidCallerARN, err.Error()), nil
+	}
+
 	entity, err := parseIamArn(callerID.Arn)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("error parsing arn %q: %v", callerID.Arn, err)), nil
+		return loginRejected(loginReasonInvalidCallerARN, fmt.Sprintf("error parsing arn %q: %v", callerID.Arn, err)), nil
+	}
+
+	if config != nil && config.ValidateCallerIdentity {
+		if err := validateCallerIdentityConsistency(callerID, entity); err != nil {
+			return loginRejected(loginReasonInvalidSTSResponse, fmt.Sprintf("inconsistent caller identity: %v", err)), nil
+		}
 	}
+	validation.resolvePrincipal(entity)
+	validation.pass(loginCheckPrincipalARN)
 
 	roleName := data.Get("role").(string)
 	if roleName == "" {
 		roleName = entity.FriendlyName
 	}
+	validation.resolveRole(roleName)
+
+	// The naming convention is enforced before the role is looked up, so
+	// that it does not reveal which roles exist
+	if config != nil && config.RoleNamePattern != "" {
+		if err := validateRoleNameForCaller(config.RoleNamePattern, roleName, entity); err != nil {
+			return loginRejected(loginReasonRoleNotAllowed, err.Error()), nil
+		}
+	}
 
 	roleEntry, err := b.lockedAWSRole(ctx, req.Storage, roleName)
 	if err != nil {
 		return nil, err
 	}
 	if roleEntry == nil {
-		return logical.ErrorResponse(fmt.Sprintf("entry for role %s not found", roleName)), nil
+		roleEntry, err = b.autoCreateIamRole(ctx, req.Storage, config, roleName, entity, callerUniqueId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if roleEntry == nil {
+		return loginRejected(loginReasonRoleNotFound, fmt.Sprintf("entry for role %s not found", roleName)), nil
+	}
+	if err := b.applyDefaultTTLs(ctx, req.Storage, roleEntry); err != nil {
+		return nil, err
 	}
 
 	if roleEntry.AuthType != iamAuthType {
-		return logical.ErrorResponse(fmt.Sprintf("auth method iam not allowed for role %s", roleName)), nil
+		return loginRejected(loginReasonRoleNotAllowed, fmt.Sprintf("auth method iam not allowed for role %s", roleName)), nil
+	}
+	if roleEntry.STSEndpoint != "" && roleEntry.STSEndpoint != endpoint {
+		return loginRejected(loginReasonRoleNotAllowed, fmt.Sprintf("role %q overrides the STS endpoint, so it must be named in the login request", roleName)), nil
+	}
+	ctx = withIAMEndpoint(ctx, roleEntry.IAMEndpoint)
+	validation.pass(loginCheckRole)
+
+	if err := validateLoginWindow(roleEntry, roleName, b.clock()); err != nil {
+		return loginRejected(loginReasonRoleNotAllowed, err.Error()), nil
+	}
+
+	if err := validateBoundConstraintCount(roleEntry, roleName); err != nil {
+		return loginRejected(loginReasonRoleNotAllowed, err.Error()), nil
+	}
+
+	// The size limit depends on the role, so it can only be enforced once
+	// the caller, and hence the role, is known
+	if err := validateRequestBodySize(body, config, roleEntry); err != nil {
+		return loginRejected(loginReasonInvalidRequest, err.Error()), nil
+	}
+
+	if len(roleEntry.AllowedSigningRegions) > 0 {
+		if signingRegionErr != nil {
+			return loginRejected(loginReasonInvalidRequest, fmt.Sprintf("error validating signing region of role %q: %v", roleName, signingRegionErr)), nil
+		}
+		if !strutil.StrListContains(roleEntry.AllowedSigningRegions, signingRegion) {
+			return loginRejected(loginReasonRoleNotAllowed, fmt.Sprintf("request signed for region %q, which is not allowed by role %q", signingRegion, roleName)), nil
+		}
+	}
+
+	if roleEntry.RequireTemporaryCredentials {
+		if err := validateTemporaryCredentials(headers); err != nil {
+			return loginRejected(failureReason(err, loginReasonPrincipalNotAllowed), fmt.Sprintf("error validating credentials of role %q: %v", roleName, err)), nil
+		}
+	}
+
+	if roleEntry.BoundSessionNamePattern != "" {
+		if err := validateSessionName(entity, roleEntry.BoundSessionNamePattern); err != nil {
+			return loginRejected(loginReasonPrincipalNotAllowed, fmt.Sprintf("IAM principal %q does not belong to the role %q: %v", callerID.Arn, roleName, err)), nil
+		}
+	}
+
+	if roleEntry.DenyRootPrincipal && entity.Type == rootEntityType {
+		return loginRejected(loginReasonPrincipalNotAllowed, fmt.Sprintf("root principal %q not allowed to login to role %q", callerID.Arn, roleName)), nil
+	}
+
+	if roleEntry.DenyServiceLinkedRoles && entity.isServiceLinkedRole() {
+		return loginRejected(loginReasonPrincipalNotAllowed, fmt.Sprintf("service-linked role %q not allowed to login to role %q", callerID.Arn, roleName)), nil
+	}
+
+	var warnings []string
+	if roleEntry.RequireManagementAccount {
+		if err := b.verifyManagementAccount(ctx, req.Storage, getAnyRegionForAwsPartition(entity.Partition).ID(), entity.AccountNumber, &warnings); err != nil {
+			return loginRejected(loginReasonAccountMismatch, fmt.Sprintf("error validating account of IAM principal %q: %v", callerID.Arn, err)), nil
+		}
+	}
+
+	if roleEntry.BoundOrganizationID != "" {
+		if err := b.verifyAccountOrganization(ctx, req.Storage, config, entity, roleEntry.BoundOrganizationID); err != nil {
+			return loginRejected(loginReasonAccountMismatch, fmt.Sprintf("error validating organization of IAM principal %q: %v", callerID.Arn, err)), nil
+		}
 	}
 
 	// The role creation should ensure that either we're inferring this is an EC2 instance
@@ -1228,7 +2240,7 @@ func (b *backend) pathLoginUpdateIam(ctx context.Context, req *logical.Request,
 		// 1: callerUniqueId is in roleEntry.BoundIamPrincipalIDs (entries in roleEntry.BoundIamPrincipalIDs
 		//    implies that roleEntry.ResolveAWSUniqueIDs is true)
 		// 2: roleEntry.ResolveAWSUniqueIDs is false and entity.canonicalArn() is in roleEntry.BoundIamPrincipalARNs
-		// 3: Full ARN matches one of the wildcard globs in roleEntry.BoundIamPrincipalARNs
+		// 3: Full ARN matches one of the wildcard or regex patterns in roleEntry.BoundIamPrincipalARNs
 		// Need to be able to handle pathological configurations such as roleEntry.BoundIamPrincipalARNs looking something like:
 		// arn:aw:iam::123456789012:{user/UserName,user/path/*,role/RoleName,role/path/*}
 		switch {
@@ -1236,26 +2248,82 @@ func (b *backend) pathLoginUpdateIam(ctx context.Context, req *logical.Request,
 		case !roleEntry.ResolveAWSUniqueIDs && strutil.StrListContains(roleEntry.BoundIamPrincipalARNs, entity.canonicalArn()): // check 2 passed
 		default:
 			// evaluate check 3
-			fullArn := b.getCachedUserId(callerUniqueId)
-			if fullArn == "" {
-				fullArn, err = b.fullArn(ctx, entity, req.Storage)
-				if err != nil {
-					return logical.ErrorResponse(fmt.Sprintf("error looking up full ARN of entity %v: %v", entity, err)), nil
-				}
-				if fullArn == "" {
-					return logical.ErrorResponse(fmt.Sprintf("got empty string back when looking up full ARN of entity %v", entity)), nil
-				}
-				b.setCachedUserId(callerUniqueId, fullArn)
+			fullArn, err := b.cachedFullArn(ctx, req.Storage, entity, callerUniqueId)
+			if err != nil {
+				return loginRejected(loginReasonLookupFailed, err.Error()), nil
 			}
 			matchedWildcardBind := false
 			for _, principalARN := range roleEntry.BoundIamPrincipalARNs {
-				if strings.HasSuffix(principalARN, "*") && strutil.GlobbedStringsMatch(principalARN, fullArn) {
+				if isBoundPrincipalARNPattern(principalARN) && boundPrincipalPatternMatches(principalARN, roleEntry.boundIamPrincipalARNPatterns, fullArn) {
 					matchedWildcardBind = true
 					break
 				}
 			}
 			if !matchedWildcardBind {
-				return logical.ErrorResponse(fmt.Sprintf("IAM Principal %q does not belong to the role %q", callerID.Arn, roleName)), nil
+				return loginRejected(loginReasonARNMismatch, fmt.Sprintf("IAM Principal %q does not belong to the role %q", callerID.Arn, roleName)), nil
+			}
+		}
+	}
+
+	if len(roleEntry.BoundPermissionsBoundaryARNs) > 0 {
+		if err := b.verifyPermissionsBoundary(ctx, req.Storage, roleEntry, entity); err != nil {
+			return loginRejected(loginReasonPrincipalNotAllowed, fmt.Sprintf("IAM principal %q does not belong to the role %q: %v", callerID.Arn, roleName, err)), nil
+		}
+	}
+
+	if roleEntry.BoundSourceRolePath != "" {
+		if err := b.verifySourceRolePath(ctx, req.Storage, roleEntry, entity); err != nil {
+			return loginRejected(loginReasonPrincipalNotAllowed, fmt.Sprintf("IAM principal %q does not belong to the role %q: %v", callerID.Arn, roleName, err)), nil
+		}
+	}
+
+	if roleEntry.RequireActivePrincipal {
+		if err := b.verifyActivePrincipal(ctx, req.Storage, entity); err != nil {
+			return loginRejected(loginReasonPrincipalNotAllowed, fmt.Sprintf("IAM principal %q does not belong to the role %q: %v", callerID.Arn, roleName, err)), nil
+		}
+	}
+
+	// Record every bound ARN entry which matches the caller, not only the
+	// first one, so that overlapping binds can be audited, and the index of
+	// the first one, so that reordering the entries can be detected
+	var matchedBoundARNs []string
+	matchedBoundIndex := -1
+	if (roleEntry.IncludeMatchedBoundARNs || roleEntry.IncludeMatchedBoundIndex) && len(roleEntry.BoundIamPrincipalARNs) > 0 {
+		fullArn, err := b.cachedFullArn(ctx, req.Storage, entity, callerUniqueId)
+		if err != nil {
+			if err := b.lookupFailed(ctx, req.Storage, matchedBoundARNsLookup, err, &warnings); err != nil {
+				return loginRejected(loginReasonLookupFailed, err.Error()), nil
+			}
+		} else {
+			matchedBoundARNs = matchedBoundPrincipalARNs(roleEntry.BoundIamPrincipalARNs, roleEntry.boundIamPrincipalARNPatterns, entity.canonicalArn(), fullArn)
+			matchedBoundIndex = matchedBoundPrincipalIndex(roleEntry.BoundIamPrincipalARNs, roleEntry.boundIamPrincipalARNPatterns, entity.canonicalArn(), fullArn)
+		}
+	}
+
+	// The unique ID is only recorded, independently of how the caller was
+	// matched against the role
+	uniqueID := ""
+	if roleEntry.ForwardUniqueID {
+		uniqueID, err = b.cachedUniqueID(ctx, req.Storage, entity)
+		if err != nil {
+			if err := b.lookupFailed(ctx, req.Storage, uniqueIDLookup, err, &warnings); err != nil {
+				return loginRejected(loginReasonLookupFailed, err.Error()), nil
+			}
+		}
+	}
+
+	team := ""
+	if roleEntry.TeamTagKey != "" {
+		tags, err := b.principalTagsFunc(ctx, req.Storage, entity)
+		if err != nil {
+			err = fmt.Errorf("error fetching tags of IAM principal %q: %v", callerID.Arn, err)
+			if err := b.lookupFailed(ctx, req.Storage, teamTagLookup, err, &warnings); err != nil {
+				return loginRejected(loginReasonLookupFailed, err.Error()), nil
+			}
+		} else {
+			team, err = teamTagValue(tags, roleEntry.TeamTagKey)
+			if err != nil {
+				return loginRejected(loginReasonPrincipalNotAllowed, fmt.Sprintf("IAM principal %q: %v", callerID.Arn, err)), nil
 			}
 		}
 	}
@@ -1264,10 +2332,22 @@ func (b *backend) pathLoginUpdateIam(ctx context.Context, req *logical.Request,
 
 	inferredEntityType := ""
 	inferredEntityID := ""
+	var inferredInstance *ec2.Instance
 	if roleEntry.InferredEntityType == ec2EntityType {
-		instance, err := b.validateInstance(ctx, req.Storage, entity.SessionInfo, roleEntry.InferredAWSRegion, callerID.Account)
+		// EC2 instances log in with the credentials of their instance
+		// profile, which are sessions of its role named after the instance ID
+		instanceID := entity.roleSessionName()
+		if instanceID == "" {
+			return loginRejected(loginReasonInstanceMismatch, fmt.Sprintf("IAM principal %q is not a session of an assumed role and cannot be inferred as an EC2 instance", callerID.Arn)), nil
+		}
+		reservation, err := b.validateInstanceReservation(ctx, req.Storage, instanceID, roleEntry.InferredAWSRegion, callerID.Account)
 		if err != nil {
-			return logical.ErrorResponse(fmt.Sprintf("failed to verify %s as a valid EC2 instance in region %s", entity.SessionInfo, roleEntry.InferredAWSRegion)), nil
+			return loginRejected(loginReasonInstanceMismatch, fmt.Sprintf("failed to verify %s as a valid EC2 instance in region %s", instanceID, roleEntry.InferredAWSRegion)), nil
+		}
+		instance := reservation.Instances[0]
+
+		if err := validateReservationOwner(reservation, roleEntry, roleName); err != nil {
+			return loginRejected(loginReasonInstanceMismatch, fmt.Sprintf("error validating instance: %s", err)), nil
 		}
 
 		// build a fake identity doc to pass on metadata about the instance to verifyInstanceMeetsRoleRequirements
@@ -1285,16 +2365,23 @@ func (b *backend) pathLoginUpdateIam(ctx context.Context, req *logical.Request,
 			return nil, err
 		}
 		if validationError != nil {
-			return logical.ErrorResponse(fmt.Sprintf("error validating instance: %s", validationError)), nil
+			return loginRejected(loginReasonInstanceMismatch, fmt.Sprintf("error validating instance: %s", validationError)), nil
+		}
+
+		if roleEntry.RequireMatchingInstanceProfilePath {
+			if err := b.verifyInstanceProfilePath(ctx, req.Storage, entity, instance); err != nil {
+				return loginRejected(loginReasonPrincipalNotAllowed, fmt.Sprintf("IAM principal %q does not belong to the role %q: %v", callerID.Arn, roleName, err)), nil
+			}
 		}
 
 		inferredEntityType = ec2EntityType
-		inferredEntityID = entity.SessionInfo
+		inferredEntityID = instanceID
+		inferredInstance = instance
 	}
 
 	resp := &logical.Response{
 		Auth: &logical.Auth{
-			Period:   roleEntry.Period,
+			Period:   renewalPeriod(roleEntry, 0),
 			Policies: policies,
 			Metadata: map[string]string{
 				"client_arn":           callerID.Arn,
@@ -1305,6 +2392,9 @@ func (b *backend) pathLoginUpdateIam(ctx context.Context, req *logical.Request,
 				"inferred_entity_id":   inferredEntityID,
 				"inferred_aws_region":  roleEntry.InferredAWSRegion,
 				"account_id":           entity.AccountNumber,
+				"sts_request_id":       stsRequestID,
+				"partition":            entity.Partition,
+				"role_session_name":    entity.roleSessionName(),
 			},
 			InternalData: map[string]interface{}{
 				"role_name": roleName,
@@ -1321,30 +2411,574 @@ func (b *backend) pathLoginUpdateIam(ctx context.Context, req *logical.Request,
 		},
 	}
 
+	if roleEntry.IncludeMatchedBoundARNs {
+		resp.Auth.Metadata["matched_bound_arns"] = strings.Join(matchedBoundARNs, ",")
+	}
+
+	if roleEntry.ForwardUniqueID {
+		resp.Auth.Metadata["unique_id"] = uniqueID
+	}
+
+	if roleEntry.IncludeMatchedBoundIndex {
+		resp.Auth.Metadata["matched_bound_index"] = ""
+		if matchedBoundIndex >= 0 {
+			resp.Auth.Metadata["matched_bound_index"] = strconv.Itoa(matchedBoundIndex)
+		}
+	}
+
+	if team != "" {
+		resp.Auth.Alias.Metadata = map[string]string{
+			"team": team,
+		}
+	}
+
+	addRoleAliasMetadata(resp.Auth.Alias, roleEntry, roleName)
+
+	if inferredInstance != nil {
+		addLaunchTimeMetadata(resp.Auth.Metadata, roleEntry, inferredInstance)
+	}
+
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+
 	return resp, nil
 }
 
-// These two methods (hasValuesFor*) return two bools
-// The first is a hasAll, that is, does the request have all the values
-// necessary for this auth method
-// The second is a hasAny, that is, does the request have any of the fields
-// exclusive to this auth method
-func hasValuesForEc2Auth(data *framework.FieldData) (bool, bool) {
-	_, hasPkcs7 := data.GetOk("pkcs7")
-	_, hasIdentity := data.GetOk("identity")
-	_, hasSignature := data.GetOk("signature")
-	return (hasPkcs7 || (hasIdentity && hasSignature)), (hasPkcs7 || hasIdentity || hasSignature)
+// addRoleAliasMetadata adds the name and auth_type of the role used to login
+// to the alias metadata, if the role asks for it
+func addRoleAliasMetadata(alias *logical.Alias, roleEntry *awsRoleEntry, roleName string) {
+	if !roleEntry.IncludeRoleInAliasMetadata {
+		return
+	}
+	if alias.Metadata == nil {
+		alias.Metadata = make(map[string]string)
+	}
+	alias.Metadata["role"] = roleName
+	alias.Metadata["auth_type"] = roleEntry.AuthType
 }
 
-func hasValuesForIamAuth(data *framework.FieldData) (bool, bool) {
-	_, hasRequestMethod := data.GetOk("iam_http_request_method")
-	_, hasRequestURL := data.GetOk("iam_request_url")
+// Maximum length of a bound_session_name_pattern. Go regular expressions run
+// in linear time, so this only bounds the cost of compiling and matching them.
+const maxSessionNamePatternLength = 256
+
+// compileSessionNamePattern compiles a bound_session_name_pattern, anchored so
+// that it matches the whole session name
+func compileSessionNamePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > maxSessionNamePatternLength {
+		return nil, fmt.Errorf("pattern exceeds %d characters", maxSessionNamePatternLength)
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// Maximum length of a bound_private_dns_pattern, bounding the cost of
+// compiling and matching it as for bound_session_name_pattern
+const maxPrivateDNSPatternLength = 256
+
+// Prefix of a bound_private_dns_pattern which is a regular expression rather
+// than a wildcard pattern
+const privateDNSRegexPrefix = "regex:"
+
+// compilePrivateDNSPattern compiles a bound_private_dns_pattern. Both regular
+// expressions and wildcard patterns are anchored so that they match the whole
+// name.
+func compilePrivateDNSPattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > maxPrivateDNSPatternLength {
+		return nil, fmt.Errorf("pattern exceeds %d characters", maxPrivateDNSPatternLength)
+	}
+	if expr := strings.TrimPrefix(pattern, privateDNSRegexPrefix); expr != pattern {
+		return regexp.Compile("^(?:" + expr + ")$")
+	}
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// validateSessionName ensures that the entity is an assumed role whose
+// session name matches the given pattern
+func validateSessionName(entity *iamEntity, pattern string) error {
+	if entity.Type != "assumed-role" {
+		return fmt.Errorf("session name pattern requires an assumed role, got %s", entity.Type)
+	}
+	re, err := compileSessionNamePattern(pattern)
+	if err != nil {
+		return errwrap.Wrapf("invalid bound_session_name_pattern: {{err}}", err)
+	}
+	if !re.MatchString(entity.SessionInfo) {
+		return fmt.Errorf("session name %q does not match the bound session name pattern", entity.SessionInfo)
+	}
+	return nil
+}
+
+// verifyManagementAccount ensures that the given account is the management
+// account of the AWS organization it belongs to. If the organization cannot
+// be looked up and the lookup fails open, a warning is added instead.
+func (b *backend) verifyManagementAccount(ctx context.Context, s logical.Storage, region, accountID string, warnings *[]string) error {
+	managementAccountID, err := b.organizationManagementAccount(ctx, s, region, accountID)
+	if err != nil {
+		return b.lookupFailed(ctx, s, managementAccountLookup, err, warnings)
+	}
+	if managementAccountID != accountID {
+		return fmt.Errorf("account %q is not the management account of its organization", accountID)
+	}
+	return nil
+}
+
+// verifyAccountOrganization ensures that the account of the given entity
+// belongs to the given AWS organization
+func (b *backend) verifyAccountOrganization(ctx context.Context, s logical.Storage, config *clientConfig, entity *iamEntity, organizationID string) error {
+	region := getAnyRegionForAwsPartition(entity.Partition)
+	if region == nil {
+		return fmt.Errorf("unable to resolve partition %q to a region", entity.Partition)
+	}
+	cacheTTL := defaultOrganizationCacheTTL
+	if config != nil {
+		cacheTTL = config.OrganizationCacheTTL
+	}
+	accountOrganizationID, err := b.accountOrganization(ctx, s, region.ID(), entity.AccountNumber, cacheTTL)
+	if err != nil {
+		return err
+	}
+	if accountOrganizationID != organizationID {
+		return fmt.Errorf("account %q does not belong to organization %q", entity.AccountNumber, organizationID)
+	}
+	return nil
+}
+
+// verifyPermissionsBoundary ensures that the IAM user or role underlying the
+// given entity has one of the permissions boundaries bound to the role
+func (b *backend) verifyPermissionsBoundary(ctx context.Context, s logical.Storage, roleEntry *awsRoleEntry, entity *iamEntity) error {
+	boundaryARN, err := b.cachedPermissionsBoundary(ctx, s, entity)
+	if err != nil {
+		return err
+	}
+	if boundaryARN == "" {
+		return fmt.Errorf("no permissions boundary attached to %s %q", entity.Type, entity.FriendlyName)
+	}
+	if !strutil.StrListContains(roleEntry.BoundPermissionsBoundaryARNs, boundaryARN) {
+		return fmt.Errorf("permissions boundary %q is not bound to the role", boundaryARN)
+	}
+	return nil
+}
+
+// cachedPermissionsBoundary returns the ARN of the permissions boundary
+// attached to the given entity, consulting the cache first and populating it
+// after a successful lookup
+func (b *backend) cachedPermissionsBoundary(ctx context.Context, s logical.Storage, entity *iamEntity) (string, error) {
+	canonicalArn := entity.canonicalArn()
+	if entry, ok := b.lookupCache.get(permissionsBoundaryCacheNamespace, canonicalArn, b.clock()); ok {
+		return entry.(string), nil
+	}
+	boundaryARN, err := b.permissionsBoundaryFunc(ctx, s, entity)
+	if err != nil {
+		return "", err
+	}
+	b.lookupCache.set(permissionsBoundaryCacheNamespace, canonicalArn, boundaryARN, b.clock().Add(permissionsBoundaryCacheTTL))
+	return boundaryARN, nil
+}
+
+// verifySourceRolePath ensures that the entity is a session of an assumed role
+// whose path is, or is below, the bound_source_role_path of the role
+func (b *backend) verifySourceRolePath(ctx context.Context, s logical.Storage, roleEntry *awsRoleEntry, entity *iamEntity) error {
+	if entity.Type != "assumed-role" {
+		return fmt.Errorf("%s %q is not a session of an assumed role", entity.Type, entity.FriendlyName)
+	}
+	rolePath, err := b.cachedRolePath(ctx, s, entity)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(rolePath, roleEntry.BoundSourceRolePath) {
+		return fmt.Errorf("path %q of source role %q is not under %q", rolePath, entity.FriendlyName, roleEntry.BoundSourceRolePath)
+	}
+	return nil
+}
+
+// verifyInstanceProfilePath ensures that the entity is a session of an
+// assumed role whose path equals the path of the instance profile of the
+// given instance
+func (b *backend) verifyInstanceProfilePath(ctx context.Context, s logical.Storage, entity *iamEntity, instance *ec2.Instance) error {
+	if entity.Type != "assumed-role" {
+		return fmt.Errorf("%s %q is not a session of an assumed role", entity.Type, entity.FriendlyName)
+	}
+	if instance.IamInstanceProfile == nil || instance.IamInstanceProfile.Arn == nil {
+		return fmt.Errorf("instance %q has no instance profile", aws.StringValue(instance.InstanceId))
+	}
+	profile, err := parseIamArn(*instance.IamInstanceProfile.Arn)
+	if err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("error parsing instance profile ARN %q: {{err}}", *instance.IamInstanceProfile.Arn), err)
+	}
+	if profile.Type != "instance-profile" {
+		return fmt.Errorf("unexpected instance profile ARN %q", *instance.IamInstanceProfile.Arn)
+	}
+	rolePath, err := b.cachedRolePath(ctx, s, entity)
+	if err != nil {
+		return err
+	}
+	if rolePath != profile.iamPath() {
+		return fmt.Errorf("path %q of role %q does not match path %q of instance profile %q", rolePath, entity.FriendlyName, profile.iamPath(), profile.FriendlyName)
+	}
+	return nil
+}
+
+// cachedRolePath returns the path of the IAM role underlying the given
+// entity, consulting the cache first and populating it after a successful
+// lookup
+func (b *backend) cachedRolePath(ctx context.Context, s logical.Storage, entity *iamEntity) (string, error) {
+	canonicalArn := entity.canonicalArn()
+	if entry, ok := b.lookupCache.get(rolePathCacheNamespace, canonicalArn, b.clock()); ok {
+		return entry.(string), nil
+	}
+	rolePath, err := b.rolePathFunc(ctx, s, entity)
+	if err != nil {
+		return "", err
+	}
+	b.lookupCache.set(rolePathCacheNamespace, canonicalArn, rolePath, b.clock().Add(rolePathCacheTTL))
+	return rolePath, nil
+}
+
+// verifyActivePrincipal ensures that the IAM user or role underlying the
+// entity still exists. Sessions of assumed roles remain valid until they
+// expire even if the role is deleted, so they are checked against their role.
+func (b *backend) verifyActivePrincipal(ctx context.Context, s logical.Storage, entity *iamEntity) error {
+	canonicalArn := entity.canonicalArn()
+	exists, ok := b.lookupCache.get(activePrincipalCacheNamespace, canonicalArn, b.clock())
+	if !ok {
+		var err error
+		exists, err = b.principalExistsFunc(ctx, s, entity)
+		if err != nil {
+			return err
+		}
+		b.lookupCache.set(activePrincipalCacheNamespace, canonicalArn, exists, b.clock().Add(activePrincipalCacheTTL))
+	}
+	if !exists.(bool) {
+		return fmt.Errorf("%s %q no longer exists", entity.Type, entity.FriendlyName)
+	}
+	return nil
+}
+
+// cachedFullArn returns the full ARN of the given entity, consulting the user
+// ID cache first and populating it after a successful lookup
+func (b *backend) cachedFullArn(ctx context.Context, s logical.Storage, entity *iamEntity, callerUniqueId string) (string, error) {
+	fullArn := b.getCachedUserId(callerUniqueId)
+	if fullArn != "" {
+		return fullArn, nil
+	}
+	fullArn, err := b.fullArn(ctx, entity, s)
+	if err != nil {
+		return "", fmt.Errorf("error looking up full ARN of entity %v: %v", entity, err)
+	}
+	if fullArn == "" {
+		return "", fmt.Errorf("got empty string back when looking up full ARN of entity %v", entity)
+	}
+	b.setCachedUserId(callerUniqueId, fullArn)
+	return fullArn, nil
+}
+
+// cachedUniqueID returns the unique ID of the IAM principal underlying the
+// given entity, as resolved through IAM, consulting the cache first and
+// populating it after a successful lookup
+func (b *backend) cachedUniqueID(ctx context.Context, s logical.Storage, entity *iamEntity) (string, error) {
+	canonicalArn := entity.canonicalArn()
+	if entry, ok := b.lookupCache.get(uniqueIDCacheNamespace, canonicalArn, b.clock()); ok {
+		return entry.(string), nil
+	}
+	uniqueID, err := b.resolveArnToUniqueIDFunc(ctx, s, canonicalArn)
+	if err != nil {
+		return "", fmt.Errorf("error resolving unique ID of %q: %v", canonicalArn, err)
+	}
+	b.lookupCache.set(uniqueIDCacheNamespace, canonicalArn, uniqueID, b.clock().Add(uniqueIDCacheTTL))
+	return uniqueID, nil
+}
+
+// matchedBoundPrincipalARNs returns every entry in boundPrincipalARNs which
+// matches the caller, either exactly (by its canonical or full ARN) or as a
+// pattern against the full ARN. patterns holds the compiled patterns among
+// the entries.
+func matchedBoundPrincipalARNs(boundPrincipalARNs []string, patterns map[string]*regexp.Regexp, canonicalArn, fullArn string) []string {
+	var matched []string
+	for _, principalARN := range boundPrincipalARNs {
+		if boundPrincipalARNMatches(principalARN, patterns, canonicalArn, fullArn) {
+			matched = append(matched, principalARN)
+		}
+	}
+	return matched
+}
+
+// matchedBoundPrincipalIndex returns the index of the first entry in
+// boundPrincipalARNs which matches the caller, or -1 if none does
+func matchedBoundPrincipalIndex(boundPrincipalARNs []string, patterns map[string]*regexp.Regexp, canonicalArn, fullArn string) int {
+	for i, principalARN := range boundPrincipalARNs {
+		if boundPrincipalARNMatches(principalARN, patterns, canonicalArn, fullArn) {
+			return i
+		}
+	}
+	return -1
+}
+
+// boundPrincipalARNMatches returns whether an entry of bound_iam_principal_arn
+// matches the caller
+func boundPrincipalARNMatches(principalARN string, patterns map[string]*regexp.Regexp, canonicalArn, fullArn string) bool {
+	if isBoundPrincipalARNPattern(principalARN) {
+		return boundPrincipalPatternMatches(principalARN, patterns, fullArn)
+	}
+	return principalARN == canonicalArn || principalARN == fullArn
+}
+
+// Prefixes of the bound_iam_principal_arn entries which are wildcard patterns
+// or regular expressions rather than ARNs. Patterns have to be marked as such:
+// the path of an ARN may contain '*' and '?'.
+const (
+	principalARNGlobPrefix  = "glob:"
+	principalARNRegexPrefix = "regex:"
+)
+
+// isBoundPrincipalARNPattern returns whether an entry of bound_iam_principal_arn
+// is a pattern rather than an ARN. Besides the prefixed patterns, an entry
+// ending with '*' matches any ARN it is a prefix of, as names of IAM
+// principals cannot contain '*'.
+func isBoundPrincipalARNPattern(principalARN string) bool {
+	return strings.HasPrefix(principalARN, principalARNGlobPrefix) ||
+		strings.HasPrefix(principalARN, principalARNRegexPrefix) ||
+		strings.HasSuffix(principalARN, "*")
+}
+
+// compileBoundPrincipalARNPattern compiles a prefixed bound_iam_principal_arn
+// pattern. In wildcard patterns '*' matches any sequence of characters and '?'
+// any single character. Both wildcard patterns and regular expressions must
+// match the whole ARN, as paths may contain the ':' separating the account
+// from the rest of the ARN.
+func compileBoundPrincipalARNPattern(pattern string) (*regexp.Regexp, error) {
+	if expr := strings.TrimPrefix(pattern, principalARNRegexPrefix); expr != pattern {
+		return regexp.Compile("^(?:" + expr + ")$")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("^")
+	for _, r := range strings.TrimPrefix(pattern, principalARNGlobPrefix) {
+		switch r {
+		case '*':
+			buf.WriteString(".*")
+		case '?':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.Compile(buf.String())
+}
+
+// compileBoundPrincipalARNPatterns compiles the prefixed patterns among the
+// bound_iam_principal_arn entries, keyed by their source
+func compileBoundPrincipalARNPatterns(principalARNs []string) (map[string]*regexp.Regexp, error) {
+	patterns := make(map[string]*regexp.Regexp)
+	for _, principalARN := range principalARNs {
+		if !strings.HasPrefix(principalARN, principalARNGlobPrefix) && !strings.HasPrefix(principalARN, principalARNRegexPrefix) {
+			continue
+		}
+		re, err := compileBoundPrincipalARNPattern(principalARN)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bound_iam_principal_arn pattern %q: %v", principalARN, err)
+		}
+		patterns[principalARN] = re
+	}
+	return patterns, nil
+}
+
+// boundPrincipalPatternMatches returns whether a bound_iam_principal_arn
+// pattern matches the full ARN of the caller. A prefixed pattern missing from
+// the compiled patterns matches nothing.
+func boundPrincipalPatternMatches(pattern string, patterns map[string]*regexp.Regexp, fullArn string) bool {
+	if fullArn == "" {
+		return false
+	}
+	if re, ok := patterns[pattern]; ok {
+		return re.MatchString(fullArn)
+	}
+	if strings.HasPrefix(pattern, principalARNGlobPrefix) || strings.HasPrefix(pattern, principalARNRegexPrefix) {
+		return false
+	}
+	return strutil.GlobbedStringsMatch(pattern, fullArn)
+}
+
+// boundPrincipalPatternPrefixLen returns the length of the literal prefix of
+// a bound_iam_principal_arn pattern, which ranks how specifically it matches.
+// Regular expressions are ranked below any wildcard pattern.
+func boundPrincipalPatternPrefixLen(pattern string) int {
+	if strings.HasPrefix(pattern, principalARNRegexPrefix) {
+		return -1
+	}
+	return strings.IndexAny(strings.TrimPrefix(pattern, principalARNGlobPrefix), "*?")
+}
+
+// roleForIamEntity selects the role a caller authenticates against when it
+// does not name one. Every iam role whose bound_iam_principal_arn entries match
+// the caller is a candidate, and the candidate with the most specific match
+// wins: an exact ARN match ranks above any pattern, and a pattern with a
+// longer literal prefix ranks above a shorter one. If the best match is shared by more
+// than one role, the selection is ambiguous and an error is returned.
+func (b *backend) roleForIamEntity(ctx context.Context, s logical.Storage, entity *iamEntity, callerUniqueId string) (string, error) {
+	b.roleMutex.RLock()
+	roleNames, err := s.List(ctx, "role/")
+	b.roleMutex.RUnlock()
+	if err != nil {
+		return "", err
+	}
+
+	candidates := make(map[string][]string)
+	patterns := make(map[string]*regexp.Regexp)
+	needFullArn := false
+	for _, roleName := range roleNames {
+		roleEntry, err := b.lockedAWSRole(ctx, s, roleName)
+		if err != nil {
+			return "", err
+		}
+		if roleEntry == nil || roleEntry.AuthType != iamAuthType || len(roleEntry.BoundIamPrincipalARNs) == 0 {
+			continue
+		}
+		candidates[roleName] = roleEntry.BoundIamPrincipalARNs
+		for source, re := range roleEntry.boundIamPrincipalARNPatterns {
+			patterns[source] = re
+		}
+		for _, principalARN := range roleEntry.BoundIamPrincipalARNs {
+			if isBoundPrincipalARNPattern(principalARN) {
+				needFullArn = true
+			}
+		}
+	}
+
+	// Patterns are matched against the full ARN, which requires an IAM API
+	// call, so only look it up when some candidate needs it
+	fullArn := ""
+	if needFullArn {
+		fullArn, err = b.cachedFullArn(ctx, s, entity, callerUniqueId)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return selectMostSpecificRole(candidates, patterns, entity.canonicalArn(), fullArn)
+}
+
+// boundARNMatch describes how specifically a bound_iam_principal_arn entry
+// matches a caller; prefixLen is only meaningful for pattern matches
+type boundARNMatch struct {
+	exact     bool
+	prefixLen int
+}
+
+// moreSpecificThan returns true if m is a more specific match than other
+func (m boundARNMatch) moreSpecificThan(other boundARNMatch) bool {
+	if m.exact || other.exact {
+		return m.exact && !other.exact
+	}
+	return m.prefixLen > other.prefixLen
+}
+
+// selectMostSpecificRole returns the name of the role whose bound principal
+// ARNs match the caller most specifically. candidates maps role names to their
+// bound_iam_principal_arn entries, and patterns holds their compiled patterns.
+// An error is returned if no role matches or if several roles share the most
+// specific match.
+func selectMostSpecificRole(candidates map[string][]string, patterns map[string]*regexp.Regexp, canonicalArn, fullArn string) (string, error) {
+	var best boundARNMatch
+	var bestRoles []string
+	for roleName, boundPrincipalARNs := range candidates {
+		matched := matchedBoundPrincipalARNs(boundPrincipalARNs, patterns, canonicalArn, fullArn)
+		if len(matched) == 0 {
+			continue
+		}
+
+		var roleBest boundARNMatch
+		for i, principalARN := range matched {
+			match := boundARNMatch{
+				exact: true,
+			}
+			if isBoundPrincipalARNPattern(principalARN) {
+				match = boundARNMatch{
+					prefixLen: boundPrincipalPatternPrefixLen(principalARN),
+				}
+			}
+			if i == 0 || match.moreSpecificThan(roleBest) {
+				roleBest = match
+			}
+		}
+
+		switch {
+		case len(bestRoles) == 0 || roleBest.moreSpecificThan(best):
+			best = roleBest
+			bestRoles = []string{roleName}
+		case !best.moreSpecificThan(roleBest):
+			bestRoles = append(bestRoles, roleName)
+		}
+	}
+
+	switch len(bestRoles) {
+	case 0:
+		return "", fmt.Errorf("no role is bound to IAM principal %q", canonicalArn)
+	case 1:
+		return bestRoles[0], nil
+	default:
+		sort.Strings(bestRoles)
+		return "", fmt.Errorf("IAM principal %q matches roles %q equally; specify the role explicitly", canonicalArn, bestRoles)
+	}
+}
+
+// validateRequestBodySize ensures that the signed request body does not
+// exceed the maximum size set on the role or, failing that, on the client
+// configuration. A limit of 0 means no limit.
+func validateRequestBodySize(body string, config *clientConfig, roleEntry *awsRoleEntry) error {
+	limit := roleEntry.MaxRequestBodySize
+	if limit == 0 && config != nil {
+		limit = config.MaxRequestBodySize
+	}
+	if limit > 0 && len(body) > limit {
+		return fmt.Errorf("iam_request_body of %d bytes exceeds the maximum size of %d bytes", len(body), limit)
+	}
+	return nil
+}
+
+// These two methods (hasValuesFor*) return two bools
+// The first is a hasAll, that is, does the request have all the values
+// necessary for this auth method
+// The second is a hasAny, that is, does the request have any of the fields
+// exclusive to this auth method
+func hasValuesForEc2Auth(data *framework.FieldData) (bool, bool) {
+	_, hasPkcs7 := data.GetOk("pkcs7")
+	_, hasIdentity := data.GetOk("identity")
+	_, hasSignature := data.GetOk("signature")
+	return (hasPkcs7 || (hasIdentity && hasSignature)), (hasPkcs7 || hasIdentity || hasSignature)
+}
+
+func hasValuesForIamAuth(data *framework.FieldData) (bool, bool) {
+	_, hasRequestMethod := data.GetOk("iam_http_request_method")
+	_, hasRequestURL := data.GetOk("iam_request_url")
 	_, hasRequestBody := data.GetOk("iam_request_body")
 	_, hasRequestHeaders := data.GetOk("iam_request_headers")
 	return (hasRequestMethod && hasRequestURL && hasRequestBody && hasRequestHeaders),
 		(hasRequestMethod || hasRequestURL || hasRequestBody || hasRequestHeaders)
 }
 
+var accountIDRegex = regexp.MustCompile(`^[0-9]{12}$`)
+
+// normalizeAccountID strips the hyphens and whitespace which some tools use
+// to format AWS account IDs, such as "1234-5678-9012", and ensures that the
+// result is a 12 digit account ID
+func normalizeAccountID(accountID string) (string, error) {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, accountID)
+	if !accountIDRegex.MatchString(normalized) {
+		return "", fmt.Errorf("invalid AWS account ID %q; expected 12 digits", accountID)
+	}
+	return normalized, nil
+}
+
 func parseIamArn(iamArn string) (*iamEntity, error) {
 	// iamArn should look like one of the following:
 	// 1. arn:aws:iam::<account_id>:<entity_type>/<UserName>
@@ -1366,6 +3000,15 @@ func parseIamArn(iamArn string) (*iamEntity, error) {
 	}
 	// fullParts[3] is the region, which doesn't matter for AWS IAM entities
 	entity.AccountNumber = fullParts[4]
+	// The root user of an account has no name, e.g. arn:aws:iam::<account_id>:root
+	if fullParts[5] == rootEntityType {
+		if fullParts[2] != "iam" {
+			return nil, fmt.Errorf("unrecognized arn: root principal of service %q, expected iam", fullParts[2])
+		}
+		entity.Type = rootEntityType
+		entity.FriendlyName = rootEntityType
+		return &entity, nil
+	}
 	// fullParts[5] would now be something like user/<UserName> or assumed-role/<RoleName>/<RoleSessionName>
 	parts := strings.Split(fullParts[5], "/")
 	if len(parts) < 2 {
@@ -1379,6 +3022,9 @@ func parseIamArn(iamArn string) (*iamEntity, error) {
 	case "assumed-role":
 		// Assumed roles don't have paths and have a slightly different format
 		// parts[2] is <RoleSessionName>
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("unrecognized arn: %q is not of the form assumed-role/<RoleName>/<RoleSessionName>", fullParts[5])
+		}
 		entity.Path = ""
 		entity.FriendlyName = parts[1]
 		entity.SessionInfo = parts[2]
@@ -1391,41 +3037,369 @@ func parseIamArn(iamArn string) (*iamEntity, error) {
 	return &entity, nil
 }
 
-func validateVaultHeaderValue(headers http.Header, requestUrl *url.URL, requiredHeaderValue string) error {
-	providedValue := ""
-	for k, v := range headers {
-		if strings.ToLower(iamServerIdHeader) == strings.ToLower(k) {
-			providedValue = strings.Join(v, ",")
-			break
-		}
+// validateRequestURLQuery ensures that the signed request carries no query
+// string. The GetCallerIdentity request is sent in the POST body, so a query
+// string serves no purpose and may indicate a crafted request. Presigned
+// requests, which carry their signature in the query string, are not
+// supported.
+func validateRequestURLQuery(requestUrl *url.URL) error {
+	if requestUrl.RawQuery != "" || requestUrl.ForceQuery {
+		return fmt.Errorf("unexpected query string %q", requestUrl.RawQuery)
 	}
+	return nil
+}
+
+// validateVaultHeaderValue ensures that the request carries the expected
+// value of the server ID header, and that the header is signed. If
+// requireSignedHost is set, the Host header must be signed as well.
+func validateVaultHeaderValue(headers http.Header, requestUrl *url.URL, acceptedHeaderValues []string, requireSignedHost bool) error {
+	providedValue := strings.Join(headerValues(headers, iamServerIdHeader), ",")
 	if providedValue == "" {
-		return fmt.Errorf("missing header %q", iamServerIdHeader)
+		return failLogin(loginReasonMissingServerIDHeader, fmt.Errorf("missing header %q", iamServerIdHeader))
 	}
 
 	// NOT doing a constant time compare here since the value is NOT intended to be secret
-	if providedValue != requiredHeaderValue {
-		return fmt.Errorf("expected %q but got %q", requiredHeaderValue, providedValue)
+	if !strutil.StrListContains(acceptedHeaderValues, providedValue) {
+		if len(acceptedHeaderValues) == 1 {
+			return failLogin(loginReasonInvalidServerIDHeader, fmt.Errorf("expected %q but got %q", acceptedHeaderValues[0], providedValue))
+		}
+		return failLogin(loginReasonInvalidServerIDHeader, fmt.Errorf("expected one of %q but got %q", acceptedHeaderValues, providedValue))
+	}
+
+	signedHeaders, err := authorizationSignedHeaders(headers)
+	if err != nil {
+		return err
+	}
+	if err := ensureHeaderIsSigned(signedHeaders, iamServerIdHeader); err != nil {
+		return failLogin(loginReasonUnsignedHeader, err)
+	}
+	if requireSignedHost {
+		return validateHostHeaderSigned(headers)
+	}
+	return nil
+}
+
+// validateHostHeaderSigned ensures that the Host header is listed in the
+// SignedHeaders of the Authorization header, so that the request cannot be
+// redirected to another host without invalidating its signature
+func validateHostHeaderSigned(headers http.Header) error {
+	signedHeaders, err := authorizationSignedHeaders(headers)
+	if err != nil {
+		return err
+	}
+	if err := ensureHeaderIsSigned(signedHeaders, "Host"); err != nil {
+		return failLogin(loginReasonUnsignedHeader, fmt.Errorf("header %q wasn't signed", "Host"))
+	}
+	return nil
+}
+
+// validateFormContentType ensures that the request declares the form encoded
+// Content-Type of GetCallerIdentity request bodies, and that the header is
+// covered by the signature
+func validateFormContentType(headers http.Header) error {
+	contentTypes := headerValues(headers, "Content-Type")
+	if len(contentTypes) != 1 {
+		return fmt.Errorf("expected exactly one Content-Type header, got %d", len(contentTypes))
+	}
+	mediaType, _, err := mime.ParseMediaType(contentTypes[0])
+	if err != nil {
+		return errwrap.Wrapf("error parsing Content-Type header: {{err}}", err)
+	}
+	if mediaType != formContentType {
+		return fmt.Errorf("unexpected Content-Type %q; expected %q", mediaType, formContentType)
+	}
+
+	signedHeaders, err := authorizationSignedHeaders(headers)
+	if err != nil {
+		return err
+	}
+	if err := ensureHeaderIsSigned(signedHeaders, "Content-Type"); err != nil {
+		return failLogin(loginReasonUnsignedHeader, fmt.Errorf("header %q wasn't signed", "Content-Type"))
+	}
+	return nil
+}
+
+// validateSignedHeaderCount ensures that the SignedHeaders of the request
+// list at most maxSignedHeaders headers
+func validateSignedHeaderCount(headers http.Header, maxSignedHeaders int) error {
+	signedHeaders, err := authorizationSignedHeaders(headers)
+	if err != nil {
+		return err
+	}
+	if count := len(strings.Split(signedHeaders, ";")); count > maxSignedHeaders {
+		return fmt.Errorf("%d headers are signed, more than the maximum of %d", count, maxSignedHeaders)
+	}
+	return nil
+}
+
+// validateTemporaryCredentials ensures that the request was signed with
+// temporary credentials. Requests signed with those carry a security token,
+// which must itself be covered by the signature, whereas requests signed with
+// the long-term keys of an IAM user do not.
+func validateTemporaryCredentials(headers http.Header) error {
+	providedToken := strings.Join(headerValues(headers, amzSecurityTokenHeader), ",")
+	if providedToken == "" {
+		return fmt.Errorf("missing header %q; the request must be signed with temporary credentials", amzSecurityTokenHeader)
+	}
+
+	signedHeaders, err := authorizationSignedHeaders(headers)
+	if err != nil {
+		return err
+	}
+	if err := ensureHeaderIsSigned(signedHeaders, amzSecurityTokenHeader); err != nil {
+		return failLogin(loginReasonUnsignedHeader, fmt.Errorf("header %q wasn't signed", amzSecurityTokenHeader))
+	}
+	return nil
+}
+
+// validateAuthorizationAlgorithm ensures that the Authorization header uses
+// the SigV4 signing algorithm, which is the only one STS accepts for
+// GetCallerIdentity requests
+func validateAuthorizationAlgorithm(authzHeader string) error {
+	algorithm := strings.SplitN(strings.TrimSpace(authzHeader), " ", 2)[0]
+	if algorithm != sigV4Algorithm {
+		return fmt.Errorf("unsupported signature algorithm %q in Authorization header; only %q is accepted", algorithm, sigV4Algorithm)
+	}
+	return nil
+}
+
+// headerValues returns the values of the named header, matching header names
+// case-insensitively as headers may not have been canonicalized. Values of
+// keys which only differ in case are combined in a deterministic order.
+func headerValues(headers http.Header, name string) []string {
+	var keys []string
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var values []string
+	for _, k := range keys {
+		values = append(values, headers[k]...)
 	}
+	return values
+}
 
-	if authzHeaders, ok := headers["Authorization"]; ok {
+// authorizationSignedHeaders extracts the list of signed headers from the
+// Authorization header of a SigV4 signed request
+func authorizationSignedHeaders(headers http.Header) (string, error) {
+	if authzHeaders := headerValues(headers, "Authorization"); len(authzHeaders) > 0 {
 		// authzHeader looks like AWS4-HMAC-SHA256 Credential=AKI..., SignedHeaders=host;x-amz-date;x-vault-awsiam-id, Signature=...
 		// We need to extract out the SignedHeaders
 		re := regexp.MustCompile(".*SignedHeaders=([^,]+)")
 		authzHeader := strings.Join(authzHeaders, ",")
+		if err := validateAuthorizationAlgorithm(authzHeader); err != nil {
+			return "", err
+		}
 		matches := re.FindSubmatch([]byte(authzHeader))
 		if len(matches) < 1 {
-			return fmt.Errorf("vault header wasn't signed")
+			return "", fmt.Errorf("no SignedHeaders component in Authorization header")
 		}
 		if len(matches) > 2 {
-			return fmt.Errorf("found multiple SignedHeaders components")
+			return "", fmt.Errorf("found multiple SignedHeaders components")
 		}
-		signedHeaders := string(matches[1])
-		return ensureHeaderIsSigned(signedHeaders, iamServerIdHeader)
+		return string(matches[1]), nil
 	}
 	// TODO: If we support GET requests, then we need to parse the X-Amz-SignedHeaders
 	// argument out of the query string and search in there for the header value
-	return fmt.Errorf("missing Authorization header")
+	return "", fmt.Errorf("missing Authorization header")
+}
+
+// Regions a request can be signed for; checked before a region is used in the
+// host name of an STS endpoint
+var signingRegionRegex = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// authorizationSigningRegion extracts the region of the credential scope from
+// the Authorization header of a SigV4 signed request, which looks like
+// Credential=AKI.../20150830/us-east-1/sts/aws4_request
+func authorizationSigningRegion(headers http.Header) (string, error) {
+	authzHeaders := headerValues(headers, "Authorization")
+	if len(authzHeaders) == 0 {
+		return "", fmt.Errorf("missing Authorization header")
+	}
+	re := regexp.MustCompile(`Credential=[^/,\s]+/[^/,\s]+/([^/,\s]+)/`)
+	matches := re.FindAllStringSubmatch(strings.Join(authzHeaders, ","), -1)
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no Credential component in Authorization header")
+	}
+	if len(matches) > 1 {
+		return "", fmt.Errorf("found multiple Credential components")
+	}
+	region := matches[0][1]
+	if !signingRegionRegex.MatchString(region) {
+		return "", fmt.Errorf("invalid region %q in Authorization header", region)
+	}
+	return region, nil
+}
+
+// regionalSTSEndpoint returns the STS endpoint of the given region. Requests
+// signed for us-east-1 keep being sent to the global endpoint, which is in
+// that region. Regions of the China partition use its own domain.
+func regionalSTSEndpoint(region string) (string, error) {
+	if !signingRegionRegex.MatchString(region) {
+		return "", fmt.Errorf("invalid region %q", region)
+	}
+	if region == defaultSTSRegion {
+		return defaultSTSEndpoint, nil
+	}
+	dnsSuffix := "amazonaws.com"
+	if strings.HasPrefix(region, "cn-") {
+		dnsSuffix = "amazonaws.com.cn"
+	}
+	return fmt.Sprintf("https://sts.%s.%s", region, dnsSuffix), nil
+}
+
+// countInstanceLogin counts a login of an instance against the
+// max_logins_per_instance of the role, in the identity whitelist entry of the
+// instance, and returns an error if the limit has been reached within the
+// current login_rate_window. A new window starts with the first login after
+// the previous one has elapsed.
+func countInstanceLogin(storedIdentity *whitelistIdentity, roleEntry *awsRoleEntry, now time.Time) error {
+	if roleEntry.MaxLoginsPerInstance <= 0 {
+		return nil
+	}
+	if storedIdentity.LoginWindowStart.IsZero() || !now.Before(storedIdentity.LoginWindowStart.Add(roleEntry.LoginRateWindow)) {
+		storedIdentity.LoginWindowStart = now
+		storedIdentity.LoginWindowCount = 0
+	}
+	if storedIdentity.LoginWindowCount >= roleEntry.MaxLoginsPerInstance {
+		return fmt.Errorf("logged in %d times since %s, the maximum allowed within %s", storedIdentity.LoginWindowCount, storedIdentity.LoginWindowStart.Format(time.RFC3339), roleEntry.LoginRateWindow)
+	}
+	storedIdentity.LoginWindowCount++
+	return nil
+}
+
+// Placeholders of a role_name_pattern, replaced with the values of the caller
+const (
+	roleNamePatternFriendlyName = "{{friendly_name}}"
+	roleNamePatternAccountID    = "{{account_id}}"
+)
+
+// validateRoleNamePattern ensures that a role_name_pattern only uses known
+// placeholders
+func validateRoleNamePattern(pattern string) error {
+	remainder := strings.NewReplacer(roleNamePatternFriendlyName, "", roleNamePatternAccountID, "").Replace(pattern)
+	if strings.Contains(remainder, "{{") || strings.Contains(remainder, "}}") {
+		return fmt.Errorf("unknown placeholder in %q; expected %s or %s", pattern, roleNamePatternFriendlyName, roleNamePatternAccountID)
+	}
+	return nil
+}
+
+// validateRoleNameForCaller ensures that the name of the role matches the
+// role_name_pattern rendered for the caller. Role names are case-insensitive.
+func validateRoleNameForCaller(pattern, roleName string, entity *iamEntity) error {
+	rendered := strings.NewReplacer(
+		roleNamePatternFriendlyName, strings.ToLower(entity.FriendlyName),
+		roleNamePatternAccountID, entity.AccountNumber,
+	).Replace(pattern)
+	parts := strings.Split(rendered, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return err
+	}
+	if !re.MatchString(strings.ToLower(roleName)) {
+		return fmt.Errorf("role name %q does not match the pattern %q required for the caller", roleName, rendered)
+	}
+	return nil
+}
+
+// validateSTSEndpointAllowed ensures that the host of the STS endpoint which
+// the signed request is about to be forwarded to is one of the allowed ones.
+// Allowed entries without a port match the endpoint on any port.
+func validateSTSEndpointAllowed(endpoint string, allowedEndpoints []string) error {
+	parsedEndpoint, err := url.Parse(endpoint)
+	if err != nil || parsedEndpoint.Host == "" {
+		return fmt.Errorf("unable to determine the host of endpoint %q", endpoint)
+	}
+	host := strings.ToLower(parsedEndpoint.Host)
+	hostname := strings.ToLower(parsedEndpoint.Hostname())
+	for _, allowed := range allowedEndpoints {
+		if allowed == host || allowed == hostname {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q of the STS endpoint is not in allowed_sts_endpoints", host)
+}
+
+// Maximum length of the ARN of an IAM principal, as documented by AWS
+const maxCallerARNLength = 2048
+
+// validateCallerARNLength ensures that the ARN returned by STS is no longer
+// than maxLength, or maxCallerARNLength if maxLength is 0, before any further
+// processing of it
+func validateCallerARNLength(arn string, maxLength int) error {
+	if maxLength <= 0 {
+		maxLength = maxCallerARNLength
+	}
+	if len(arn) > maxLength {
+		return fmt.Errorf("caller ARN of %d characters exceeds the maximum of %d", len(arn), maxLength)
+	}
+	return nil
+}
+
+// validateCallerIdentityConsistency ensures that the fields of the caller
+// identity returned by STS agree with each other: the account must be the one
+// in the ARN, the user ID of a root principal is its account, and the user ID
+// of an assumed role ends with the session name of its ARN
+func validateCallerIdentityConsistency(callerID *GetCallerIdentityResult, entity *iamEntity) error {
+	if callerID.Account != entity.AccountNumber {
+		return fmt.Errorf("account %q does not match account %q of ARN %q", callerID.Account, entity.AccountNumber, callerID.Arn)
+	}
+	switch entity.Type {
+	case rootEntityType:
+		if callerID.UserId != entity.AccountNumber {
+			return fmt.Errorf("user ID %q of root principal does not match its account %q", callerID.UserId, entity.AccountNumber)
+		}
+	case "assumed-role":
+		userIDParts := strings.SplitN(callerID.UserId, ":", 2)
+		if len(userIDParts) != 2 || userIDParts[1] != entity.SessionInfo {
+			return fmt.Errorf("user ID %q does not match session %q of ARN %q", callerID.UserId, entity.SessionInfo, callerID.Arn)
+		}
+	}
+	return nil
+}
+
+// validateRequestDate ensures that the signed request was signed no longer
+// than maxAge ago, according to its X-Amz-Date header, and not further in the
+// future than the allowed clock skew
+func validateRequestDate(headers http.Header, maxAge time.Duration, now time.Time) error {
+	amzDates := headerValues(headers, "X-Amz-Date")
+	if len(amzDates) == 0 {
+		return failLogin(loginReasonInvalidRequest, fmt.Errorf("missing X-Amz-Date header"))
+	}
+	if len(amzDates) > 1 {
+		return failLogin(loginReasonInvalidRequest, fmt.Errorf("found multiple X-Amz-Date headers"))
+	}
+	signedAt, err := time.Parse(amzDateFormat, amzDates[0])
+	if err != nil {
+		return failLogin(loginReasonInvalidRequest, fmt.Errorf("invalid X-Amz-Date %q", amzDates[0]))
+	}
+	if now.Sub(signedAt) > maxAge {
+		return fmt.Errorf("request signed at %s is older than %s", signedAt.Format(time.RFC3339), maxAge)
+	}
+	if signedAt.Sub(now) > maxIAMRequestClockSkew {
+		return fmt.Errorf("request signed at %s is more than %s in the future", signedAt.Format(time.RFC3339), maxIAMRequestClockSkew)
+	}
+	return nil
+}
+
+// validateRequestMethod ensures that the method of the signed request is
+// POST, in any case, and returns it in upper case as it was signed. A request
+// signed for any other method cannot be the GetCallerIdentity call expected.
+func validateRequestMethod(method string) (string, error) {
+	if method == "" {
+		return "", fmt.Errorf("missing iam_http_request_method")
+	}
+	// In the future, might consider supporting GET
+	if !strings.EqualFold(method, http.MethodPost) {
+		return "", fmt.Errorf("invalid iam_http_request_method %q; the signed GetCallerIdentity request must use POST", method)
+	}
+	return http.MethodPost, nil
 }
 
 func buildHttpRequest(method, endpoint string, parsedUrl *url.URL, body string, headers http.Header) *http.Request {
@@ -1477,13 +3451,17 @@ func buildHttpRequest(method, endpoint string, parsedUrl *url.URL, body string,
 func ensureHeaderIsSigned(signedHeaders, headerToSign string) error {
 	// Not doing a constant time compare here, the values aren't secret
 	for _, header := range strings.Split(signedHeaders, ";") {
-		if header == strings.ToLower(headerToSign) {
+		if strings.ToLower(header) == strings.ToLower(headerToSign) {
 			return nil
 		}
 	}
 	return fmt.Errorf("vault header wasn't signed")
 }
 
+// parseGetCallerIdentityResponse parses the XML response of STS. Elements are
+// matched on their local names only, as the struct tags of the response do
+// not name a namespace, so responses whose namespace was rewritten or dropped
+// by a proxy still parse.
 func parseGetCallerIdentityResponse(response string) (GetCallerIdentityResponse, error) {
 	decoder := xml.NewDecoder(strings.NewReader(response))
 	result := GetCallerIdentityResponse{}
@@ -1496,13 +3474,37 @@ func parseIamRequestHeaders(headersB64 string) (http.Header, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to base64 decode iam_request_headers")
 	}
+	// Reject anything but a JSON object upfront, so that a malformed value
+	// fails with a precise error rather than further down the login
+	if !utf8.Valid(headersJson) {
+		return nil, fmt.Errorf("iam_request_headers is not valid UTF-8 after base64 decoding")
+	}
+	if !json.Valid(headersJson) {
+		return nil, fmt.Errorf("iam_request_headers is not valid JSON after base64 decoding")
+	}
 	var headersDecoded map[string]interface{}
 	err = jsonutil.DecodeJSON(headersJson, &headersDecoded)
 	if err != nil {
-		return nil, errwrap.Wrapf(fmt.Sprintf("failed to JSON decode iam_request_headers %q: {{err}}", headersJson), err)
+		// The headers are not quoted, as they hold the signature and the
+		// session token of the request
+		return nil, errwrap.Wrapf("failed to JSON decode iam_request_headers: {{err}}", err)
 	}
+	if headersDecoded == nil {
+		return nil, fmt.Errorf("iam_request_headers is not a JSON object after base64 decoding")
+	}
+	// Header names are case-insensitive, and intermediaries may have changed
+	// their case, so keys are canonicalized. Keys which only differ in case
+	// are merged in a deterministic order.
+	keys := make([]string, 0, len(headersDecoded))
+	for k := range headersDecoded {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
 	headers := make(http.Header)
-	for k, v := range headersDecoded {
+	for _, rawKey := range keys {
+		v := headersDecoded[rawKey]
+		k := http.CanonicalHeaderKey(rawKey)
 		switch typedValue := v.(type) {
 		case string:
 			headers.Add(k, typedValue)
@@ -1526,7 +3528,10 @@ func parseIamRequestHeaders(headersB64 string) (http.Header, error) {
 	return headers, nil
 }
 
-func submitCallerIdentityRequest(method, endpoint string, parsedUrl *url.URL, body string, headers http.Header) (*GetCallerIdentityResult, error) {
+// submitCallerIdentityRequest forwards the signed GetCallerIdentity request to
+// STS, and returns the identity of the caller along with the ID STS assigned
+// to the request, which AWS support can use to trace it
+func submitCallerIdentityRequest(method, endpoint string, parsedUrl *url.URL, body string, headers http.Header) (*GetCallerIdentityResult, string, error) {
 	// NOTE: We need to ensure we're calling STS, instead of acting as an unintended network proxy
 	// The protection against this is that this method will only call the endpoint specified in the
 	// client config (defaulting to sts.amazonaws.com), so it would require a Vault admin to override
@@ -1538,7 +3543,7 @@ func submitCallerIdentityRequest(method, endpoint string, parsedUrl *url.URL, bo
 	}
 	response, err := client.Do(request)
 	if err != nil {
-		return nil, errwrap.Wrapf("error making request: {{err}}", err)
+		return nil, "", errwrap.Wrapf("error making request: {{err}}", err)
 	}
 	if response != nil {
 		defer response.Body.Close()
@@ -1546,18 +3551,32 @@ func submitCallerIdentityRequest(method, endpoint string, parsedUrl *url.URL, bo
 	// we check for status code afterwards to also print out response body
 	responseBody, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("received error code %d from STS: %s", response.StatusCode, string(responseBody))
+		return nil, "", fmt.Errorf("received error code %d from STS: %s", response.StatusCode, string(responseBody))
 	}
 	callerIdentityResponse, err := parseGetCallerIdentityResponse(string(responseBody))
 	if err != nil {
-		return nil, fmt.Errorf("error parsing STS response")
+		return nil, "", failLogin(loginReasonInvalidSTSResponse, fmt.Errorf("error parsing STS response"))
 	}
-	return &callerIdentityResponse.GetCallerIdentityResult[0], nil
+	if len(callerIdentityResponse.GetCallerIdentityResult) == 0 {
+		return nil, "", failLogin(loginReasonInvalidSTSResponse, fmt.Errorf("error parsing STS response: no GetCallerIdentityResult"))
+	}
+	callerID := &callerIdentityResponse.GetCallerIdentityResult[0]
+	callerID.Account, err = normalizeAccountID(callerID.Account)
+	if err != nil {
+		return nil, "", failLogin(loginReasonInvalidSTSResponse, errwrap.Wrapf("error parsing STS response: {{err}}", err))
+	}
+	var requestID string
+	if len(callerIdentityResponse.ResponseMetadata) > 0 {
+		requestID = callerIdentityResponse.ResponseMetadata[0].RequestId
+	}
+	return callerID, requestID, nil
 }
 
+// The XML tags below deliberately leave out the STS namespace, see
+// parseGetCallerIdentityResponse
 type GetCallerIdentityResponse struct {
 	XMLName                 xml.Name                  `xml:"GetCallerIdentityResponse"`
 	GetCallerIdentityResult []GetCallerIdentityResult `xml:"GetCallerIdentityResult"`
@@ -1583,6 +3602,33 @@ type identityDocument struct {
 	AccountID   string                 `json:"accountId,omitempty"`
 	Region      string                 `json:"region,omitempty"`
 	PendingTime string                 `json:"pendingTime,omitempty"`
+
+	// signingCert is the certificate which verified the signature of the
+	// document; it is not part of the document itself
+	signingCert *x509.Certificate
+}
+
+// instanceExtendedAttributes holds the attributes of an EC2 instance, as
+// returned by the DescribeInstances API, which ec2.Instance does not carry
+type instanceExtendedAttributes struct {
+	InstanceId            *string                  `locationName:"instanceId" type:"string"`
+	MetadataOptions       *instanceMetadataOptions `locationName:"metadataOptions" type:"structure"`
+	CapacityReservationId *string                  `locationName:"capacityReservationId" type:"string"`
+}
+
+// instanceMetadataOptions represents the instance metadata service options of
+// an EC2 instance
+type instanceMetadataOptions struct {
+	HttpEndpoint *string `locationName:"httpEndpoint" type:"string"`
+	HttpTokens   *string `locationName:"httpTokens" type:"string"`
+}
+
+type describeInstancesExtendedOutput struct {
+	Reservations []*describeInstancesExtendedReservation `locationName:"reservationSet" locationNameList:"item" type:"list"`
+}
+
+type describeInstancesExtendedReservation struct {
+	Instances []*instanceExtendedAttributes `locationName:"instancesSet" locationNameList:"item" type:"list"`
 }
 
 // roleTagLoginResponse represents the return values required after the process
@@ -1593,6 +3639,9 @@ type roleTagLoginResponse struct {
 	DisallowReauthentication bool          `json:"disallow_reauthentication"`
 }
 
+// Type of the iamEntity of the root user of an account
+const rootEntityType = "root"
+
 type iamEntity struct {
 	Partition     string
 	AccountNumber string
@@ -1602,6 +3651,24 @@ type iamEntity struct {
 	SessionInfo   string
 }
 
+// iamPath returns the path of the entity in the form used by the IAM API,
+// beginning and ending with '/'
+func (e *iamEntity) iamPath() string {
+	if e.Path == "" {
+		return "/"
+	}
+	return "/" + e.Path + "/"
+}
+
+// roleSessionName returns the name of the session of an assumed role, which
+// is the last part of its ARN, or "" for other entities
+func (e *iamEntity) roleSessionName() string {
+	if e.Type != "assumed-role" {
+		return ""
+	}
+	return e.SessionInfo
+}
+
 // Returns a Vault-internal canonical ARN for referring to an IAM entity
 func (e *iamEntity) canonicalArn() string {
 	entityType := e.Type
@@ -1614,9 +3681,27 @@ func (e *iamEntity) canonicalArn() string {
 	// make an AWS API call to look up the role by FriendlyName, which introduces more complexity to
 	// code and test, and it also breaks backwards compatibility in an area where we would really want
 	// it
+	if entityType == rootEntityType {
+		return fmt.Sprintf("arn:%s:iam::%s:root", e.Partition, e.AccountNumber)
+	}
 	return fmt.Sprintf("arn:%s:iam::%s:%s/%s", e.Partition, e.AccountNumber, entityType, e.FriendlyName)
 }
 
+// isServiceLinkedRole returns true if the entity is, or is a session of, an
+// AWS service-linked role. Such roles always live under the aws-service-role
+// path; as assumed-role ARNs carry no path, their sessions are recognized by
+// the AWSServiceRoleFor prefix which AWS gives the role names instead.
+func (e *iamEntity) isServiceLinkedRole() bool {
+	switch e.Type {
+	case "role":
+		return e.Path == serviceLinkedRolePath || strings.HasPrefix(e.Path, serviceLinkedRolePath+"/")
+	case "assumed-role":
+		return strings.HasPrefix(e.FriendlyName, serviceLinkedRoleNamePrefix)
+	default:
+		return false
+	}
+}
+
 // This returns the "full" ARN of an iamEntity, how it would be referred to in AWS proper
 func (b *backend) fullArn(ctx context.Context, e *iamEntity, s logical.Storage) (string, error) {
 	// Not assuming path is reliable for any entity types
@@ -1638,6 +3723,9 @@ func (b *backend) fullArn(ctx context.Context, e *iamEntity, s logical.Storage)
 			return "", fmt.Errorf("nil response from GetUser")
 		}
 		return *(resp.User.Arn), nil
+	case rootEntityType:
+		// The root user has no path, so its ARN is already complete
+		return e.canonicalArn(), nil
 	case "assumed-role":
 		fallthrough
 	case "role":
@@ -1657,8 +3745,374 @@ func (b *backend) fullArn(ctx context.Context, e *iamEntity, s logical.Storage)
 	}
 }
 
+// principalTags returns the tags of the IAM user or role underlying the given
+// entity. The tagging APIs are not modeled by the vendored AWS SDK, so the
+// requests are built directly.
+func (b *backend) principalTags(ctx context.Context, s logical.Storage, e *iamEntity) (map[string]string, error) {
+	client, err := b.clientIAM(ctx, s, getAnyRegionForAwsPartition(e.Partition).ID(), e.AccountNumber)
+	if err != nil {
+		return nil, errwrap.Wrapf("error creating IAM client: {{err}}", err)
+	}
+
+	input := &listPrincipalTagsInput{}
+	operation := ""
+	switch e.Type {
+	case "user":
+		operation = "ListUserTags"
+		input.UserName = aws.String(e.FriendlyName)
+	case "assumed-role":
+		fallthrough
+	case "role":
+		operation = "ListRoleTags"
+		input.RoleName = aws.String(e.FriendlyName)
+	default:
+		return nil, fmt.Errorf("unrecognized entity type: %s", e.Type)
+	}
+
+	tags := make(map[string]string)
+	for {
+		output := &listPrincipalTagsOutput{}
+		req := client.NewRequest(&request.Operation{
+			Name:       operation,
+			HTTPMethod: "POST",
+			HTTPPath:   "/",
+		}, input, output)
+		if err := req.Send(); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("error fetching tags of %s %q: {{err}}", e.Type, e.FriendlyName), err)
+		}
+		for _, tag := range output.Tags {
+			if tag == nil || tag.Key == nil || tag.Value == nil {
+				continue
+			}
+			tags[*tag.Key] = *tag.Value
+		}
+		if output.IsTruncated == nil || !*output.IsTruncated || output.Marker == nil {
+			break
+		}
+		input.Marker = output.Marker
+	}
+	return tags, nil
+}
+
+// permissionsBoundary returns the ARN of the permissions boundary attached to
+// the IAM user or role underlying the given entity, or an empty string if there
+// is none. The vendored AWS SDK does not model permissions boundaries, so the
+// requests are built directly.
+func (b *backend) permissionsBoundary(ctx context.Context, s logical.Storage, e *iamEntity) (string, error) {
+	client, err := b.clientIAM(ctx, s, getAnyRegionForAwsPartition(e.Partition).ID(), e.AccountNumber)
+	if err != nil {
+		return "", errwrap.Wrapf("error creating IAM client: {{err}}", err)
+	}
+
+	var input interface{}
+	operation := ""
+	switch e.Type {
+	case "user":
+		operation = "GetUser"
+		input = &iam.GetUserInput{UserName: aws.String(e.FriendlyName)}
+	case "assumed-role":
+		fallthrough
+	case "role":
+		operation = "GetRole"
+		input = &iam.GetRoleInput{RoleName: aws.String(e.FriendlyName)}
+	default:
+		return "", fmt.Errorf("unrecognized entity type: %s", e.Type)
+	}
+
+	output := &getPrincipalOutput{}
+	req := client.NewRequest(&request.Operation{
+		Name:       operation,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}, input, output)
+	if err := req.Send(); err != nil {
+		return "", errwrap.Wrapf(fmt.Sprintf("error fetching %s %q: {{err}}", e.Type, e.FriendlyName), err)
+	}
+
+	principal := output.User
+	if principal == nil {
+		principal = output.Role
+	}
+	if principal == nil {
+		return "", fmt.Errorf("nil response from %s", operation)
+	}
+	if principal.PermissionsBoundary == nil || principal.PermissionsBoundary.PermissionsBoundaryArn == nil {
+		return "", nil
+	}
+	return *principal.PermissionsBoundary.PermissionsBoundaryArn, nil
+}
+
+// principalExists reports whether the IAM user or role underlying the given
+// entity exists
+func (b *backend) principalExists(ctx context.Context, s logical.Storage, e *iamEntity) (bool, error) {
+	client, err := b.clientIAM(ctx, s, getAnyRegionForAwsPartition(e.Partition).ID(), e.AccountNumber)
+	if err != nil {
+		return false, errwrap.Wrapf("error creating IAM client: {{err}}", err)
+	}
+
+	switch e.Type {
+	case "user":
+		_, err = client.GetUserWithContext(ctx, &iam.GetUserInput{
+			UserName: aws.String(e.FriendlyName),
+		})
+	case "assumed-role":
+		fallthrough
+	case "role":
+		_, err = client.GetRoleWithContext(ctx, &iam.GetRoleInput{
+			RoleName: aws.String(e.FriendlyName),
+		})
+	default:
+		return false, fmt.Errorf("unrecognized entity type: %s", e.Type)
+	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == iam.ErrCodeNoSuchEntityException {
+		return false, nil
+	}
+	if err != nil {
+		return false, errwrap.Wrapf(fmt.Sprintf("error fetching %s %q: {{err}}", e.Type, e.FriendlyName), err)
+	}
+	return true, nil
+}
+
+// rolePath returns the path of the IAM role underlying the given entity
+func (b *backend) rolePath(ctx context.Context, s logical.Storage, e *iamEntity) (string, error) {
+	client, err := b.clientIAM(ctx, s, getAnyRegionForAwsPartition(e.Partition).ID(), e.AccountNumber)
+	if err != nil {
+		return "", errwrap.Wrapf("error creating IAM client: {{err}}", err)
+	}
+
+	output, err := client.GetRoleWithContext(ctx, &iam.GetRoleInput{
+		RoleName: aws.String(e.FriendlyName),
+	})
+	if err != nil {
+		return "", errwrap.Wrapf(fmt.Sprintf("error fetching role %q: {{err}}", e.FriendlyName), err)
+	}
+	if output == nil || output.Role == nil || output.Role.Path == nil {
+		return "", fmt.Errorf("nil response from GetRole")
+	}
+	return *output.Role.Path, nil
+}
+
+// instanceTags converts the tags in an EC2 instance description into a map
+func instanceTags(instance *ec2.Instance) map[string]string {
+	tags := make(map[string]string)
+	if instance == nil {
+		return tags
+	}
+	for _, tag := range instance.Tags {
+		if tag == nil || tag.Key == nil || tag.Value == nil {
+			continue
+		}
+		tags[*tag.Key] = *tag.Value
+	}
+	return tags
+}
+
+// instanceTypeMatches returns whether the instance type is one of the bound
+// instance types, which may end in a wildcard matching any suffix
+func instanceTypeMatches(boundInstanceTypes []string, instanceType string) bool {
+	if instanceType == "" {
+		return false
+	}
+	for _, boundInstanceType := range boundInstanceTypes {
+		switch {
+		case strings.HasSuffix(boundInstanceType, "*") && strings.HasPrefix(instanceType, boundInstanceType[:len(boundInstanceType)-1]):
+			return true
+		case instanceType == boundInstanceType:
+			return true
+		}
+	}
+	return false
+}
+
+// teamTagValue returns the value of the required team tag, failing if the tag
+// is absent or empty
+func teamTagValue(tags map[string]string, tagKey string) (string, error) {
+	team := tags[tagKey]
+	if team == "" {
+		return "", fmt.Errorf("required team tag %q is not present", tagKey)
+	}
+	return team, nil
+}
+
+// validateBoundConstraintCount ensures that the role still has at least the
+// min_bound_constraints it requires, which may not be the case for roles which
+// were stored without going through the role endpoint, such as imported ones
+func validateBoundConstraintCount(roleEntry *awsRoleEntry, roleName string) error {
+	if count := roleEntry.boundConstraintCount(); count < roleEntry.MinBoundConstraints {
+		return fmt.Errorf("role %q has %d bound constraints, fewer than its min_bound_constraints of %d", roleName, count, roleEntry.MinBoundConstraints)
+	}
+	return nil
+}
+
+// loginWindow is a parsed allowed_login_window of a role
+type loginWindow struct {
+	// Days on which the window starts, indexed by time.Weekday
+	days [7]bool
+
+	// Start and end of the window as offsets from midnight UTC; an end
+	// before the start extends the window past midnight
+	start time.Duration
+	end   time.Duration
+}
+
+var loginWindowDays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseLoginWindow parses a window such as "Mon-Fri 09:00-17:00". The days
+// are optional and default to every day of the week.
+func parseLoginWindow(spec string) (*loginWindow, error) {
+	fields := strings.Fields(spec)
+	window := &loginWindow{}
+	var timesSpec string
+	switch len(fields) {
+	case 1:
+		for day := range window.days {
+			window.days[day] = true
+		}
+		timesSpec = fields[0]
+	case 2:
+		for _, daysSpec := range strings.Split(fields[0], ",") {
+			bounds := strings.Split(daysSpec, "-")
+			if len(bounds) > 2 {
+				return nil, fmt.Errorf("invalid day range %q", daysSpec)
+			}
+			first, ok := loginWindowDays[strings.ToLower(bounds[0])]
+			if !ok {
+				return nil, fmt.Errorf("invalid day %q", bounds[0])
+			}
+			last := first
+			if len(bounds) == 2 {
+				last, ok = loginWindowDays[strings.ToLower(bounds[1])]
+				if !ok {
+					return nil, fmt.Errorf("invalid day %q", bounds[1])
+				}
+			}
+			// Ranges such as Fri-Mon wrap around the end of the week
+			for day := first; ; day = (day + 1) % 7 {
+				window.days[day] = true
+				if day == last {
+					break
+				}
+			}
+		}
+		timesSpec = fields[1]
+	default:
+		return nil, fmt.Errorf("expected optional days followed by a time range, got %q", spec)
+	}
+
+	times := strings.Split(timesSpec, "-")
+	if len(times) != 2 {
+		return nil, fmt.Errorf("invalid time range %q", timesSpec)
+	}
+	var offsets [2]time.Duration
+	for i, timeSpec := range times {
+		parsed, err := time.Parse("15:04", timeSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q, expected HH:MM", timeSpec)
+		}
+		offsets[i] = time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute
+	}
+	window.start, window.end = offsets[0], offsets[1]
+	if window.start == window.end {
+		return nil, fmt.Errorf("time range %q is empty", timesSpec)
+	}
+	return window, nil
+}
+
+// contains returns whether the given time falls within the window
+func (w *loginWindow) contains(t time.Time) bool {
+	t = t.UTC()
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && offset >= w.start && offset < w.end
+	}
+	// The window extends past midnight, so it either started today or on
+	// the previous day
+	previousDay := (day + 6) % 7
+	return (w.days[day] && offset >= w.start) || (w.days[previousDay] && offset < w.end)
+}
+
+// validateLoginWindow ensures that a login at the given time is allowed by
+// the allowed_login_window of the role, if any
+func validateLoginWindow(roleEntry *awsRoleEntry, roleName string, now time.Time) error {
+	if roleEntry.AllowedLoginWindow == "" {
+		return nil
+	}
+	window, err := parseLoginWindow(roleEntry.AllowedLoginWindow)
+	if err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("invalid allowed_login_window of role %q: {{err}}", roleName), err)
+	}
+	if !window.contains(now) {
+		return fmt.Errorf("login to role %q is not allowed outside of its allowed_login_window %q", roleName, roleEntry.AllowedLoginWindow)
+	}
+	return nil
+}
+
+// listPrincipalTagsInput is the input of the IAM ListUserTags and ListRoleTags
+// APIs; only one of UserName and RoleName is set
+type listPrincipalTagsInput struct {
+	_ struct{} `type:"structure"`
+
+	Marker   *string `type:"string"`
+	RoleName *string `type:"string"`
+	UserName *string `type:"string"`
+}
+
+// listPrincipalTagsOutput is the output of the IAM ListUserTags and
+// ListRoleTags APIs
+type listPrincipalTagsOutput struct {
+	_ struct{} `type:"structure"`
+
+	IsTruncated *bool           `type:"boolean"`
+	Marker      *string         `type:"string"`
+	Tags        []*principalTag `type:"list"`
+}
+
+type principalTag struct {
+	_ struct{} `type:"structure"`
+
+	Key   *string `type:"string"`
+	Value *string `type:"string"`
+}
+
+// getPrincipalOutput is the output of the IAM GetUser and GetRole APIs,
+// limited to the permissions boundary; only one of User and Role is set
+type getPrincipalOutput struct {
+	_ struct{} `type:"structure"`
+
+	Role *principalDescription `type:"structure"`
+	User *principalDescription `type:"structure"`
+}
+
+type principalDescription struct {
+	_ struct{} `type:"structure"`
+
+	PermissionsBoundary *attachedPermissionsBoundary `type:"structure"`
+}
+
+type attachedPermissionsBoundary struct {
+	_ struct{} `type:"structure"`
+
+	PermissionsBoundaryArn  *string `type:"string"`
+	PermissionsBoundaryType *string `type:"string"`
+}
+
 const iamServerIdHeader = "X-Vault-AWS-IAM-Server-ID"
 
+const amzSecurityTokenHeader = "X-Amz-Security-Token"
+
+const sigV4Algorithm = "AWS4-HMAC-SHA256"
+
+const formContentType = "application/x-www-form-urlencoded"
+
 const pathLoginSyn = `
 Authenticates an EC2 instance with Vault.
 `
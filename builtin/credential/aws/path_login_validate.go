@@ -0,0 +1,324 @@
+package awsauth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/helper/policyutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// Checks of a login reported by login/validate, in the order in which they
+// are performed
+const (
+	loginCheckRequest        = "request"
+	loginCheckServerIDHeader = "server_id_header"
+	loginCheckSTSEndpoint    = "sts_endpoint"
+	loginCheckRequestDate    = "request_date"
+	loginCheckSTSRequest     = "sts_request"
+	loginCheckPrincipalARN   = "principal_arn"
+	loginCheckIdentityDoc    = "identity_document"
+	loginCheckRole           = "role"
+	loginCheckInstance       = "instance"
+	loginCheckRoleBindings   = "role_bindings"
+	loginCheckPolicies       = "policies"
+)
+
+var (
+	iamLoginChecks = []string{
+		loginCheckRequest,
+		loginCheckServerIDHeader,
+		loginCheckSTSEndpoint,
+		loginCheckRequestDate,
+		loginCheckSTSRequest,
+		loginCheckPrincipalARN,
+		loginCheckRole,
+		loginCheckRoleBindings,
+		loginCheckPolicies,
+	}
+	ec2LoginChecks = []string{
+		loginCheckIdentityDoc,
+		loginCheckRole,
+		loginCheckInstance,
+		loginCheckRoleBindings,
+		loginCheckPolicies,
+	}
+)
+
+func pathLoginValidate(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "login/validate$",
+		Fields:  pathLogin(b).Fields,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathLoginValidateUpdate,
+		},
+
+		HelpSynopsis:    pathLoginValidateSyn,
+		HelpDescription: pathLoginValidateDesc,
+	}
+}
+
+type loginValidationContextKey struct{}
+
+// loginValidation records the progress of a login performed by login/validate
+type loginValidation struct {
+	passed       map[string]bool
+	role         string
+	canonicalARN string
+}
+
+// loginValidationFromContext returns the validation the login performed with
+// the given context belongs to, or nil for actual logins
+func loginValidationFromContext(ctx context.Context) *loginValidation {
+	validation, _ := ctx.Value(loginValidationContextKey{}).(*loginValidation)
+	return validation
+}
+
+// pass records that the given check of the login passed
+func (v *loginValidation) pass(check string) {
+	if v == nil {
+		return
+	}
+	if v.passed == nil {
+		v.passed = make(map[string]bool)
+	}
+	v.passed[check] = true
+}
+
+// resolveRole records the name of the role the login is attempted against
+func (v *loginValidation) resolveRole(roleName string) {
+	if v != nil {
+		v.role = roleName
+	}
+}
+
+// resolvePrincipal records the IAM principal returned by STS
+func (v *loginValidation) resolvePrincipal(entity *iamEntity) {
+	if v != nil {
+		v.canonicalARN = entity.canonicalArn()
+	}
+}
+
+// pathLoginValidateUpdate performs a login with the given data without
+// issuing a token, and reports which of its checks passed. Storage is not
+// mutated, so validating a login neither whitelists an instance nor records
+// a signed request as used.
+func (b *backend) pathLoginValidateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.lockedClientConfigEntry(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	authType, err := loginAuthType(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	validation := &loginValidation{}
+	ctx = context.WithValue(ctx, loginValidationContextKey{}, validation)
+	dryRunReq := *req
+	dryRunReq.Storage = newDryRunStorage(req.Storage)
+
+	var loginResp *logical.Response
+	var checks []string
+	switch authType {
+	case ec2AuthType:
+		loginResp, err = b.pathLoginUpdateEc2(ctx, &dryRunReq, data)
+		checks = ec2LoginChecks
+	case iamAuthType:
+		loginResp, err = b.pathLoginUpdateIam(ctx, &dryRunReq, data)
+		checks = iamLoginChecks
+	}
+	notConfigured := isNotConfiguredError(err)
+	if err != nil && !notConfigured {
+		return nil, err
+	}
+
+	loginError := ""
+	reason := loginReasonUnclassified
+	var policies []string
+	var warnings []string
+	switch {
+	case notConfigured:
+		loginError = loginRejectionError(loginReasonNotConfigured, errBackendNotConfigured)
+		reason = loginReasonNotConfigured
+	case loginResp == nil:
+		loginError = "login returned no response"
+	case loginResp.IsError():
+		if err := b.redactErrorResponseARNs(ctx, req.Storage, loginResp); err != nil {
+			return nil, err
+		}
+		loginError, _ = loginResp.Data["error"].(string)
+		reason = loginResponseReason(loginResp)
+	case loginResp.Auth == nil:
+		loginError = "login returned no auth"
+	default:
+		validation.pass(loginCheckRoleBindings)
+		warnings = loginResp.Warnings
+
+		policies = policyutil.SanitizePolicies(append([]string(nil), loginResp.Auth.Policies...), policyutil.DoNotAddDefaultPolicy)
+		unknownPolicies, err := b.unknownPolicies(ctx, config, policies)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(unknownPolicies) == 0:
+			validation.pass(loginCheckPolicies)
+		case config.UnknownPolicyAction == unknownPolicyActionReject:
+			loginError = loginRejectionError(loginReasonUnknownPolicies, fmt.Sprintf("policies %q of the role do not exist", unknownPolicies))
+			reason = loginReasonUnknownPolicies
+		default:
+			validation.pass(loginCheckPolicies)
+			warnings = append(warnings, fmt.Sprintf("policies %q of the role do not exist", unknownPolicies))
+		}
+	}
+
+	// Checks run in order and the login stops at the first failure, so the
+	// first check which did not pass is the one which failed
+	checkResults := make([]map[string]interface{}, 0, len(checks))
+	failed := false
+	for _, check := range checks {
+		result := map[string]interface{}{
+			"name": check,
+		}
+		switch {
+		case validation.passed[check]:
+			result["status"] = "passed"
+		case !failed:
+			result["status"] = "failed"
+			result["error"] = loginError
+			failed = true
+		default:
+			result["status"] = "not_run"
+		}
+		checkResults = append(checkResults, result)
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"valid":         loginError == "",
+			"auth_type":     authType,
+			"role":          validation.role,
+			"canonical_arn": validation.canonicalARN,
+			"checks":        checkResults,
+		},
+		Warnings: warnings,
+	}
+	if loginError != "" {
+		resp.Data["error"] = loginError
+		resp.Data["reason"] = string(reason)
+	} else {
+		resp.Data["policies"] = policies
+	}
+	return resp, nil
+}
+
+// dryRunStorage serves reads from the underlying storage, but keeps writes in
+// memory so that they are discarded along with it
+type dryRunStorage struct {
+	logical.Storage
+
+	l sync.Mutex
+	// Entries written or deleted, the latter being nil
+	entries map[string]*logical.StorageEntry
+}
+
+func newDryRunStorage(s logical.Storage) *dryRunStorage {
+	return &dryRunStorage{
+		Storage: s,
+		entries: make(map[string]*logical.StorageEntry),
+	}
+}
+
+func (s *dryRunStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := s.Storage.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	listed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		listed[key] = true
+	}
+	for key, entry := range s.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		key = strings.TrimPrefix(key, prefix)
+		switch i := strings.Index(key, "/"); {
+		case i >= 0:
+			listed[key[:i+1]] = true
+		case entry == nil:
+			delete(listed, key)
+		default:
+			listed[key] = true
+		}
+	}
+
+	keys = make([]string, 0, len(listed))
+	for key := range listed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *dryRunStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	s.l.Lock()
+	entry, ok := s.entries[key]
+	s.l.Unlock()
+	if !ok {
+		return s.Storage.Get(ctx, key)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	return &logical.StorageEntry{
+		Key:      entry.Key,
+		Value:    append([]byte(nil), entry.Value...),
+		SealWrap: entry.SealWrap,
+	}, nil
+}
+
+func (s *dryRunStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.entries[entry.Key] = &logical.StorageEntry{
+		Key:      entry.Key,
+		Value:    append([]byte(nil), entry.Value...),
+		SealWrap: entry.SealWrap,
+	}
+	return nil
+}
+
+func (s *dryRunStorage) Delete(ctx context.Context, key string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.entries[key] = nil
+	return nil
+}
+
+const pathLoginValidateSyn = `
+Validates a login without issuing a token.
+`
+
+const pathLoginValidateDesc = `
+This endpoint takes the same data as the login endpoint and performs all the
+checks of a login, including the call to AWS STS for the iam auth type, but
+does not issue a token nor modify the storage of the backend. Instead, it
+returns whether the login would succeed, the role and canonical ARN it
+resolved, the policies the token would get, and the status of each check:
+"passed", "failed" along with the error, or "not_run" when an earlier check
+failed. An invalid login also returns the reason code the login endpoint
+would have rejected it with.
+
+Unlike the login endpoint, this endpoint requires a Vault token.
+`
@@ -0,0 +1,230 @@
+package awsauth
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func testValidateLogin(t *testing.T, b *backend, storage logical.Storage, loginData map[string]interface{}) *logical.Response {
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login/validate",
+		Data:      loginData,
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("failed to validate login: resp:%#v err:%v", resp, err)
+	}
+	if resp.Auth != nil {
+		t.Fatalf("bad: expected no auth from a login validation, got %#v", resp.Auth)
+	}
+	return resp
+}
+
+// testCheckStatuses returns the status of each check of a login validation
+func testCheckStatuses(t *testing.T, resp *logical.Response) map[string]string {
+	statuses := make(map[string]string)
+	for _, check := range resp.Data["checks"].([]map[string]interface{}) {
+		statuses[check["name"].(string)] = check["status"].(string)
+		if check["status"] == "failed" && check["error"] != resp.Data["error"] {
+			t.Fatalf("bad: expected the error of the failed check %q to be %q, got %q", check["name"], resp.Data["error"], check["error"])
+		}
+	}
+	return statuses
+}
+
+func TestBackend_pathLoginValidate_iam(t *testing.T) {
+	b, storage, cleanup := testIamLoginBackend(t, "arn:aws:iam::123456789012:user/Bob", map[string]interface{}{
+		"policies": "dev,prod",
+	})
+	defer cleanup()
+
+	resp := testValidateLogin(t, b, storage, testIamLoginData("iamrole"))
+	if resp.Data["valid"] != true {
+		t.Fatalf("bad: expected the login to be valid, got %#v", resp.Data)
+	}
+	if resp.Data["auth_type"] != iamAuthType || resp.Data["role"] != "iamrole" {
+		t.Fatalf("bad: expected an iam login to role %q, got %#v", "iamrole", resp.Data)
+	}
+	if resp.Data["canonical_arn"] != "arn:aws:iam::123456789012:user/Bob" {
+		t.Fatalf("bad: canonical_arn: %#v", resp.Data["canonical_arn"])
+	}
+	if !reflect.DeepEqual(resp.Data["policies"], []string{"dev", "prod"}) {
+		t.Fatalf("bad: policies: %#v", resp.Data["policies"])
+	}
+	for _, check := range iamLoginChecks {
+		if status := testCheckStatuses(t, resp)[check]; status != "passed" {
+			t.Fatalf("bad: expected check %q to pass, got %q", check, status)
+		}
+	}
+
+	// A principal which is not bound to the role is resolved, but fails
+	// the bindings of the role
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/iamrole",
+		Data: map[string]interface{}{
+			"bound_iam_principal_arn": "arn:aws:iam::123456789012:user/Alice",
+		},
+		Storage: storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to update role: resp:%#v err:%v", resp, err)
+	}
+	resp = testValidateLogin(t, b, storage, testIamLoginData("iamrole"))
+	if resp.Data["valid"] != false || resp.Data["error"] == "" {
+		t.Fatalf("bad: expected the login to be invalid, got %#v", resp.Data)
+	}
+	if resp.Data["canonical_arn"] != "arn:aws:iam::123456789012:user/Bob" {
+		t.Fatalf("bad: canonical_arn: %#v", resp.Data["canonical_arn"])
+	}
+	expected := map[string]string{
+		loginCheckRequest:        "passed",
+		loginCheckServerIDHeader: "passed",
+		loginCheckSTSEndpoint:    "passed",
+		loginCheckRequestDate:    "passed",
+		loginCheckSTSRequest:     "passed",
+		loginCheckPrincipalARN:   "passed",
+		loginCheckRole:           "passed",
+		loginCheckRoleBindings:   "failed",
+		loginCheckPolicies:       "not_run",
+	}
+	if statuses := testCheckStatuses(t, resp); !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("bad: checks\nexpected: %#v\ngot: %#v", expected, statuses)
+	}
+
+	// A missing server ID header fails before the request is sent to STS
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/client",
+		Data: map[string]interface{}{
+			"iam_server_id_header_value": "vault.example.com",
+		},
+		Storage: storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to configure client: resp:%#v err:%v", resp, err)
+	}
+	resp = testValidateLogin(t, b, storage, testIamLoginData("iamrole"))
+	if resp.Data["valid"] != false || resp.Data["canonical_arn"] != "" || resp.Data["role"] != "" {
+		t.Fatalf("bad: expected the login to be invalid without resolving the caller, got %#v", resp.Data)
+	}
+	if resp.Data["reason"] != string(loginReasonMissingServerIDHeader) {
+		t.Fatalf("bad: reason: %#v", resp.Data["reason"])
+	}
+	expected = map[string]string{
+		loginCheckRequest:        "passed",
+		loginCheckServerIDHeader: "failed",
+		loginCheckSTSEndpoint:    "not_run",
+		loginCheckRequestDate:    "not_run",
+		loginCheckSTSRequest:     "not_run",
+		loginCheckPrincipalARN:   "not_run",
+		loginCheckRole:           "not_run",
+		loginCheckRoleBindings:   "not_run",
+		loginCheckPolicies:       "not_run",
+	}
+	if statuses := testCheckStatuses(t, resp); !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("bad: checks\nexpected: %#v\ngot: %#v", expected, statuses)
+	}
+}
+
+func TestBackend_pathLoginValidate_ec2(t *testing.T) {
+	b, storage, loginData, cleanup := testEc2LoginBackend(t, nil)
+	defer cleanup()
+
+	resp := testValidateLogin(t, b, storage, loginData)
+	if resp.Data["valid"] != true || resp.Data["auth_type"] != ec2AuthType || resp.Data["role"] != "ec2role" {
+		t.Fatalf("bad: expected a valid ec2 login to role %q, got %#v", "ec2role", resp.Data)
+	}
+	for _, check := range ec2LoginChecks {
+		if status := testCheckStatuses(t, resp)[check]; status != "passed" {
+			t.Fatalf("bad: expected check %q to pass, got %q", check, status)
+		}
+	}
+
+	// The instance is not whitelisted, so it can still login without a
+	// nonce afterwards
+	identities, err := storage.List(context.Background(), "whitelist/identity/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 0 {
+		t.Fatalf("bad: expected validating a login not to whitelist the instance, got %v", identities)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Data:      loginData,
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("failed to login after validating the login: resp:%#v err:%v", resp, err)
+	}
+}
+
+func TestBackend_dryRunStorage(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	for _, key := range []string{"a/1", "a/2", "b"} {
+		if err := storage.Put(ctx, &logical.StorageEntry{Key: key, Value: []byte(key)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := newDryRunStorage(storage)
+	if err := s.Put(ctx, &logical.StorageEntry{Key: "a/3", Value: []byte("new")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, &logical.StorageEntry{Key: "c/1", Value: []byte("new")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, &logical.StorageEntry{Key: "b", Value: []byte("updated")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "a/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := s.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"a/", "b", "c/"}) {
+		t.Fatalf("bad: keys: %v", keys)
+	}
+	keys, err = s.List(ctx, "a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"2", "3"}) {
+		t.Fatalf("bad: keys under a/: %v", keys)
+	}
+
+	if entry, err := s.Get(ctx, "a/1"); err != nil || entry != nil {
+		t.Fatalf("bad: expected a/1 to be deleted, got %#v, err: %v", entry, err)
+	}
+	if entry, err := s.Get(ctx, "b"); err != nil || entry == nil || string(entry.Value) != "updated" {
+		t.Fatalf("bad: expected b to be updated, got %#v, err: %v", entry, err)
+	}
+	if entry, err := s.Get(ctx, "a/2"); err != nil || entry == nil || string(entry.Value) != "a/2" {
+		t.Fatalf("bad: expected a/2 to be read from the underlying storage, got %#v, err: %v", entry, err)
+	}
+
+	// None of the writes reached the underlying storage
+	if entry, err := storage.Get(ctx, "b"); err != nil || entry == nil || string(entry.Value) != "b" {
+		t.Fatalf("bad: expected b to be unchanged, got %#v, err: %v", entry, err)
+	}
+	keys, err = storage.List(ctx, "a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"1", "2"}) {
+		t.Fatalf("bad: underlying keys under a/: %v", keys)
+	}
+	if entry, err := storage.Get(ctx, "c/1"); err != nil || entry != nil {
+		t.Fatalf("bad: expected c/1 not to be written, got %#v, err: %v", entry, err)
+	}
+}
@@ -3,19 +3,26 @@ package awsauth
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/helper/policyutil"
+	"github.com/hashicorp/vault/helper/strutil"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
+	"github.com/mitchellh/copystructure"
 )
 
 var (
-	currentRoleStorageVersion = 2
+	currentRoleStorageVersion = 3
+
+	// autoCreatedRoleNameRegex matches the names accepted by the role path
+	autoCreatedRoleNameRegex = regexp.MustCompile(`^\w(([\w-.]+)?\w)?$`)
 )
 
 func pathRole(b *backend) *framework.Path {
@@ -43,11 +50,49 @@ when auth_type is ec2 or inferred_entity_type is ec2_instance.`,
 in its identity document to match one of the IDs specified by this parameter.
 This is only applicable when auth_type is ec2 or inferred_entity_type is
 ec2_instance.`,
+			},
+			"bound_reservation_owner_id": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `If set, defines a constraint on the EC2 instances that the owner ID of
+the reservation holding the instance, as returned by DescribeInstances, match
+one of the IDs specified by this parameter. This is checked independently of
+the account ID in the identity document. This is only applicable when
+auth_type is ec2 or inferred_entity_type is ec2_instance.`,
 			},
 			"bound_iam_principal_arn": {
 				Type: framework.TypeCommaStringSlice,
-				Description: `ARN of the IAM principals to bind to this role. Only applicable when
-auth_type is iam.`,
+				Description: `ARN of the IAM principals to bind to this role. An entry
+ending with '*' matches any ARN it is a prefix of. Entries prefixed with
+'glob:', in which '*' matches any sequence of characters and '?' any single
+character, or with 'regex:' for a regular expression, are patterns matched
+against the whole full ARN of the principal. Only applicable when auth_type is
+iam.`,
+			},
+			"bound_permissions_boundary_arn": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `If set, defines a constraint on the authenticating IAM principal
+that it must have one of the permissions boundaries specified by this parameter
+attached. The configured IAM user or EC2 instance role must be allowed to
+execute the 'iam:GetRole' and 'iam:GetUser' actions if this is specified. The
+attached boundary is cached for 10 minutes. Only applicable when auth_type is
+iam.`,
+			},
+			"bound_source_role_path": {
+				Type: framework.TypeString,
+				Description: `If set, defines a constraint on the authenticating IAM principal to
+be a session of an assumed IAM role whose path is the given path, or is below
+it, such as '/ci/'. As the ARNs of assumed roles do not hold the path of the
+role, it is looked up, which requires the configured IAM user or EC2 instance
+role to be allowed to execute the 'iam:GetRole' action. The path is cached for
+10 minutes. Only applicable when auth_type is iam.`,
+			},
+			"require_matching_instance_profile_path": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the path of the IAM role of the authenticating
+principal must equal the path of the instance profile of the inferred EC2
+instance. The path of the role is looked up as for bound_source_role_path. Only
+applicable when auth_type is iam and inferred_entity_type is ec2_instance.`,
 			},
 			"bound_region": {
 				Type: framework.TypeCommaStringSlice,
@@ -86,7 +131,8 @@ ec2_instance.`,
 				Description: `If set, resolve all AWS IAM ARNs into AWS's internal unique IDs.
 When an IAM entity (e.g., user, role, or instance profile) is deleted, then all references
 to it within the role will be invalidated, which prevents a new IAM entity from being created
-with the same name and matching the role's IAM binds. Once set, this cannot be unset.`,
+with the same name and matching the role's IAM binds. Once set, this cannot be unset.
+Defaults to the default_resolve_aws_unique_ids of the client configuration.`,
 			},
 			"inferred_entity_type": {
 				Type: framework.TypeString,
@@ -119,6 +165,73 @@ applicable when auth_type is ec2 or inferred_entity_type is ec2_instance.`,
 If set, defines a constraint on the EC2 instance to be associated with the
 subnet ID that matches one of the values specified by this parameter. This is
 only applicable when auth_type is ec2 or inferred_entity_type is
+ec2_instance.`,
+			},
+			"bound_security_group_id": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+If set, defines a constraint on the EC2 instance to be associated with the
+security group IDs specified by this parameter. By default, one of the groups
+is enough; see bound_security_group_match. This is only applicable when
+auth_type is ec2 or inferred_entity_type is ec2_instance.`,
+			},
+			"bound_security_group_match": {
+				Type: framework.TypeString,
+				Description: `
+Whether the EC2 instance must be associated with 'any' or 'all' of the
+security group IDs in bound_security_group_id. Defaults to 'any'.`,
+			},
+			"bound_monitoring_state": {
+				Type: framework.TypeString,
+				Description: `
+If set, defines a constraint on the EC2 instance to have detailed monitoring
+in the given state, either 'enabled' or 'disabled'. This is only applicable
+when auth_type is ec2 or inferred_entity_type is ec2_instance.`,
+			},
+			"bound_tenancy": {
+				Type: framework.TypeString,
+				Description: `
+If set, defines a constraint on the EC2 instance to run with the given
+placement tenancy, either 'default', 'dedicated' or 'host'. This is only
+applicable when auth_type is ec2 or inferred_entity_type is ec2_instance.`,
+			},
+			"bound_ebs_optimized": {
+				Type: framework.TypeString,
+				Description: `
+If set, defines a constraint on the EC2 instance to have EBS optimization
+either enabled, 'true', or disabled, 'false'. This is only applicable when
+auth_type is ec2 or inferred_entity_type is ec2_instance.`,
+			},
+			"bound_private_dns_pattern": {
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `If set, defines a constraint on the private DNS name of the EC2
+instance to match the given pattern. The pattern is matched against the whole
+name, and '*' matches any sequence of characters, such as
+'ip-10-0-*.ec2.internal'. A pattern starting with 'regex:' is instead a regular
+expression, which must also match the whole name. At most 256 characters are
+allowed. This is only applicable when auth_type is ec2 or
+inferred_entity_type is ec2_instance.`,
+			},
+			"bound_placement_group": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `If set, defines a constraint on the EC2 instance to be in a placement
+group whose name matches one of the values specified by this parameter. This
+is only applicable when auth_type is ec2 or inferred_entity_type is
+ec2_instance.`,
+			},
+			"bound_capacity_reservation_id": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `If set, defines a constraint on the EC2 instance to run in one of the
+capacity reservations whose IDs are specified by this parameter. This is only
+applicable when auth_type is ec2 or inferred_entity_type is ec2_instance.`,
+			},
+			"bound_instance_type": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `If set, defines a constraint on the EC2 instance to be of one of the
+instance types specified by this parameter. A value ending in '*' matches the
+instance types beginning with the value before the '*', e.g. 'm5.*'. This is
+only applicable when auth_type is ec2 or inferred_entity_type is
 ec2_instance.`,
 			},
 			"role_tag": {
@@ -137,17 +250,27 @@ is only allowed if auth_type is ec2.`,
 If set, indicates that the token generated using this role should never expire.
 The token should be renewed within the duration specified by this value. At
 each renewal, the token's TTL will be set to the value of this parameter.`,
+			},
+			"max_renewal_increment": {
+				Type:    framework.TypeDurationSecond,
+				Default: 0,
+				Description: `If set, caps how much a single renewal extends a token generated
+using this role: the requested increment, or the period if none is requested,
+is clamped to this value, which also caps the period of the token on login.
+Requires period to be set. Defaults to 0, meaning that renewals extend tokens
+by the period.`,
 			},
 			"ttl": {
 				Type:    framework.TypeDurationSecond,
 				Default: 0,
 				Description: `Duration in seconds after which the issued token should expire. Defaults
-to 0, in which case the value will fallback to the system/mount defaults.`,
+to 0, in which case the value will fallback to the default_ttl of the client
+configuration, or to the system/mount defaults.`,
 			},
 			"max_ttl": {
 				Type:        framework.TypeDurationSecond,
 				Default:     0,
-				Description: "The maximum allowed lifetime of tokens issued using this role.",
+				Description: "The maximum allowed lifetime of tokens issued using this role. Defaults to the default_max_ttl of the client configuration, if set.",
 			},
 			"policies": {
 				Type:        framework.TypeCommaStringSlice,
@@ -173,6 +296,255 @@ auth_type is ec2.`,
         'auth/aws-ec2/identity-whitelist/<instance_id>' endpoint. This is only
         applicable when auth_type is ec2.`,
 			},
+			"include_matched_bound_index": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the login response metadata will contain a
+'matched_bound_index' field holding the index of the first entry of
+bound_iam_principal_arn that matched the authenticating principal, so that
+reordering the entries can be detected. Resolving wildcard matches may require
+the 'iam:GetUser' or 'iam:GetRole' permissions. This is only applicable when
+auth_type is iam.`,
+			},
+			"include_matched_bound_arns": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the login response metadata will contain a
+'matched_bound_arns' field listing every entry of bound_iam_principal_arn that
+matched the authenticating principal. Resolving wildcard matches may require
+the 'iam:GetUser' or 'iam:GetRole' permissions. This is only applicable when
+auth_type is iam.`,
+			},
+			"forward_unique_id": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the login response metadata will contain a
+'unique_id' field holding the unique ID of the authenticating principal, or of
+the role of an assumed role session, as resolved through IAM, even when
+resolve_aws_unique_ids is not set. It is only recorded for auditing and does
+not affect how the principal is matched against the role. Resolving it
+requires the 'iam:GetUser' or 'iam:GetRole' permissions. This is only
+applicable when auth_type is iam.`,
+			},
+			"require_imdsv2": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, only allows EC2 instances which require session tokens
+(IMDSv2) to access their instance metadata service to login. The configured
+EC2 client must be allowed to execute the 'ec2:DescribeInstances' action. This
+is only applicable when auth_type is ec2 or inferred_entity_type is
+ec2_instance.`,
+			},
+			"forward_launch_time": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the login response metadata will contain a 'launch_time'
+field holding the launch time of the EC2 instance, as returned by
+DescribeInstances, in RFC3339 format. This is only applicable when auth_type
+is ec2 or inferred_entity_type is ec2_instance.`,
+			},
+			"require_instance_profile": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, only allows EC2 instances which have an IAM instance
+profile attached to login, independently of bound_iam_instance_profile_arn and
+bound_iam_role_arn. This is only applicable when auth_type is ec2 or
+inferred_entity_type is ec2_instance.`,
+			},
+			"require_self_owned_ami": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, only allows EC2 instances running an AMI owned by the
+account of the instance to login, so that instances running third-party AMIs
+are rejected. The configured EC2 client must be allowed to execute the
+'ec2:DescribeImages' action. This is only applicable when auth_type is ec2 or
+inferred_entity_type is ec2_instance.`,
+			},
+			"cap_ttl_to_certificate_expiry": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the max_ttl of the tokens issued by this role is capped so
+that they expire no later than the certificate which verified the signature of
+the instance identity document. This is only applicable when auth_type is ec2.`,
+			},
+			"forward_cert_fingerprint": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the login response metadata will contain a
+'cert_fingerprint' field holding the hex encoded SHA-256 fingerprint of the
+certificate which verified the signature of the instance identity document,
+so that it can be pinned downstream. This is only applicable when auth_type is
+ec2.`,
+			},
+			"forward_instance_document": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the login response metadata will contain an
+'instance_document' field holding the JSON encoded region, instanceId,
+imageId, accountId and pendingTime fields of the verified instance identity
+document. Intended for debugging. This is only applicable when auth_type is
+ec2.`,
+			},
+			"cross_check_instance": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the instance identity document must be consistent
+with the current description of the instance: its instance ID, account ID and
+AMI ID must match. This is only applicable when auth_type is ec2.`,
+			},
+			"bound_session_name_pattern": {
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `If set, a regular expression which the whole session name of
+an authenticating assumed role must match, such as 'ci-(dev|prod)-[0-9]+'.
+Callers which are not assumed roles are rejected. At most 256 characters are
+allowed. This is only applicable when auth_type is iam.`,
+			},
+			"deny_root_principal": {
+				Type:    framework.TypeBool,
+				Default: true,
+				Description: `If set, the root user of AWS accounts, whose ARN looks like
+'arn:aws:iam::123456789012:root', is not allowed to login to this role, even
+if it matches one of the bound_iam_principal_arn entries. Defaults to true.
+This is only applicable when auth_type is iam.`,
+			},
+			"deny_duplicate_bound_iam_principal_arns": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, writing bound_iam_principal_arn entries which
+refer to the same principal, such as the ARNs of a role with and without its
+path, fails instead of keeping only the first of them. This is only applicable
+when auth_type is iam.`,
+			},
+			"deny_service_linked_roles": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, AWS service-linked roles, which live under the
+'aws-service-role' path, and their sessions are not allowed to login to this
+role, even if they match one of the bound_iam_principal_arn entries. This is
+only applicable when auth_type is iam.`,
+			},
+			"require_active_principal": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the IAM user or role underlying the authenticating
+principal must still exist, so that sessions of deleted roles are rejected at
+login and renewal. The configured IAM user or EC2 instance role must be allowed
+to execute the 'iam:GetRole' and 'iam:GetUser' actions if this is specified.
+The result of the lookup is cached for 1 minute. This is only applicable when
+auth_type is iam.`,
+			},
+			"max_request_body_size": {
+				Type:    framework.TypeInt,
+				Default: 0,
+				Description: `If set, the maximum size, in bytes, of the signed request body
+accepted when logging in to this role, overriding the max_request_body_size of
+the client configuration. Defaults to 0, meaning that the client configuration
+applies. This is only applicable when auth_type is iam.`,
+			},
+			"require_temporary_credentials": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, only allows login requests signed with temporary
+credentials, such as those of an assumed role or an instance profile, which
+carry a signed X-Amz-Security-Token header. Requests signed with the long-term
+access keys of an IAM user are rejected. This is only applicable when
+auth_type is iam.`,
+			},
+			"require_management_account": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, only allows logins from the management account of
+the AWS organization which the authenticating account belongs to. The
+management account is looked up with the Organizations DescribeOrganization
+API, which the client used for the authenticating account must be allowed to
+call, and cached for an hour.`,
+			},
+			"bound_organization_id": {
+				Type: framework.TypeString,
+				Description: `If set, only allows logins from accounts of the AWS organization
+with this ID, such as o-exampleorgid. This is only applicable when auth_type
+is iam. The organization of an account is looked up with the Organizations
+DescribeAccount API, which the client configured on this backend must be
+allowed to call from the management account of the organization, and cached
+for the organization_cache_ttl of the client configuration.`,
+			},
+			"min_bound_constraints": {
+				Type:    framework.TypeInt,
+				Default: 0,
+				Description: `If set, the minimum number of bound_* constraints which must
+be configured on this role. Role updates which would leave fewer constraints
+are rejected, and so are logins if the stored role has fewer constraints.
+Defaults to 0, meaning that only a single constraint is required.`,
+			},
+			"allowed_login_window": {
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `If set, logins to this role are only allowed within this
+window of UTC time, formatted as an optional comma-separated list of days or
+day ranges followed by a start and end time, such as 'Mon-Fri 09:00-17:00' or
+'22:00-06:00'. A window ending before it starts extends past midnight, and its
+days refer to the day it starts. Defaults to an empty string, meaning that
+logins are allowed at any time.`,
+			},
+			"sts_endpoint": {
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `If set, iam logins to this role forward the signed
+GetCallerIdentity request to this STS endpoint rather than to the sts_endpoint
+of the client configuration, such as for roles of principals in another AWS
+partition. Logins must then name the role in the login request. Only
+applicable when auth_type is iam.`,
+			},
+			"iam_endpoint": {
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `If set, the IAM lookups of this role, such as the
+resolution of its bound principals, use this IAM endpoint rather than the
+iam_endpoint of the client configuration. Only applicable when auth_type is
+iam.`,
+			},
+			"allowed_signing_regions": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `If set, iam logins to this role are rejected unless the
+GetCallerIdentity request was signed for one of these regions, as given by the
+credential scope of its Authorization header. Only applicable when auth_type is
+iam.`,
+			},
+			"max_logins_per_instance": {
+				Type:    framework.TypeInt,
+				Default: 0,
+				Description: `If set, logins of an EC2 instance to this role are rejected
+once it has logged in this many times within login_rate_window, to contain
+compromised instances hammering the auth method. The count is kept in the
+identity whitelist entry of the instance. This is only applicable when
+auth_type is ec2. Defaults to 0, meaning no limit.`,
+			},
+			"login_rate_window": {
+				Type:    framework.TypeDurationSecond,
+				Default: 0,
+				Description: `Duration of the window within which the logins of an EC2
+instance are counted against max_logins_per_instance. The window starts with
+the first login counted in it. Required if max_logins_per_instance is set.`,
+			},
+			"team_tag_key": {
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `If set, the key of a tag which identifies the team owning the
+authenticating entity. For the ec2 auth_type the tag is read from the EC2
+instance; for the iam auth_type it is read from the IAM user or role. Login
+fails if the tag is not present, and its value is added to the entity alias
+metadata as 'team'. Reading IAM principal tags requires the 'iam:ListUserTags'
+or 'iam:ListRoleTags' permissions. Defaults to an empty string, meaning that
+no team tag is required.`,
+			},
+			"include_role_in_alias_metadata": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the name and auth_type of this role are added to the entity
+alias metadata as 'role' and 'auth_type' on login, so that identity policies
+can be keyed on them.`,
+			},
 		},
 
 		ExistenceCheck: b.pathRoleExistenceCheck,
@@ -248,26 +620,82 @@ func (b *backend) lockedAWSRole(ctx context.Context, s logical.Storage, roleName
 		return nil, errwrap.Wrapf("error upgrading roleEntry: {{err}}", err)
 	}
 	if needUpgrade && (b.System().LocalMount() || !b.System().ReplicationState().HasState(consts.ReplicationPerformanceSecondary)) {
-		b.roleMutex.Lock()
-		defer b.roleMutex.Unlock()
-		// Now that we have a R/W lock, we need to re-read the role entry in case it was
-		// written to between releasing the read lock and acquiring the write lock
-		roleEntry, err = b.nonLockedAWSRole(ctx, s, roleName)
-		if err != nil {
-			return nil, err
-		}
-		// somebody deleted the role, so no use in putting it back
-		if roleEntry == nil {
-			return nil, nil
-		}
-		// now re-check to see if we need to upgrade
-		if needUpgrade, err = b.upgradeRoleEntry(ctx, s, roleEntry); err != nil {
-			return nil, errwrap.Wrapf("error upgrading roleEntry: {{err}}", err)
-		}
-		if needUpgrade {
-			if err = b.nonLockedSetAWSRole(ctx, s, roleName, roleEntry); err != nil {
-				return nil, errwrap.Wrapf("error saving upgraded roleEntry: {{err}}", err)
-			}
+		return b.sharedRoleUpgrade(ctx, s, roleName)
+	}
+	return roleEntry, nil
+}
+
+// roleUpgradeCall is an upgrade of a role entry in progress, whose result is
+// shared by the concurrent readers of the role
+type roleUpgradeCall struct {
+	wg        sync.WaitGroup
+	roleEntry *awsRoleEntry
+	err       error
+}
+
+// sharedRoleUpgrade upgrades the role entry and persists it, unless an upgrade
+// of the same role is already in progress, in which case its result is waited
+// for. Each caller is given its own copy of the upgraded role entry.
+func (b *backend) sharedRoleUpgrade(ctx context.Context, s logical.Storage, roleName string) (*awsRoleEntry, error) {
+	key := strings.ToLower(roleName)
+
+	b.roleUpgradesMutex.Lock()
+	call, inProgress := b.roleUpgrades[key]
+	if !inProgress {
+		call = &roleUpgradeCall{}
+		call.wg.Add(1)
+		b.roleUpgrades[key] = call
+	}
+	b.roleUpgradesMutex.Unlock()
+
+	if inProgress {
+		call.wg.Wait()
+	} else {
+		call.roleEntry, call.err = b.upgradeAndSetAWSRole(ctx, s, roleName)
+
+		b.roleUpgradesMutex.Lock()
+		delete(b.roleUpgrades, key)
+		b.roleUpgradesMutex.Unlock()
+		call.wg.Done()
+	}
+
+	if call.err != nil || call.roleEntry == nil {
+		return nil, call.err
+	}
+	// The upgraded entry is shared, and callers may modify theirs
+	roleEntryRaw, err := copystructure.Copy(call.roleEntry)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to copy the upgraded roleEntry: {{err}}", err)
+	}
+	roleEntry := roleEntryRaw.(*awsRoleEntry)
+	// Unexported fields are not copied; compiled patterns are safe to share
+	roleEntry.boundIamPrincipalARNPatterns = call.roleEntry.boundIamPrincipalARNPatterns
+	return roleEntry, nil
+}
+
+// upgradeAndSetAWSRole upgrades the role entry in storage, if it still needs
+// to be, under the write lock
+func (b *backend) upgradeAndSetAWSRole(ctx context.Context, s logical.Storage, roleName string) (*awsRoleEntry, error) {
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+	// Now that we have a R/W lock, we need to re-read the role entry in case it was
+	// written to between releasing the read lock and acquiring the write lock
+	roleEntry, err := b.nonLockedAWSRole(ctx, s, roleName)
+	if err != nil {
+		return nil, err
+	}
+	// somebody deleted the role, so no use in putting it back
+	if roleEntry == nil {
+		return nil, nil
+	}
+	// now re-check to see if we need to upgrade
+	needUpgrade, err := b.upgradeRoleEntry(ctx, s, roleEntry)
+	if err != nil {
+		return nil, errwrap.Wrapf("error upgrading roleEntry: {{err}}", err)
+	}
+	if needUpgrade {
+		if err = b.nonLockedSetAWSRole(ctx, s, roleName, roleEntry); err != nil {
+			return nil, errwrap.Wrapf("error saving upgraded roleEntry: {{err}}", err)
 		}
 	}
 	return roleEntry, nil
@@ -290,6 +718,89 @@ func (b *backend) lockedSetAWSRole(ctx context.Context, s logical.Storage, roleN
 	return b.nonLockedSetAWSRole(ctx, s, roleName, roleEntry)
 }
 
+// autoCreateIamRole builds the role of an iam login naming a role which does
+// not exist, if the client configuration enables it. The role is a copy of the
+// configured template role, bound to the canonical ARN of the caller. Only
+// callers matching the configured principal pattern are eligible, and only for
+// the role named after themselves, so that each caller can create at most one
+// role. A nil role entry is returned if the caller is not eligible. The role
+// is only kept in memory here; it is recorded in the autoCreatedRole of the
+// context, if any, and stored by storeAutoCreatedIamRole once the login
+// succeeds.
+func (b *backend) autoCreateIamRole(ctx context.Context, s logical.Storage, config *clientConfig, roleName string, entity *iamEntity, callerUniqueId string) (*awsRoleEntry, error) {
+	if config == nil || !config.AutoCreateRoles || config.AutoCreateRoleTemplate == "" || config.AutoCreatePrincipalPattern == "" {
+		return nil, nil
+	}
+	if !strings.EqualFold(roleName, entity.FriendlyName) || !autoCreatedRoleNameRegex.MatchString(roleName) {
+		return nil, nil
+	}
+	canonicalArn := entity.canonicalArn()
+	if !strutil.GlobbedStringsMatch(config.AutoCreatePrincipalPattern, canonicalArn) {
+		return nil, nil
+	}
+
+	templateEntry, err := b.lockedAWSRole(ctx, s, config.AutoCreateRoleTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if templateEntry == nil {
+		return nil, fmt.Errorf("auto_create_role_template %q not found", config.AutoCreateRoleTemplate)
+	}
+	if templateEntry.AuthType != iamAuthType {
+		return nil, fmt.Errorf("auto_create_role_template %q does not use the iam auth type", config.AutoCreateRoleTemplate)
+	}
+
+	roleEntryRaw, err := copystructure.Copy(templateEntry)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to copy the role template: {{err}}", err)
+	}
+	roleEntry := roleEntryRaw.(*awsRoleEntry)
+	roleEntry.BoundIamPrincipalARNs = []string{canonicalArn}
+	roleEntry.BoundIamPrincipalIDs = nil
+	if roleEntry.ResolveAWSUniqueIDs {
+		roleEntry.BoundIamPrincipalIDs = []string{callerUniqueId}
+	}
+	roleEntry.HMACKey, err = uuid.GenerateUUID()
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to generate role HMAC key: {{err}}", err)
+	}
+
+	// The login fills in defaults on the role it uses, so it is given its own
+	// copy, and the role to store is left as built
+	if autoCreated := autoCreatedRoleFromContext(ctx); autoCreated != nil {
+		storedEntryRaw, err := copystructure.Copy(roleEntry)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to copy the auto-created role: {{err}}", err)
+		}
+		autoCreated.name = roleName
+		autoCreated.template = config.AutoCreateRoleTemplate
+		autoCreated.entry = storedEntryRaw.(*awsRoleEntry)
+	}
+
+	return roleEntry, nil
+}
+
+// storeAutoCreatedIamRole stores the role built by autoCreateIamRole for a
+// login which succeeded. The role may have been created by a concurrent login,
+// in which case it is left untouched.
+func (b *backend) storeAutoCreatedIamRole(ctx context.Context, s logical.Storage, autoCreated *autoCreatedRole) error {
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+
+	existingEntry, err := b.nonLockedAWSRole(ctx, s, autoCreated.name)
+	if err != nil {
+		return err
+	}
+	if existingEntry != nil {
+		return nil
+	}
+	if err := b.nonLockedSetAWSRole(ctx, s, autoCreated.name, autoCreated.entry); err != nil {
+		return err
+	}
+	b.Logger().Info("auto-created role", "role", strings.ToLower(autoCreated.name), "template", autoCreated.template, "principal", autoCreated.entry.BoundIamPrincipalARNs[0])
+	return nil
+}
+
 // nonLockedSetAWSRole creates or updates a role in the storage. This method
 // does not acquire the write lock before reading the role from the storage. If
 // locking is desired, use lockedSetAWSRole instead.
@@ -344,7 +855,7 @@ func (b *backend) upgradeRoleEntry(ctx context.Context, s logical.Storage, roleE
 			roleEntry.ResolveAWSUniqueIDs &&
 			roleEntry.BoundIamPrincipalARN != "" &&
 			roleEntry.BoundIamPrincipalID == "" &&
-			!strings.HasSuffix(roleEntry.BoundIamPrincipalARN, "*") {
+			!isBoundPrincipalARNPattern(roleEntry.BoundIamPrincipalARN) {
 			principalId, err := b.resolveArnToUniqueIDFunc(ctx, s, roleEntry.BoundIamPrincipalARN)
 			if err != nil {
 				return false, err
@@ -403,6 +914,13 @@ func (b *backend) upgradeRoleEntry(ctx context.Context, s logical.Storage, roleE
 		}
 		roleEntry.Version = 2
 		fallthrough
+	case 2:
+		// Deny root account principals on existing iam roles, as on new ones
+		if roleEntry.AuthType == iamAuthType {
+			roleEntry.DenyRootPrincipal = true
+		}
+		roleEntry.Version = 3
+		fallthrough
 	case currentRoleStorageVersion:
 	default:
 		return false, fmt.Errorf("unrecognized role version: %q", roleEntry.Version)
@@ -436,6 +954,13 @@ func (b *backend) nonLockedAWSRole(ctx context.Context, s logical.Storage, roleN
 		return nil, err
 	}
 
+	// The patterns were validated when the role was written, so if one no
+	// longer compiles, none of them matches
+	result.boundIamPrincipalARNPatterns, err = compileBoundPrincipalARNPatterns(result.BoundIamPrincipalARNs)
+	if err != nil {
+		b.Logger().Warn("failed to compile the bound_iam_principal_arn patterns of role", "role", roleName, "error", err)
+	}
+
 	return &result, nil
 }
 
@@ -520,6 +1045,18 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 		roleEntry.BoundAccountIDs = boundAccountIDRaw.([]string)
 	}
 
+	if boundSourceRolePathRaw, ok := data.GetOk("bound_source_role_path"); ok {
+		roleEntry.BoundSourceRolePath = boundSourceRolePathRaw.(string)
+	}
+
+	if requireMatchingInstanceProfilePathRaw, ok := data.GetOk("require_matching_instance_profile_path"); ok {
+		roleEntry.RequireMatchingInstanceProfilePath = requireMatchingInstanceProfilePathRaw.(bool)
+	}
+
+	if boundReservationOwnerIDRaw, ok := data.GetOk("bound_reservation_owner_id"); ok {
+		roleEntry.BoundReservationOwnerIDs = boundReservationOwnerIDRaw.([]string)
+	}
+
 	if boundRegionRaw, ok := data.GetOk("bound_region"); ok {
 		roleEntry.BoundRegions = boundRegionRaw.([]string)
 	}
@@ -532,6 +1069,50 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 		roleEntry.BoundSubnetIDs = boundSubnetIDRaw.([]string)
 	}
 
+	if boundSecurityGroupIDRaw, ok := data.GetOk("bound_security_group_id"); ok {
+		roleEntry.BoundSecurityGroupIDs = boundSecurityGroupIDRaw.([]string)
+	}
+
+	if boundSecurityGroupMatchRaw, ok := data.GetOk("bound_security_group_match"); ok {
+		roleEntry.BoundSecurityGroupMatch = strings.ToLower(boundSecurityGroupMatchRaw.(string))
+	}
+
+	if boundMonitoringStateRaw, ok := data.GetOk("bound_monitoring_state"); ok {
+		roleEntry.BoundMonitoringState = strings.ToLower(boundMonitoringStateRaw.(string))
+	}
+
+	if boundPrivateDNSPatternRaw, ok := data.GetOk("bound_private_dns_pattern"); ok {
+		roleEntry.BoundPrivateDNSPattern = boundPrivateDNSPatternRaw.(string)
+	}
+
+	if boundPlacementGroupRaw, ok := data.GetOk("bound_placement_group"); ok {
+		roleEntry.BoundPlacementGroups = boundPlacementGroupRaw.([]string)
+	}
+
+	if boundCapacityReservationIDRaw, ok := data.GetOk("bound_capacity_reservation_id"); ok {
+		roleEntry.BoundCapacityReservationIDs = boundCapacityReservationIDRaw.([]string)
+	}
+
+	if boundInstanceTypeRaw, ok := data.GetOk("bound_instance_type"); ok {
+		roleEntry.BoundInstanceTypes = strutil.RemoveDuplicates(boundInstanceTypeRaw.([]string), true)
+	}
+
+	if boundTenancyRaw, ok := data.GetOk("bound_tenancy"); ok {
+		roleEntry.BoundTenancy = strings.ToLower(boundTenancyRaw.(string))
+	}
+
+	if boundEBSOptimizedRaw, ok := data.GetOk("bound_ebs_optimized"); ok {
+		roleEntry.BoundEBSOptimized = strings.ToLower(boundEBSOptimizedRaw.(string))
+	}
+
+	if stsEndpointRaw, ok := data.GetOk("sts_endpoint"); ok {
+		roleEntry.STSEndpoint = stsEndpointRaw.(string)
+	}
+
+	if iamEndpointRaw, ok := data.GetOk("iam_endpoint"); ok {
+		roleEntry.IAMEndpoint = iamEndpointRaw.(string)
+	}
+
 	if resolveAWSUniqueIDsRaw, ok := data.GetOk("resolve_aws_unique_ids"); ok {
 		switch {
 		case req.Operation == logical.CreateOperation:
@@ -542,7 +1123,10 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 			roleEntry.ResolveAWSUniqueIDs = resolveAWSUniqueIDsRaw.(bool)
 		}
 	} else if req.Operation == logical.CreateOperation {
-		roleEntry.ResolveAWSUniqueIDs = data.Get("resolve_aws_unique_ids").(bool)
+		roleEntry.ResolveAWSUniqueIDs, err = b.defaultResolveAWSUniqueIDs(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if boundIamRoleARNRaw, ok := data.GetOk("bound_iam_role_arn"); ok {
@@ -559,20 +1143,20 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 
 	if boundIamPrincipalARNRaw, ok := data.GetOk("bound_iam_principal_arn"); ok {
 		principalARNs := boundIamPrincipalARNRaw.([]string)
+		denyDuplicates := roleEntry.DenyDuplicateBoundIamPrincipalARNs
+		if denyDuplicatesBool, ok := data.GetOk("deny_duplicate_bound_iam_principal_arns"); ok {
+			denyDuplicates = denyDuplicatesBool.(bool)
+		}
+		principalARNs, duplicates := dedupBoundIamPrincipalARNs(principalARNs)
+		if len(duplicates) > 0 && denyDuplicates {
+			return logical.ErrorResponse(fmt.Sprintf("bound_iam_principal_arn has duplicate entries: %s", strings.Join(duplicates, ", "))), nil
+		}
 		roleEntry.BoundIamPrincipalARNs = principalARNs
 		roleEntry.BoundIamPrincipalIDs = []string{}
 	}
-	if roleEntry.ResolveAWSUniqueIDs && len(roleEntry.BoundIamPrincipalIDs) == 0 {
-		// we might be turning on resolution on this role, so ensure we update the IDs
-		for _, principalARN := range roleEntry.BoundIamPrincipalARNs {
-			if !strings.HasSuffix(principalARN, "*") {
-				principalID, err := b.resolveArnToUniqueIDFunc(ctx, req.Storage, principalARN)
-				if err != nil {
-					return logical.ErrorResponse(fmt.Sprintf("unable to resolve ARN %#v to internal ID: %s", principalARN, err.Error())), nil
-				}
-				roleEntry.BoundIamPrincipalIDs = append(roleEntry.BoundIamPrincipalIDs, principalID)
-			}
-		}
+
+	if boundPermissionsBoundaryARNRaw, ok := data.GetOk("bound_permissions_boundary_arn"); ok {
+		roleEntry.BoundPermissionsBoundaryARNs = boundPermissionsBoundaryARNRaw.([]string)
 	}
 
 	if inferRoleTypeRaw, ok := data.GetOk("inferred_entity_type"); ok {
@@ -610,120 +1194,238 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 		}
 	}
 
-	allowEc2Binds := roleEntry.AuthType == ec2AuthType
+	// The consistency of the inferred entity type is checked along with the
+	// rest of the role
+	allowEc2Binds := roleEntry.AuthType == ec2AuthType || roleEntry.InferredEntityType != ""
 
-	if roleEntry.InferredEntityType != "" {
-		switch {
-		case roleEntry.AuthType != iamAuthType:
-			return logical.ErrorResponse("specified inferred_entity_type but didn't allow iam auth_type"), nil
-		case roleEntry.InferredEntityType != ec2EntityType:
-			return logical.ErrorResponse(fmt.Sprintf("specified invalid inferred_entity_type: %s", roleEntry.InferredEntityType)), nil
-		case roleEntry.InferredAWSRegion == "":
-			return logical.ErrorResponse("specified inferred_entity_type but not inferred_aws_region"), nil
+	includeMatchedBoundARNsBool, ok := data.GetOk("include_matched_bound_arns")
+	if ok {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified include_matched_bound_arns but not specifying iam auth_type"), nil
 		}
-		allowEc2Binds = true
-	} else if roleEntry.InferredAWSRegion != "" {
-		return logical.ErrorResponse("specified inferred_aws_region but not inferred_entity_type"), nil
+		roleEntry.IncludeMatchedBoundARNs = includeMatchedBoundARNsBool.(bool)
 	}
 
-	numBinds := 0
+	forwardUniqueIDBool, ok := data.GetOk("forward_unique_id")
+	if ok {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified forward_unique_id but not specifying iam auth_type"), nil
+		}
+		roleEntry.ForwardUniqueID = forwardUniqueIDBool.(bool)
+	}
 
-	if len(roleEntry.BoundAccountIDs) > 0 {
-		if !allowEc2Binds {
-			return logical.ErrorResponse(fmt.Sprintf("specified bound_account_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+	includeMatchedBoundIndexBool, ok := data.GetOk("include_matched_bound_index")
+	if ok {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified include_matched_bound_index but not specifying iam auth_type"), nil
 		}
-		numBinds++
+		roleEntry.IncludeMatchedBoundIndex = includeMatchedBoundIndexBool.(bool)
 	}
 
-	if len(roleEntry.BoundRegions) > 0 {
+	minBoundConstraintsInt, ok := data.GetOk("min_bound_constraints")
+	if ok {
+		roleEntry.MinBoundConstraints = minBoundConstraintsInt.(int)
+	}
+
+	policiesRaw, ok := data.GetOk("policies")
+	if ok {
+		roleEntry.Policies = policyutil.ParsePolicies(policiesRaw)
+	} else if req.Operation == logical.CreateOperation {
+		roleEntry.Policies = []string{}
+	}
+
+	disallowReauthenticationBool, ok := data.GetOk("disallow_reauthentication")
+	if ok {
 		if roleEntry.AuthType != ec2AuthType {
-			return logical.ErrorResponse("specified bound_region but not specifying ec2 auth_type"), nil
+			return logical.ErrorResponse("specified disallow_reauthentication when not using ec2 auth type"), nil
 		}
-		numBinds++
+		roleEntry.DisallowReauthentication = disallowReauthenticationBool.(bool)
+	} else if req.Operation == logical.CreateOperation && roleEntry.AuthType == ec2AuthType {
+		roleEntry.DisallowReauthentication = data.Get("disallow_reauthentication").(bool)
 	}
 
-	if len(roleEntry.BoundAmiIDs) > 0 {
+	allowInstanceMigrationBool, ok := data.GetOk("allow_instance_migration")
+	if ok {
+		if roleEntry.AuthType != ec2AuthType {
+			return logical.ErrorResponse("specified allow_instance_migration when not using ec2 auth type"), nil
+		}
+		roleEntry.AllowInstanceMigration = allowInstanceMigrationBool.(bool)
+	} else if req.Operation == logical.CreateOperation && roleEntry.AuthType == ec2AuthType {
+		roleEntry.AllowInstanceMigration = data.Get("allow_instance_migration").(bool)
+	}
+
+	requireIMDSv2Bool, ok := data.GetOk("require_imdsv2")
+	if ok {
 		if !allowEc2Binds {
-			return logical.ErrorResponse(fmt.Sprintf("specified bound_ami_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+			return logical.ErrorResponse(fmt.Sprintf("specified require_imdsv2 but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
 		}
-		numBinds++
+		roleEntry.RequireIMDSv2 = requireIMDSv2Bool.(bool)
 	}
 
-	if len(roleEntry.BoundIamInstanceProfileARNs) > 0 {
+	forwardLaunchTimeBool, ok := data.GetOk("forward_launch_time")
+	if ok {
 		if !allowEc2Binds {
-			return logical.ErrorResponse(fmt.Sprintf("specified bound_iam_instance_profile_arn but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+			return logical.ErrorResponse(fmt.Sprintf("specified forward_launch_time but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
 		}
-		numBinds++
+		roleEntry.ForwardLaunchTime = forwardLaunchTimeBool.(bool)
 	}
 
-	if len(roleEntry.BoundEc2InstanceIDs) > 0 {
+	requireInstanceProfileBool, ok := data.GetOk("require_instance_profile")
+	if ok {
 		if !allowEc2Binds {
-			return logical.ErrorResponse(fmt.Sprintf("specified bound_ec2_instance_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+			return logical.ErrorResponse(fmt.Sprintf("specified require_instance_profile but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
 		}
-		numBinds++
+		roleEntry.RequireInstanceProfile = requireInstanceProfileBool.(bool)
 	}
 
-	if len(roleEntry.BoundIamRoleARNs) > 0 {
+	requireSelfOwnedAMIBool, ok := data.GetOk("require_self_owned_ami")
+	if ok {
 		if !allowEc2Binds {
-			return logical.ErrorResponse(fmt.Sprintf("specified bound_iam_role_arn but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+			return logical.ErrorResponse(fmt.Sprintf("specified require_self_owned_ami but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
 		}
-		numBinds++
+		roleEntry.RequireSelfOwnedAMI = requireSelfOwnedAMIBool.(bool)
 	}
 
-	if len(roleEntry.BoundIamPrincipalARNs) > 0 {
+	crossCheckInstanceBool, ok := data.GetOk("cross_check_instance")
+	if ok {
+		if roleEntry.AuthType != ec2AuthType {
+			return logical.ErrorResponse("specified cross_check_instance when not using ec2 auth type"), nil
+		}
+		roleEntry.CrossCheckInstance = crossCheckInstanceBool.(bool)
+	}
+
+	boundSessionNamePatternStr, ok := data.GetOk("bound_session_name_pattern")
+	if ok {
 		if roleEntry.AuthType != iamAuthType {
-			return logical.ErrorResponse("specified bound_iam_principal_arn but not specifying iam auth_type"), nil
+			return logical.ErrorResponse("specified bound_session_name_pattern but not specifying iam auth_type"), nil
 		}
-		numBinds++
+		roleEntry.BoundSessionNamePattern = boundSessionNamePatternStr.(string)
 	}
 
-	if len(roleEntry.BoundVpcIDs) > 0 {
-		if !allowEc2Binds {
-			return logical.ErrorResponse(fmt.Sprintf("specified bound_vpc_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+	denyDuplicateBoundIamPrincipalARNsBool, ok := data.GetOk("deny_duplicate_bound_iam_principal_arns")
+	if ok {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified deny_duplicate_bound_iam_principal_arns but not specifying iam auth_type"), nil
 		}
-		numBinds++
+		roleEntry.DenyDuplicateBoundIamPrincipalARNs = denyDuplicateBoundIamPrincipalARNsBool.(bool)
 	}
 
-	if len(roleEntry.BoundSubnetIDs) > 0 {
-		if !allowEc2Binds {
-			return logical.ErrorResponse(fmt.Sprintf("specified bound_subnet_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+	denyRootPrincipalBool, ok := data.GetOk("deny_root_principal")
+	if ok {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified deny_root_principal but not specifying iam auth_type"), nil
 		}
-		numBinds++
+		roleEntry.DenyRootPrincipal = denyRootPrincipalBool.(bool)
+	} else if req.Operation == logical.CreateOperation && roleEntry.AuthType == iamAuthType {
+		roleEntry.DenyRootPrincipal = data.Get("deny_root_principal").(bool)
 	}
 
-	if numBinds == 0 {
-		return logical.ErrorResponse("at least be one bound parameter should be specified on the role"), nil
+	denyServiceLinkedRolesBool, ok := data.GetOk("deny_service_linked_roles")
+	if ok {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified deny_service_linked_roles but not specifying iam auth_type"), nil
+		}
+		roleEntry.DenyServiceLinkedRoles = denyServiceLinkedRolesBool.(bool)
 	}
 
-	policiesRaw, ok := data.GetOk("policies")
+	requireActivePrincipalBool, ok := data.GetOk("require_active_principal")
 	if ok {
-		roleEntry.Policies = policyutil.ParsePolicies(policiesRaw)
-	} else if req.Operation == logical.CreateOperation {
-		roleEntry.Policies = []string{}
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified require_active_principal but not specifying iam auth_type"), nil
+		}
+		roleEntry.RequireActivePrincipal = requireActivePrincipalBool.(bool)
 	}
 
-	disallowReauthenticationBool, ok := data.GetOk("disallow_reauthentication")
+	maxRequestBodySizeInt, ok := data.GetOk("max_request_body_size")
+	if ok {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified max_request_body_size but not specifying iam auth_type"), nil
+		}
+		roleEntry.MaxRequestBodySize = maxRequestBodySizeInt.(int)
+	}
+
+	requireTemporaryCredentialsBool, ok := data.GetOk("require_temporary_credentials")
+	if ok {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified require_temporary_credentials but not specifying iam auth_type"), nil
+		}
+		roleEntry.RequireTemporaryCredentials = requireTemporaryCredentialsBool.(bool)
+	}
+
+	requireManagementAccountBool, ok := data.GetOk("require_management_account")
+	if ok {
+		roleEntry.RequireManagementAccount = requireManagementAccountBool.(bool)
+	}
+
+	boundOrganizationIDStr, ok := data.GetOk("bound_organization_id")
+	if ok {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified bound_organization_id but not specifying iam auth_type"), nil
+		}
+		roleEntry.BoundOrganizationID = strings.TrimSpace(boundOrganizationIDStr.(string))
+	}
+
+	capTTLToCertificateExpiryBool, ok := data.GetOk("cap_ttl_to_certificate_expiry")
 	if ok {
 		if roleEntry.AuthType != ec2AuthType {
-			return logical.ErrorResponse("specified disallow_reauthentication when not using ec2 auth type"), nil
+			return logical.ErrorResponse("specified cap_ttl_to_certificate_expiry when not using ec2 auth type"), nil
 		}
-		roleEntry.DisallowReauthentication = disallowReauthenticationBool.(bool)
-	} else if req.Operation == logical.CreateOperation && roleEntry.AuthType == ec2AuthType {
-		roleEntry.DisallowReauthentication = data.Get("disallow_reauthentication").(bool)
+		roleEntry.CapTTLToCertificateExpiry = capTTLToCertificateExpiryBool.(bool)
 	}
 
-	allowInstanceMigrationBool, ok := data.GetOk("allow_instance_migration")
+	forwardCertFingerprintBool, ok := data.GetOk("forward_cert_fingerprint")
 	if ok {
 		if roleEntry.AuthType != ec2AuthType {
-			return logical.ErrorResponse("specified allow_instance_migration when not using ec2 auth type"), nil
+			return logical.ErrorResponse("specified forward_cert_fingerprint when not using ec2 auth type"), nil
 		}
-		roleEntry.AllowInstanceMigration = allowInstanceMigrationBool.(bool)
-	} else if req.Operation == logical.CreateOperation && roleEntry.AuthType == ec2AuthType {
-		roleEntry.AllowInstanceMigration = data.Get("allow_instance_migration").(bool)
+		roleEntry.ForwardCertFingerprint = forwardCertFingerprintBool.(bool)
 	}
 
-	if roleEntry.AllowInstanceMigration && roleEntry.DisallowReauthentication {
-		return logical.ErrorResponse("cannot specify both disallow_reauthentication=true and allow_instance_migration=true"), nil
+	forwardInstanceDocumentBool, ok := data.GetOk("forward_instance_document")
+	if ok {
+		if roleEntry.AuthType != ec2AuthType {
+			return logical.ErrorResponse("specified forward_instance_document when not using ec2 auth type"), nil
+		}
+		roleEntry.ForwardInstanceDocument = forwardInstanceDocumentBool.(bool)
+	}
+
+	teamTagKeyStr, ok := data.GetOk("team_tag_key")
+	if ok {
+		roleEntry.TeamTagKey = teamTagKeyStr.(string)
+	}
+
+	includeRoleInAliasMetadataBool, ok := data.GetOk("include_role_in_alias_metadata")
+	if ok {
+		roleEntry.IncludeRoleInAliasMetadata = includeRoleInAliasMetadataBool.(bool)
+	}
+
+	allowedSigningRegionsRaw, ok := data.GetOk("allowed_signing_regions")
+	if ok {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified allowed_signing_regions but not specifying iam auth_type"), nil
+		}
+		roleEntry.AllowedSigningRegions = allowedSigningRegionsRaw.([]string)
+	}
+
+	maxLoginsPerInstanceInt, ok := data.GetOk("max_logins_per_instance")
+	if ok {
+		if roleEntry.AuthType != ec2AuthType {
+			return logical.ErrorResponse("specified max_logins_per_instance when not using ec2 auth type"), nil
+		}
+		roleEntry.MaxLoginsPerInstance = maxLoginsPerInstanceInt.(int)
+	}
+
+	loginRateWindowInt, ok := data.GetOk("login_rate_window")
+	if ok {
+		if roleEntry.AuthType != ec2AuthType {
+			return logical.ErrorResponse("specified login_rate_window when not using ec2 auth type"), nil
+		}
+		roleEntry.LoginRateWindow = time.Duration(loginRateWindowInt.(int)) * time.Second
+	}
+
+	allowedLoginWindowStr, ok := data.GetOk("allowed_login_window")
+	if ok {
+		roleEntry.AllowedLoginWindow = strings.TrimSpace(allowedLoginWindowStr.(string))
 	}
 
 	var resp logical.Response
@@ -748,19 +1450,11 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 			resp.AddWarning(fmt.Sprintf("Given max_ttl of %d seconds greater than current mount/system default of %d seconds; max_ttl will be capped at login time", maxTTL/time.Second, systemMaxTTL/time.Second))
 		}
 
-		if maxTTL < time.Duration(0) {
-			return logical.ErrorResponse("max_ttl cannot be negative"), nil
-		}
-
 		roleEntry.MaxTTL = maxTTL
 	} else if req.Operation == logical.CreateOperation {
 		roleEntry.MaxTTL = time.Duration(data.Get("max_ttl").(int)) * time.Second
 	}
 
-	if roleEntry.MaxTTL != 0 && roleEntry.MaxTTL < roleEntry.TTL {
-		return logical.ErrorResponse("ttl should be shorter than max_ttl"), nil
-	}
-
 	periodRaw, ok := data.GetOk("period")
 	if ok {
 		roleEntry.Period = time.Second * time.Duration(periodRaw.(int))
@@ -768,8 +1462,9 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 		roleEntry.Period = time.Second * time.Duration(data.Get("period").(int))
 	}
 
-	if roleEntry.Period > b.System().MaxLeaseTTL() {
-		return logical.ErrorResponse(fmt.Sprintf("'period' of '%s' is greater than the backend's maximum lease TTL of '%s'", roleEntry.Period.String(), b.System().MaxLeaseTTL().String())), nil
+	maxRenewalIncrementRaw, ok := data.GetOk("max_renewal_increment")
+	if ok {
+		roleEntry.MaxRenewalIncrement = time.Second * time.Duration(maxRenewalIncrementRaw.(int))
 	}
 
 	roleTagStr, ok := data.GetOk("role_tag")
@@ -778,15 +1473,27 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 			return logical.ErrorResponse("tried to enable role_tag when not using ec2 auth method"), nil
 		}
 		roleEntry.RoleTag = roleTagStr.(string)
-		// There is a limit of 127 characters on the tag key for AWS EC2 instances.
-		// Complying to that requirement, do not allow the value of 'key' to be more than that.
-		if len(roleEntry.RoleTag) > 127 {
-			return logical.ErrorResponse("length of role tag exceeds the EC2 key limit of 127 characters"), nil
-		}
 	} else if req.Operation == logical.CreateOperation && roleEntry.AuthType == ec2AuthType {
 		roleEntry.RoleTag = data.Get("role_tag").(string)
 	}
 
+	if resp, err := b.validateRoleEntry(ctx, req.Storage, roleEntry); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if roleEntry.ResolveAWSUniqueIDs && len(roleEntry.BoundIamPrincipalIDs) == 0 {
+		// we might be turning on resolution on this role, so ensure we update the IDs
+		for _, principalARN := range roleEntry.BoundIamPrincipalARNs {
+			if !isBoundPrincipalARNPattern(principalARN) {
+				principalID, err := b.resolveArnToUniqueIDFunc(withIAMEndpoint(ctx, roleEntry.IAMEndpoint), req.Storage, principalARN)
+				if err != nil {
+					return logical.ErrorResponse(fmt.Sprintf("unable to resolve ARN %#v to internal ID: %s", principalARN, err.Error())), nil
+				}
+				roleEntry.BoundIamPrincipalIDs = append(roleEntry.BoundIamPrincipalIDs, principalID)
+			}
+		}
+	}
+
 	if roleEntry.HMACKey == "" {
 		roleEntry.HMACKey, err = uuid.GenerateUUID()
 		if err != nil {
@@ -805,31 +1512,416 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 	return &resp, nil
 }
 
+// validateRoleEntry checks that the role is consistent, whether it was
+// written through the role endpoint or imported, and normalizes it. The
+// compiled bound_iam_principal_arn patterns are set on the role. An error
+// response is returned if the role is invalid.
+func (b *backend) validateRoleEntry(ctx context.Context, s logical.Storage, roleEntry *awsRoleEntry) (*logical.Response, error) {
+	switch roleEntry.AuthType {
+	case ec2AuthType, iamAuthType:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("unrecognized auth_type: %v", roleEntry.AuthType)), nil
+	}
+
+	accountIDs := roleEntry.BoundAccountIDs
+	roleEntry.BoundAccountIDs = nil
+	for _, accountID := range accountIDs {
+		normalized, err := normalizeAccountID(accountID)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid bound_account_id: %v", err)), nil
+		}
+		roleEntry.BoundAccountIDs = append(roleEntry.BoundAccountIDs, normalized)
+	}
+
+	ownerIDs := roleEntry.BoundReservationOwnerIDs
+	roleEntry.BoundReservationOwnerIDs = nil
+	for _, ownerID := range ownerIDs {
+		normalized, err := normalizeAccountID(ownerID)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid bound_reservation_owner_id: %v", err)), nil
+		}
+		roleEntry.BoundReservationOwnerIDs = append(roleEntry.BoundReservationOwnerIDs, normalized)
+	}
+
+	if roleEntry.BoundSourceRolePath != "" && (!strings.HasPrefix(roleEntry.BoundSourceRolePath, "/") || !strings.HasSuffix(roleEntry.BoundSourceRolePath, "/")) {
+		return logical.ErrorResponse(fmt.Sprintf("invalid bound_source_role_path %q; IAM paths must begin and end with '/'", roleEntry.BoundSourceRolePath)), nil
+	}
+
+	switch roleEntry.BoundSecurityGroupMatch {
+	case "", "any", "all":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid bound_security_group_match %q; expected 'any' or 'all'", roleEntry.BoundSecurityGroupMatch)), nil
+	}
+
+	switch roleEntry.BoundMonitoringState {
+	case "", "enabled", "disabled":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid bound_monitoring_state %q; expected 'enabled' or 'disabled'", roleEntry.BoundMonitoringState)), nil
+	}
+
+	if _, err := compilePrivateDNSPattern(roleEntry.BoundPrivateDNSPattern); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid bound_private_dns_pattern: %v", err)), nil
+	}
+
+	for _, boundInstanceType := range roleEntry.BoundInstanceTypes {
+		if strings.Contains(strings.TrimSuffix(boundInstanceType, "*"), "*") {
+			return logical.ErrorResponse(fmt.Sprintf("invalid bound_instance_type %q; only a trailing wildcard is supported", boundInstanceType)), nil
+		}
+	}
+
+	switch roleEntry.BoundTenancy {
+	case "", "default", "dedicated", "host":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid bound_tenancy %q; expected 'default', 'dedicated' or 'host'", roleEntry.BoundTenancy)), nil
+	}
+
+	switch roleEntry.BoundEBSOptimized {
+	case "", "true", "false":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid bound_ebs_optimized %q; expected 'true' or 'false'", roleEntry.BoundEBSOptimized)), nil
+	}
+
+	if roleEntry.STSEndpoint != "" || roleEntry.IAMEndpoint != "" {
+		config, err := b.lockedClientConfigEntry(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		allowInsecure := config != nil && config.AllowInsecureEndpoints
+		if roleEntry.STSEndpoint != "" {
+			if roleEntry.AuthType != iamAuthType {
+				return logical.ErrorResponse("specified sts_endpoint but not specifying iam auth_type"), nil
+			}
+			if err := validateRoleEndpoint(roleEntry.STSEndpoint, allowInsecure); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid sts_endpoint: %v", err)), nil
+			}
+		}
+		if roleEntry.IAMEndpoint != "" {
+			if roleEntry.AuthType != iamAuthType {
+				return logical.ErrorResponse("specified iam_endpoint but not specifying iam auth_type"), nil
+			}
+			if err := validateRoleEndpoint(roleEntry.IAMEndpoint, allowInsecure); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid iam_endpoint: %v", err)), nil
+			}
+		}
+	}
+
+	maxBoundIamPrincipalARNs, err := b.maxBoundIamPrincipalARNs(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if len(roleEntry.BoundIamPrincipalARNs) > maxBoundIamPrincipalARNs {
+		return logical.ErrorResponse(fmt.Sprintf("bound_iam_principal_arn has %d entries, more than the maximum of %d set by max_bound_iam_principal_arns in the client configuration", len(roleEntry.BoundIamPrincipalARNs), maxBoundIamPrincipalARNs)), nil
+	}
+	patterns, err := compileBoundPrincipalARNPatterns(roleEntry.BoundIamPrincipalARNs)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	roleEntry.boundIamPrincipalARNPatterns = patterns
+
+	allowEc2Binds := roleEntry.AuthType == ec2AuthType
+
+	if roleEntry.InferredEntityType != "" {
+		switch {
+		case roleEntry.AuthType != iamAuthType:
+			return logical.ErrorResponse("specified inferred_entity_type but didn't allow iam auth_type"), nil
+		case roleEntry.InferredEntityType != ec2EntityType:
+			return logical.ErrorResponse(fmt.Sprintf("specified invalid inferred_entity_type: %s", roleEntry.InferredEntityType)), nil
+		case roleEntry.InferredAWSRegion == "":
+			return logical.ErrorResponse("specified inferred_entity_type but not inferred_aws_region"), nil
+		}
+		allowEc2Binds = true
+	} else if roleEntry.InferredAWSRegion != "" {
+		return logical.ErrorResponse("specified inferred_aws_region but not inferred_entity_type"), nil
+	}
+
+	if len(roleEntry.BoundAccountIDs) > 0 {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_account_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if len(roleEntry.BoundRegions) > 0 {
+		if roleEntry.AuthType != ec2AuthType {
+			return logical.ErrorResponse("specified bound_region but not specifying ec2 auth_type"), nil
+		}
+	}
+
+	if len(roleEntry.BoundAmiIDs) > 0 {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_ami_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if len(roleEntry.BoundIamInstanceProfileARNs) > 0 {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_iam_instance_profile_arn but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if len(roleEntry.BoundEc2InstanceIDs) > 0 {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_ec2_instance_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if len(roleEntry.BoundIamRoleARNs) > 0 {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_iam_role_arn but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if len(roleEntry.BoundIamPrincipalARNs) > 0 {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified bound_iam_principal_arn but not specifying iam auth_type"), nil
+		}
+	}
+
+	if len(roleEntry.BoundPermissionsBoundaryARNs) > 0 {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified bound_permissions_boundary_arn but not specifying iam auth_type"), nil
+		}
+	}
+
+	if roleEntry.BoundSourceRolePath != "" {
+		if roleEntry.AuthType != iamAuthType {
+			return logical.ErrorResponse("specified bound_source_role_path but not specifying iam auth_type"), nil
+		}
+	}
+
+	if roleEntry.RequireMatchingInstanceProfilePath && (roleEntry.AuthType != iamAuthType || roleEntry.InferredEntityType != ec2EntityType) {
+		return logical.ErrorResponse(fmt.Sprintf("specified require_matching_instance_profile_path but not specifying iam auth_type and inferring %s", ec2EntityType)), nil
+	}
+
+	if len(roleEntry.BoundReservationOwnerIDs) > 0 {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_reservation_owner_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if len(roleEntry.BoundVpcIDs) > 0 {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_vpc_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if len(roleEntry.BoundSubnetIDs) > 0 {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_subnet_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if len(roleEntry.BoundSecurityGroupIDs) > 0 {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_security_group_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if roleEntry.BoundMonitoringState != "" {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_monitoring_state but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if roleEntry.BoundTenancy != "" {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_tenancy but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if roleEntry.BoundEBSOptimized != "" {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_ebs_optimized but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if len(roleEntry.BoundInstanceTypes) > 0 {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_instance_type but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if roleEntry.BoundPrivateDNSPattern != "" {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_private_dns_pattern but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if len(roleEntry.BoundPlacementGroups) > 0 {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_placement_group but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	if len(roleEntry.BoundCapacityReservationIDs) > 0 {
+		if !allowEc2Binds {
+			return logical.ErrorResponse(fmt.Sprintf("specified bound_capacity_reservation_id but not specifying ec2 auth_type or inferring %s", ec2EntityType)), nil
+		}
+	}
+
+	numBinds := roleEntry.boundConstraintCount()
+	if numBinds == 0 {
+		return logical.ErrorResponse("at least be one bound parameter should be specified on the role"), nil
+	}
+	if roleEntry.MinBoundConstraints < 0 {
+		return logical.ErrorResponse("min_bound_constraints cannot be negative"), nil
+	}
+	if numBinds < roleEntry.MinBoundConstraints {
+		return logical.ErrorResponse(fmt.Sprintf("role has %d bound constraints, fewer than the min_bound_constraints of %d", numBinds, roleEntry.MinBoundConstraints)), nil
+	}
+
+	if _, err := compileSessionNamePattern(roleEntry.BoundSessionNamePattern); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid bound_session_name_pattern: %v", err)), nil
+	}
+	if roleEntry.BoundOrganizationID != "" && !organizationIDRegex.MatchString(roleEntry.BoundOrganizationID) {
+		return logical.ErrorResponse(fmt.Sprintf("invalid bound_organization_id %q", roleEntry.BoundOrganizationID)), nil
+	}
+	if roleEntry.MaxRequestBodySize < 0 {
+		return logical.ErrorResponse("max_request_body_size cannot be negative"), nil
+	}
+
+	// Tag keys are limited to 127 characters on both EC2 and IAM
+	if len(roleEntry.TeamTagKey) > 127 {
+		return logical.ErrorResponse("length of team_tag_key exceeds the EC2 and IAM key limit of 127 characters"), nil
+	}
+	// There is a limit of 127 characters on the tag key for AWS EC2 instances.
+	// Complying to that requirement, do not allow the value of 'key' to be more than that.
+	if len(roleEntry.RoleTag) > 127 {
+		return logical.ErrorResponse("length of role tag exceeds the EC2 key limit of 127 characters"), nil
+	}
+
+	if roleEntry.MaxLoginsPerInstance < 0 {
+		return logical.ErrorResponse("max_logins_per_instance cannot be negative"), nil
+	}
+	if roleEntry.LoginRateWindow < 0 {
+		return logical.ErrorResponse("login_rate_window cannot be negative"), nil
+	}
+	if roleEntry.MaxLoginsPerInstance > 0 && roleEntry.LoginRateWindow == 0 {
+		return logical.ErrorResponse("login_rate_window must be set when max_logins_per_instance is set"), nil
+	}
+	if roleEntry.AllowedLoginWindow != "" {
+		if _, err := parseLoginWindow(roleEntry.AllowedLoginWindow); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid allowed_login_window: %v", err)), nil
+		}
+	}
+
+	if roleEntry.AllowInstanceMigration && roleEntry.DisallowReauthentication {
+		return logical.ErrorResponse("cannot specify both disallow_reauthentication=true and allow_instance_migration=true"), nil
+	}
+
+	if roleEntry.MaxTTL < time.Duration(0) {
+		return logical.ErrorResponse("max_ttl cannot be negative"), nil
+	}
+	if roleEntry.MaxTTL != 0 && roleEntry.MaxTTL < roleEntry.TTL {
+		return logical.ErrorResponse("ttl should be shorter than max_ttl"), nil
+	}
+	if roleEntry.Period > b.System().MaxLeaseTTL() {
+		return logical.ErrorResponse(fmt.Sprintf("'period' of '%s' is greater than the backend's maximum lease TTL of '%s'", roleEntry.Period.String(), b.System().MaxLeaseTTL().String())), nil
+	}
+	if roleEntry.MaxRenewalIncrement < time.Duration(0) {
+		return logical.ErrorResponse("max_renewal_increment cannot be negative"), nil
+	}
+	if roleEntry.MaxRenewalIncrement > 0 && roleEntry.Period == 0 {
+		return logical.ErrorResponse("max_renewal_increment requires period to be set"), nil
+	}
+
+	roleEntry.Policies = policyutil.SanitizePolicies(roleEntry.Policies, policyutil.DoNotAddDefaultPolicy)
+
+	return nil, nil
+}
+
+// dedupBoundIamPrincipalARNs removes the entries of principalARNs which refer
+// to the same principal as an earlier entry, keeping the first of them as
+// given. ARNs of IAM users and roles are compared in their canonical form, so
+// that an ARN with a path duplicates the same ARN without it, as names are
+// unique within an account. The removed entries are returned as well.
+func dedupBoundIamPrincipalARNs(principalARNs []string) ([]string, []string) {
+	var deduped, duplicates []string
+	seen := make(map[string]bool, len(principalARNs))
+	for _, principalARN := range principalARNs {
+		key := principalARN
+		if !isBoundPrincipalARNPattern(principalARN) {
+			if entity, err := parseIamArn(principalARN); err == nil && (entity.Type == "user" || entity.Type == "role") {
+				key = entity.canonicalArn()
+			}
+		}
+		if seen[key] {
+			duplicates = append(duplicates, principalARN)
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, principalARN)
+	}
+	return deduped, duplicates
+}
+
 // Struct to hold the information associated with a Vault role
 type awsRoleEntry struct {
-	AuthType                    string        `json:"auth_type" `
-	BoundAmiIDs                 []string      `json:"bound_ami_id_list"`
-	BoundAccountIDs             []string      `json:"bound_account_id_list"`
-	BoundEc2InstanceIDs         []string      `json:"bound_ec2_instance_id_list"`
-	BoundIamPrincipalARNs       []string      `json:"bound_iam_principal_arn_list"`
-	BoundIamPrincipalIDs        []string      `json:"bound_iam_principal_id_list"`
-	BoundIamRoleARNs            []string      `json:"bound_iam_role_arn_list"`
-	BoundIamInstanceProfileARNs []string      `json:"bound_iam_instance_profile_arn_list"`
-	BoundRegions                []string      `json:"bound_region_list"`
-	BoundSubnetIDs              []string      `json:"bound_subnet_id_list"`
-	BoundVpcIDs                 []string      `json:"bound_vpc_id_list"`
-	InferredEntityType          string        `json:"inferred_entity_type"`
-	InferredAWSRegion           string        `json:"inferred_aws_region"`
-	ResolveAWSUniqueIDs         bool          `json:"resolve_aws_unique_ids"`
-	RoleTag                     string        `json:"role_tag"`
-	AllowInstanceMigration      bool          `json:"allow_instance_migration"`
-	TTL                         time.Duration `json:"ttl"`
-	MaxTTL                      time.Duration `json:"max_ttl"`
-	Policies                    []string      `json:"policies"`
-	DisallowReauthentication    bool          `json:"disallow_reauthentication"`
-	HMACKey                     string        `json:"hmac_key"`
-	Period                      time.Duration `json:"period"`
-	Version                     int           `json:"version"`
+	AuthType                           string        `json:"auth_type" `
+	BoundAmiIDs                        []string      `json:"bound_ami_id_list"`
+	BoundAccountIDs                    []string      `json:"bound_account_id_list"`
+	BoundEc2InstanceIDs                []string      `json:"bound_ec2_instance_id_list"`
+	BoundIamPrincipalARNs              []string      `json:"bound_iam_principal_arn_list"`
+	BoundIamPrincipalIDs               []string      `json:"bound_iam_principal_id_list"`
+	BoundIamRoleARNs                   []string      `json:"bound_iam_role_arn_list"`
+	BoundIamInstanceProfileARNs        []string      `json:"bound_iam_instance_profile_arn_list"`
+	BoundPermissionsBoundaryARNs       []string      `json:"bound_permissions_boundary_arn_list"`
+	BoundSourceRolePath                string        `json:"bound_source_role_path"`
+	RequireMatchingInstanceProfilePath bool          `json:"require_matching_instance_profile_path"`
+	BoundRegions                       []string      `json:"bound_region_list"`
+	BoundSubnetIDs                     []string      `json:"bound_subnet_id_list"`
+	BoundSecurityGroupIDs              []string      `json:"bound_security_group_id_list"`
+	BoundReservationOwnerIDs           []string      `json:"bound_reservation_owner_id_list"`
+	BoundSecurityGroupMatch            string        `json:"bound_security_group_match"`
+	BoundVpcIDs                        []string      `json:"bound_vpc_id_list"`
+	InferredEntityType                 string        `json:"inferred_entity_type"`
+	InferredAWSRegion                  string        `json:"inferred_aws_region"`
+	ResolveAWSUniqueIDs                bool          `json:"resolve_aws_unique_ids"`
+	RoleTag                            string        `json:"role_tag"`
+	AllowInstanceMigration             bool          `json:"allow_instance_migration"`
+	TTL                                time.Duration `json:"ttl"`
+	MaxTTL                             time.Duration `json:"max_ttl"`
+	Policies                           []string      `json:"policies"`
+	DisallowReauthentication           bool          `json:"disallow_reauthentication"`
+	HMACKey                            string        `json:"hmac_key"`
+	Period                             time.Duration `json:"period"`
+	MaxRenewalIncrement                time.Duration `json:"max_renewal_increment"`
+	RequireIMDSv2                      bool          `json:"require_imdsv2"`
+	IncludeMatchedBoundARNs            bool          `json:"include_matched_bound_arns"`
+	ForwardUniqueID                    bool          `json:"forward_unique_id"`
+	IncludeMatchedBoundIndex           bool          `json:"include_matched_bound_index"`
+	TeamTagKey                         string        `json:"team_tag_key"`
+	ForwardInstanceDocument            bool          `json:"forward_instance_document"`
+	ForwardCertFingerprint             bool          `json:"forward_cert_fingerprint"`
+	ForwardLaunchTime                  bool          `json:"forward_launch_time"`
+	CapTTLToCertificateExpiry          bool          `json:"cap_ttl_to_certificate_expiry"`
+	IncludeRoleInAliasMetadata         bool          `json:"include_role_in_alias_metadata"`
+	DenyRootPrincipal                  bool          `json:"deny_root_principal"`
+	DenyDuplicateBoundIamPrincipalARNs bool          `json:"deny_duplicate_bound_iam_principal_arns"`
+	DenyServiceLinkedRoles             bool          `json:"deny_service_linked_roles"`
+	RequireActivePrincipal             bool          `json:"require_active_principal"`
+	MaxRequestBodySize                 int           `json:"max_request_body_size"`
+	RequireTemporaryCredentials        bool          `json:"require_temporary_credentials"`
+	RequireManagementAccount           bool          `json:"require_management_account"`
+	BoundOrganizationID                string        `json:"bound_organization_id"`
+	RequireInstanceProfile             bool          `json:"require_instance_profile"`
+	RequireSelfOwnedAMI                bool          `json:"require_self_owned_ami"`
+	AllowedLoginWindow                 string        `json:"allowed_login_window"`
+	AllowedSigningRegions              []string      `json:"allowed_signing_regions"`
+	STSEndpoint                        string        `json:"sts_endpoint"`
+	IAMEndpoint                        string        `json:"iam_endpoint"`
+	MaxLoginsPerInstance               int           `json:"max_logins_per_instance"`
+	LoginRateWindow                    time.Duration `json:"login_rate_window"`
+	CrossCheckInstance                 bool          `json:"cross_check_instance"`
+	BoundMonitoringState               string        `json:"bound_monitoring_state"`
+	BoundTenancy                       string        `json:"bound_tenancy"`
+	BoundEBSOptimized                  string        `json:"bound_ebs_optimized"`
+	BoundPlacementGroups               []string      `json:"bound_placement_group_list"`
+	BoundPrivateDNSPattern             string        `json:"bound_private_dns_pattern"`
+	BoundCapacityReservationIDs        []string      `json:"bound_capacity_reservation_id_list"`
+	BoundInstanceTypes                 []string      `json:"bound_instance_type_list"`
+	BoundSessionNamePattern            string        `json:"bound_session_name_pattern"`
+	MinBoundConstraints                int           `json:"min_bound_constraints"`
+	Version                            int           `json:"version"`
 	// DEPRECATED -- these are the old fields before we supported lists and exist for backwards compatibility
 	BoundAmiID                 string `json:"bound_ami_id,omitempty" `
 	BoundAccountID             string `json:"bound_account_id,omitempty"`
@@ -840,31 +1932,124 @@ type awsRoleEntry struct {
 	BoundRegion                string `json:"bound_region,omitempty"`
 	BoundSubnetID              string `json:"bound_subnet_id,omitempty"`
 	BoundVpcID                 string `json:"bound_vpc_id,omitempty"`
+
+	// Compiled patterns among BoundIamPrincipalARNs, keyed by their source;
+	// set when the role is loaded or written
+	boundIamPrincipalARNPatterns map[string]*regexp.Regexp
+}
+
+// boundConstraintCount returns the number of bound_* constraints which are
+// configured on the role
+func (r *awsRoleEntry) boundConstraintCount() int {
+	count := 0
+	for _, bound := range [][]string{
+		r.BoundAccountIDs,
+		r.BoundRegions,
+		r.BoundAmiIDs,
+		r.BoundIamInstanceProfileARNs,
+		r.BoundEc2InstanceIDs,
+		r.BoundIamRoleARNs,
+		r.BoundIamPrincipalARNs,
+		r.BoundPermissionsBoundaryARNs,
+		r.BoundVpcIDs,
+		r.BoundSubnetIDs,
+		r.BoundSecurityGroupIDs,
+		r.BoundReservationOwnerIDs,
+		r.BoundPlacementGroups,
+		r.BoundCapacityReservationIDs,
+		r.BoundInstanceTypes,
+	} {
+		if len(bound) > 0 {
+			count++
+		}
+	}
+	if r.BoundMonitoringState != "" {
+		count++
+	}
+	if r.BoundTenancy != "" {
+		count++
+	}
+	if r.BoundEBSOptimized != "" {
+		count++
+	}
+	if r.BoundPrivateDNSPattern != "" {
+		count++
+	}
+	if r.BoundSourceRolePath != "" {
+		count++
+	}
+	if r.BoundOrganizationID != "" {
+		count++
+	}
+	return count
 }
 
 func (r *awsRoleEntry) ToResponseData() map[string]interface{} {
 	responseData := map[string]interface{}{
-		"auth_type":                      r.AuthType,
-		"bound_ami_id":                   r.BoundAmiIDs,
-		"bound_account_id":               r.BoundAccountIDs,
-		"bound_ec2_instance_id":          r.BoundEc2InstanceIDs,
-		"bound_iam_principal_arn":        r.BoundIamPrincipalARNs,
-		"bound_iam_principal_id":         r.BoundIamPrincipalIDs,
-		"bound_iam_role_arn":             r.BoundIamRoleARNs,
-		"bound_iam_instance_profile_arn": r.BoundIamInstanceProfileARNs,
-		"bound_region":                   r.BoundRegions,
-		"bound_subnet_id":                r.BoundSubnetIDs,
-		"bound_vpc_id":                   r.BoundVpcIDs,
-		"inferred_entity_type":           r.InferredEntityType,
-		"inferred_aws_region":            r.InferredAWSRegion,
-		"resolve_aws_unique_ids":         r.ResolveAWSUniqueIDs,
-		"role_tag":                       r.RoleTag,
-		"allow_instance_migration":       r.AllowInstanceMigration,
-		"ttl":                            r.TTL / time.Second,
-		"max_ttl":                        r.MaxTTL / time.Second,
-		"policies":                       r.Policies,
-		"disallow_reauthentication":      r.DisallowReauthentication,
-		"period":                         r.Period / time.Second,
+		"auth_type":                               r.AuthType,
+		"bound_ami_id":                            r.BoundAmiIDs,
+		"bound_account_id":                        r.BoundAccountIDs,
+		"bound_ec2_instance_id":                   r.BoundEc2InstanceIDs,
+		"bound_iam_principal_arn":                 r.BoundIamPrincipalARNs,
+		"bound_iam_principal_id":                  r.BoundIamPrincipalIDs,
+		"bound_iam_role_arn":                      r.BoundIamRoleARNs,
+		"bound_iam_instance_profile_arn":          r.BoundIamInstanceProfileARNs,
+		"bound_permissions_boundary_arn":          r.BoundPermissionsBoundaryARNs,
+		"bound_source_role_path":                  r.BoundSourceRolePath,
+		"require_matching_instance_profile_path":  r.RequireMatchingInstanceProfilePath,
+		"bound_region":                            r.BoundRegions,
+		"bound_subnet_id":                         r.BoundSubnetIDs,
+		"bound_security_group_id":                 r.BoundSecurityGroupIDs,
+		"bound_security_group_match":              r.BoundSecurityGroupMatch,
+		"bound_reservation_owner_id":              r.BoundReservationOwnerIDs,
+		"bound_vpc_id":                            r.BoundVpcIDs,
+		"inferred_entity_type":                    r.InferredEntityType,
+		"inferred_aws_region":                     r.InferredAWSRegion,
+		"resolve_aws_unique_ids":                  r.ResolveAWSUniqueIDs,
+		"role_tag":                                r.RoleTag,
+		"allow_instance_migration":                r.AllowInstanceMigration,
+		"ttl":                                     r.TTL / time.Second,
+		"max_ttl":                                 r.MaxTTL / time.Second,
+		"policies":                                r.Policies,
+		"disallow_reauthentication":               r.DisallowReauthentication,
+		"period":                                  r.Period / time.Second,
+		"max_renewal_increment":                   r.MaxRenewalIncrement / time.Second,
+		"require_imdsv2":                          r.RequireIMDSv2,
+		"include_matched_bound_arns":              r.IncludeMatchedBoundARNs,
+		"forward_unique_id":                       r.ForwardUniqueID,
+		"include_matched_bound_index":             r.IncludeMatchedBoundIndex,
+		"team_tag_key":                            r.TeamTagKey,
+		"forward_instance_document":               r.ForwardInstanceDocument,
+		"forward_cert_fingerprint":                r.ForwardCertFingerprint,
+		"forward_launch_time":                     r.ForwardLaunchTime,
+		"cap_ttl_to_certificate_expiry":           r.CapTTLToCertificateExpiry,
+		"include_role_in_alias_metadata":          r.IncludeRoleInAliasMetadata,
+		"deny_root_principal":                     r.DenyRootPrincipal,
+		"deny_duplicate_bound_iam_principal_arns": r.DenyDuplicateBoundIamPrincipalARNs,
+		"deny_service_linked_roles":               r.DenyServiceLinkedRoles,
+		"require_active_principal":                r.RequireActivePrincipal,
+		"max_request_body_size":                   r.MaxRequestBodySize,
+		"require_temporary_credentials":           r.RequireTemporaryCredentials,
+		"require_management_account":              r.RequireManagementAccount,
+		"bound_organization_id":                   r.BoundOrganizationID,
+		"require_instance_profile":                r.RequireInstanceProfile,
+		"require_self_owned_ami":                  r.RequireSelfOwnedAMI,
+		"allowed_login_window":                    r.AllowedLoginWindow,
+		"allowed_signing_regions":                 r.AllowedSigningRegions,
+		"sts_endpoint":                            r.STSEndpoint,
+		"iam_endpoint":                            r.IAMEndpoint,
+		"max_logins_per_instance":                 r.MaxLoginsPerInstance,
+		"login_rate_window":                       r.LoginRateWindow / time.Second,
+		"cross_check_instance":                    r.CrossCheckInstance,
+		"bound_monitoring_state":                  r.BoundMonitoringState,
+		"bound_tenancy":                           r.BoundTenancy,
+		"bound_ebs_optimized":                     r.BoundEBSOptimized,
+		"bound_placement_group":                   r.BoundPlacementGroups,
+		"bound_private_dns_pattern":               r.BoundPrivateDNSPattern,
+		"bound_capacity_reservation_id":           r.BoundCapacityReservationIDs,
+		"bound_instance_type":                     r.BoundInstanceTypes,
+		"bound_session_name_pattern":              r.BoundSessionNamePattern,
+		"min_bound_constraints":                   r.MinBoundConstraints,
 	}
 
 	convertNilToEmptySlice := func(data map[string]interface{}, field string) {
@@ -873,13 +2058,20 @@ func (r *awsRoleEntry) ToResponseData() map[string]interface{} {
 		}
 	}
 	convertNilToEmptySlice(responseData, "bound_ami_id")
+	convertNilToEmptySlice(responseData, "allowed_signing_regions")
 	convertNilToEmptySlice(responseData, "bound_account_id")
 	convertNilToEmptySlice(responseData, "bound_iam_principal_arn")
 	convertNilToEmptySlice(responseData, "bound_iam_principal_id")
 	convertNilToEmptySlice(responseData, "bound_iam_role_arn")
 	convertNilToEmptySlice(responseData, "bound_iam_instance_profile_arn")
+	convertNilToEmptySlice(responseData, "bound_permissions_boundary_arn")
 	convertNilToEmptySlice(responseData, "bound_region")
 	convertNilToEmptySlice(responseData, "bound_subnet_id")
+	convertNilToEmptySlice(responseData, "bound_security_group_id")
+	convertNilToEmptySlice(responseData, "bound_reservation_owner_id")
+	convertNilToEmptySlice(responseData, "bound_placement_group")
+	convertNilToEmptySlice(responseData, "bound_capacity_reservation_id")
+	convertNilToEmptySlice(responseData, "bound_instance_type")
 	convertNilToEmptySlice(responseData, "bound_vpc_id")
 
 	return responseData
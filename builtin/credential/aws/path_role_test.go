@@ -2,8 +2,10 @@ package awsauth
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -564,7 +566,7 @@ func TestAwsEc2_RoleCrud(t *testing.T) {
 	roleData := map[string]interface{}{
 		"auth_type":                      "ec2",
 		"bound_ami_id":                   "testamiid",
-		"bound_account_id":               "testaccountid",
+		"bound_account_id":               "123456789012",
 		"bound_region":                   "testregion",
 		"bound_iam_role_arn":             "arn:aws:iam::123456789012:role/MyRole",
 		"bound_iam_instance_profile_arn": "arn:aws:iam::123456789012:instance-profile/MyInstancePro*",
@@ -597,27 +599,70 @@ func TestAwsEc2_RoleCrud(t *testing.T) {
 	}
 
 	expected := map[string]interface{}{
-		"auth_type":                      ec2AuthType,
-		"bound_ami_id":                   []string{"testamiid"},
-		"bound_account_id":               []string{"testaccountid"},
-		"bound_region":                   []string{"testregion"},
-		"bound_ec2_instance_id":          []string{"i-12345678901234567", "i-76543210987654321"},
-		"bound_iam_principal_arn":        []string{},
-		"bound_iam_principal_id":         []string{},
-		"bound_iam_role_arn":             []string{"arn:aws:iam::123456789012:role/MyRole"},
-		"bound_iam_instance_profile_arn": []string{"arn:aws:iam::123456789012:instance-profile/MyInstancePro*"},
-		"bound_subnet_id":                []string{"testsubnetid"},
-		"bound_vpc_id":                   []string{"testvpcid"},
-		"inferred_entity_type":           "",
-		"inferred_aws_region":            "",
-		"resolve_aws_unique_ids":         false,
-		"role_tag":                       "testtag",
-		"allow_instance_migration":       true,
-		"ttl":                            time.Duration(600),
-		"max_ttl":                        time.Duration(1200),
-		"policies":                       []string{"testpolicy1", "testpolicy2"},
-		"disallow_reauthentication":      false,
-		"period":                         time.Duration(60),
+		"auth_type":                               ec2AuthType,
+		"bound_ami_id":                            []string{"testamiid"},
+		"bound_account_id":                        []string{"123456789012"},
+		"bound_region":                            []string{"testregion"},
+		"bound_ec2_instance_id":                   []string{"i-12345678901234567", "i-76543210987654321"},
+		"bound_iam_principal_arn":                 []string{},
+		"bound_iam_principal_id":                  []string{},
+		"bound_iam_role_arn":                      []string{"arn:aws:iam::123456789012:role/MyRole"},
+		"bound_iam_instance_profile_arn":          []string{"arn:aws:iam::123456789012:instance-profile/MyInstancePro*"},
+		"bound_permissions_boundary_arn":          []string{},
+		"bound_source_role_path":                  "",
+		"require_matching_instance_profile_path":  false,
+		"bound_subnet_id":                         []string{"testsubnetid"},
+		"bound_security_group_id":                 []string{},
+		"bound_security_group_match":              "",
+		"bound_reservation_owner_id":              []string{},
+		"bound_vpc_id":                            []string{"testvpcid"},
+		"inferred_entity_type":                    "",
+		"inferred_aws_region":                     "",
+		"resolve_aws_unique_ids":                  false,
+		"role_tag":                                "testtag",
+		"allow_instance_migration":                true,
+		"ttl":                                     time.Duration(600),
+		"max_ttl":                                 time.Duration(1200),
+		"policies":                                []string{"testpolicy1", "testpolicy2"},
+		"disallow_reauthentication":               false,
+		"period":                                  time.Duration(60),
+		"max_renewal_increment":                   time.Duration(0),
+		"require_imdsv2":                          false,
+		"include_matched_bound_arns":              false,
+		"forward_unique_id":                       false,
+		"include_matched_bound_index":             false,
+		"team_tag_key":                            "",
+		"include_role_in_alias_metadata":          false,
+		"forward_instance_document":               false,
+		"forward_cert_fingerprint":                false,
+		"forward_launch_time":                     false,
+		"cap_ttl_to_certificate_expiry":           false,
+		"deny_root_principal":                     false,
+		"deny_duplicate_bound_iam_principal_arns": false,
+		"deny_service_linked_roles":               false,
+		"require_active_principal":                false,
+		"max_request_body_size":                   0,
+		"require_temporary_credentials":           false,
+		"require_management_account":              false,
+		"bound_organization_id":                   "",
+		"require_instance_profile":                false,
+		"require_self_owned_ami":                  false,
+		"allowed_login_window":                    "",
+		"allowed_signing_regions":                 []string{},
+		"sts_endpoint":                            "",
+		"iam_endpoint":                            "",
+		"max_logins_per_instance":                 0,
+		"login_rate_window":                       time.Duration(0),
+		"cross_check_instance":                    false,
+		"bound_monitoring_state":                  "",
+		"bound_tenancy":                           "",
+		"bound_ebs_optimized":                     "",
+		"bound_placement_group":                   []string{},
+		"bound_private_dns_pattern":               "",
+		"bound_capacity_reservation_id":           []string{},
+		"bound_instance_type":                     []string{},
+		"bound_session_name_pattern":              "",
+		"min_bound_constraints":                   0,
 	}
 
 	if !reflect.DeepEqual(expected, resp.Data) {
@@ -748,6 +793,308 @@ func TestRoleEntryUpgradeV1(t *testing.T) {
 	}
 }
 
+func TestBackend_pathRole_maxBoundIamPrincipalARNs(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config/client",
+		Data: map[string]interface{}{
+			"max_bound_iam_principal_arns": 3,
+		},
+		Storage: storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to configure client: resp:%#v err:%v", resp, err)
+	}
+
+	writeRole := func(count int) *logical.Response {
+		var principalARNs []string
+		for i := 0; i < count; i++ {
+			principalARNs = append(principalARNs, fmt.Sprintf("arn:aws:iam::123456789012:role/role%d", i))
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/iamrole",
+			Data: map[string]interface{}{
+				"auth_type":               iamAuthType,
+				"bound_iam_principal_arn": principalARNs,
+				"resolve_aws_unique_ids":  false,
+			},
+			Storage: storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := writeRole(3); resp != nil && resp.IsError() {
+		t.Fatalf("expected a role at the limit to be accepted: resp:%#v", resp)
+	}
+	resp = writeRole(4)
+	if resp == nil || !resp.IsError() {
+		t.Fatal("expected a role over the limit to be rejected")
+	}
+	if !strings.Contains(resp.Data["error"].(string), "maximum of 3") {
+		t.Fatalf("bad: expected the error to name the limit, got %q", resp.Data["error"])
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/client",
+		Data: map[string]interface{}{
+			"max_bound_iam_principal_arns": -1,
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatal("expected a negative max_bound_iam_principal_arns to be rejected")
+	}
+}
+
+// countingStorage counts the writes made to the wrapped storage, and delay
+// them to widen the window for concurrent readers
+type countingStorage struct {
+	logical.Storage
+	l     sync.Mutex
+	puts  int
+	delay time.Duration
+}
+
+func TestBackend_pathRole_boundIamPrincipalARNPatterns(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeRole := func(principalARNs []string) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/iamrole",
+			Data: map[string]interface{}{
+				"auth_type":               iamAuthType,
+				"bound_iam_principal_arn": principalARNs,
+				"resolve_aws_unique_ids":  false,
+			},
+			Storage: storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := writeRole([]string{
+		"glob:arn:aws:iam::123456789012:role/app-?",
+		"glob:arn:aws:iam::123456789012:role/*/app-*",
+		"regex:^arn:aws:iam::123456789012:role/app-[0-9]+$",
+	}); resp != nil && resp.IsError() {
+		t.Fatalf("failed to create role with patterns: %#v", resp)
+	}
+
+	resp := writeRole([]string{"glob:arn:aws:iam::123456789012:role/app-?", "regex:role/(app"})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for a malformed regular expression, got %#v", resp)
+	}
+	if !strings.Contains(resp.Error().Error(), "regex:role/(app") {
+		t.Fatalf("bad: expected the error to name the malformed pattern, got %q", resp.Error())
+	}
+}
+
+func TestBackend_pathRole_duplicateBoundIamPrincipalARNs(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both ARNs canonicalize to arn:aws:iam::123456789012:role/MyRole
+	principalARNs := []string{
+		"arn:aws:iam::123456789012:role/path/MyRole",
+		"arn:aws:iam::123456789012:role/OtherRole",
+		"arn:aws:iam::123456789012:role/MyRole",
+		"arn:aws:iam::123456789012:role/*",
+		"arn:aws:iam::123456789012:role/*",
+	}
+	writeRole := func(denyDuplicates bool) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/iamrole",
+			Data: map[string]interface{}{
+				"auth_type":                               iamAuthType,
+				"bound_iam_principal_arn":                 principalARNs,
+				"resolve_aws_unique_ids":                  false,
+				"deny_duplicate_bound_iam_principal_arns": denyDuplicates,
+			},
+			Storage: storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := writeRole(false); resp != nil && resp.IsError() {
+		t.Fatalf("failed to create role: resp:%#v", resp)
+	}
+	roleEntry, err := b.lockedAWSRole(context.Background(), storage, "iamrole")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"arn:aws:iam::123456789012:role/path/MyRole",
+		"arn:aws:iam::123456789012:role/OtherRole",
+		"arn:aws:iam::123456789012:role/*",
+	}
+	if !reflect.DeepEqual(roleEntry.BoundIamPrincipalARNs, expected) {
+		t.Fatalf("bad: expected deduplicated bound ARNs %q, got %q", expected, roleEntry.BoundIamPrincipalARNs)
+	}
+
+	resp := writeRole(true)
+	if resp == nil || !resp.IsError() {
+		t.Fatal("expected duplicate bound ARNs to be rejected")
+	}
+	if !strings.Contains(resp.Data["error"].(string), "arn:aws:iam::123456789012:role/MyRole") {
+		t.Fatalf("bad: expected the error to name the duplicate, got %q", resp.Data["error"])
+	}
+}
+
+func (s *countingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	s.l.Lock()
+	s.puts++
+	s.l.Unlock()
+	time.Sleep(s.delay)
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestRoleEntryUpgradeV2(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, authType := range []string{iamAuthType, ec2AuthType} {
+		roleEntry := &awsRoleEntry{
+			AuthType: authType,
+			Version:  2,
+		}
+		upgraded, err := b.upgradeRoleEntry(context.Background(), storage, roleEntry)
+		if err != nil {
+			t.Fatalf("error upgrading role entry: %#v", err)
+		}
+		if !upgraded || roleEntry.Version != currentRoleStorageVersion {
+			t.Fatalf("expected to upgrade role entry %#v", roleEntry)
+		}
+		if roleEntry.DenyRootPrincipal != (authType == iamAuthType) {
+			t.Fatalf("bad: unexpected deny_root_principal of upgraded %s role: %v", authType, roleEntry.DenyRootPrincipal)
+		}
+	}
+}
+
+func TestRoleEntryUpgrade_concurrentReads(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacyEntry, err := logical.StorageEntryJSON("role/legacy", &awsRoleEntry{
+		AuthType:         ec2AuthType,
+		BoundIamRoleARNs: []string{"arn:aws:iam::123456789012:role/my_role_prefix"},
+		Version:          1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(context.Background(), legacyEntry); err != nil {
+		t.Fatal(err)
+	}
+
+	counting := &countingStorage{Storage: storage, delay: 50 * time.Millisecond}
+	const readers = 20
+	start := make(chan struct{})
+	results := make(chan *awsRoleEntry, readers)
+	errs := make(chan error, readers)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			roleEntry, err := b.lockedAWSRole(context.Background(), counting, "legacy")
+			if err != nil {
+				errs <- err
+				return
+			}
+			results <- roleEntry
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if counting.puts != 1 {
+		t.Fatalf("bad: expected the upgraded role to be written once, got %d writes", counting.puts)
+	}
+	seen := make(map[*awsRoleEntry]bool)
+	for roleEntry := range results {
+		if roleEntry.Version != currentRoleStorageVersion || !reflect.DeepEqual(roleEntry.BoundIamRoleARNs, []string{"arn:aws:iam::123456789012:role/my_role_prefix*"}) {
+			t.Fatalf("bad: expected an upgraded role, got %#v", roleEntry)
+		}
+		if seen[roleEntry] {
+			t.Fatal("expected each reader to be given its own copy of the role")
+		}
+		seen[roleEntry] = true
+	}
+	if len(seen) != readers {
+		t.Fatalf("bad: expected %d roles, got %d", readers, len(seen))
+	}
+}
+
 func resolveArnToFakeUniqueId(ctx context.Context, s logical.Storage, arn string) (string, error) {
 	return "FakeUniqueId1", nil
 }
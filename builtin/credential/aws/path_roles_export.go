@@ -0,0 +1,203 @@
+package awsauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// Version of the envelope produced by 'roles/export'. It is independent of
+// the role storage version, which is recorded separately in the bundle.
+const roleExportFormatVersion = 1
+
+func pathExportRoles(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/export$",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathRolesExportRead,
+		},
+
+		HelpSynopsis:    pathExportRolesHelpSyn,
+		HelpDescription: pathExportRolesHelpDesc,
+	}
+}
+
+func pathImportRoles(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/import$",
+		Fields: map[string]*framework.FieldSchema{
+			"bundle": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "JSON encoded role bundle, as returned by 'roles/export'.",
+			},
+			"overwrite": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Default:     false,
+				Description: "If set, roles in the bundle replace existing roles of the same name. Otherwise the import fails if any of them already exists.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathRolesImportUpdate,
+		},
+
+		HelpSynopsis:    pathImportRolesHelpSyn,
+		HelpDescription: pathImportRolesHelpDesc,
+	}
+}
+
+// pathRolesExportRead returns all the roles in a portable bundle. The HMAC
+// keys used to create role tags are not exported.
+func (b *backend) pathRolesExportRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.roleMutex.RLock()
+	roleNames, err := req.Storage.List(ctx, "role/")
+	b.roleMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &roleExportBundle{
+		FormatVersion:  roleExportFormatVersion,
+		StorageVersion: currentRoleStorageVersion,
+		Roles:          make(map[string]*awsRoleEntry, len(roleNames)),
+	}
+	for _, roleName := range roleNames {
+		// Reading the role through lockedAWSRole upgrades it to the current
+		// storage version
+		roleEntry, err := b.lockedAWSRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if roleEntry == nil {
+			continue
+		}
+		roleEntry.HMACKey = ""
+		bundle.Roles[roleName] = roleEntry
+	}
+
+	bundleJSON, err := jsonutil.EncodeJSON(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"bundle":          string(bundleJSON),
+			"format_version":  bundle.FormatVersion,
+			"storage_version": bundle.StorageVersion,
+			"roles":           len(bundle.Roles),
+		},
+	}, nil
+}
+
+// pathRolesImportUpdate creates the roles in a bundle returned by
+// 'roles/export'. Each imported role is given a new HMAC key.
+func (b *backend) pathRolesImportUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	bundleJSON := data.Get("bundle").(string)
+	if bundleJSON == "" {
+		return logical.ErrorResponse("missing bundle"), nil
+	}
+
+	var bundle roleExportBundle
+	if err := jsonutil.DecodeJSON([]byte(bundleJSON), &bundle); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to decode bundle: %v", err)), nil
+	}
+	if bundle.FormatVersion != roleExportFormatVersion {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported bundle format version %d", bundle.FormatVersion)), nil
+	}
+	if bundle.StorageVersion > currentRoleStorageVersion {
+		return logical.ErrorResponse(fmt.Sprintf("bundle storage version %d is newer than the supported version %d", bundle.StorageVersion, currentRoleStorageVersion)), nil
+	}
+
+	overwrite := data.Get("overwrite").(bool)
+
+	b.roleMutex.Lock()
+	defer b.roleMutex.Unlock()
+
+	// Validate every role before writing any, so that a failed import does
+	// not leave a partial set of roles behind
+	for roleName, roleEntry := range bundle.Roles {
+		if roleName == "" || roleName != strings.ToLower(roleName) {
+			return logical.ErrorResponse(fmt.Sprintf("invalid role name %q in bundle", roleName)), nil
+		}
+		if roleEntry == nil {
+			return logical.ErrorResponse(fmt.Sprintf("missing definition of role %q in bundle", roleName)), nil
+		}
+		if roleEntry.Version > bundle.StorageVersion {
+			return logical.ErrorResponse(fmt.Sprintf("role %q has storage version %d, newer than the bundle storage version %d", roleName, roleEntry.Version, bundle.StorageVersion)), nil
+		}
+		if _, err := b.upgradeRoleEntry(ctx, req.Storage, roleEntry); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("error upgrading role %q: {{err}}", roleName), err)
+		}
+		resp, err := b.validateRoleEntry(ctx, req.Storage, roleEntry)
+		if err != nil {
+			return nil, err
+		}
+		if resp != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid role %q: %v", roleName, resp.Data["error"])), nil
+		}
+		if overwrite {
+			continue
+		}
+		existingEntry, err := b.nonLockedAWSRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if existingEntry != nil {
+			return logical.ErrorResponse(fmt.Sprintf("role %q already exists; set overwrite to replace it", roleName)), nil
+		}
+	}
+
+	for roleName, roleEntry := range bundle.Roles {
+		hmacKey, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to generate role HMAC key: {{err}}", err)
+		}
+		roleEntry.HMACKey = hmacKey
+
+		if err := b.nonLockedSetAWSRole(ctx, req.Storage, roleName, roleEntry); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// roleExportBundle is the portable envelope holding exported roles
+type roleExportBundle struct {
+	FormatVersion  int                      `json:"format_version"`
+	StorageVersion int                      `json:"storage_version"`
+	Roles          map[string]*awsRoleEntry `json:"roles"`
+}
+
+const pathExportRolesHelpSyn = `
+Exports all the roles as a portable JSON bundle.
+`
+
+const pathExportRolesHelpDesc = `
+Returns a 'bundle' holding the definitions of all the roles, for backup or
+migration to another mount. The bundle records its format version and the
+storage version of the roles it holds, so that it can be imported using the
+'roles/import' endpoint. The keys used to create role tags are not exported.
+`
+
+const pathImportRolesHelpSyn = `
+Imports roles from a bundle created by 'roles/export'.
+`
+
+const pathImportRolesHelpDesc = `
+Creates the roles held in a 'bundle' returned by the 'roles/export' endpoint,
+upgrading them from the storage version recorded in the bundle if needed.
+Each role is validated as if it was written through the 'role' endpoint, and
+no role is imported if any of them is invalid.
+Existing roles of the same name cause the import to fail, unless 'overwrite'
+is set. Each imported role is given a new key for creating role tags, so role
+tags created before the export are not valid for the imported roles.
+`
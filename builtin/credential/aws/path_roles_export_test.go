@@ -0,0 +1,232 @@
+package awsauth
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestBackend_pathRolesExportImport(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles := map[string]map[string]interface{}{
+		"ec2role": {
+			"auth_type":    "ec2",
+			"policies":     "p,q",
+			"bound_ami_id": "ami-abcd123",
+			"role_tag":     "VaultRole",
+		},
+		"iamrole": {
+			"auth_type":               "iam",
+			"policies":                "r",
+			"bound_iam_principal_arn": "arn:aws:iam::123456789012:role/*",
+			"resolve_aws_unique_ids":  false,
+		},
+	}
+	for roleName, data := range roles {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + roleName,
+			Data:      data,
+			Storage:   storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("failed to create role %q: resp:%#v err:%v", roleName, resp, err)
+		}
+	}
+
+	exported := make(map[string]*awsRoleEntry)
+	for roleName := range roles {
+		roleEntry, err := b.lockedAWSRole(context.Background(), storage, roleName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exported[roleName] = roleEntry
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "roles/export",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("failed to export roles: resp:%#v err:%v", resp, err)
+	}
+	if resp.Data["storage_version"] != currentRoleStorageVersion {
+		t.Fatalf("bad: expected storage version %d, got %#v", currentRoleStorageVersion, resp.Data["storage_version"])
+	}
+	bundle := resp.Data["bundle"].(string)
+	if strings.Contains(bundle, exported["ec2role"].HMACKey) {
+		t.Fatal("exported bundle contains the role HMAC key")
+	}
+
+	// Importing into a mount which already has the roles is refused unless
+	// overwrite is set
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/import",
+		Data: map[string]interface{}{
+			"bundle": bundle,
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatal("expected import over existing roles to fail")
+	}
+
+	for roleName := range roles {
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "role/" + roleName,
+			Storage:   storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("failed to delete role %q: resp:%#v err:%v", roleName, resp, err)
+		}
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/import",
+		Data: map[string]interface{}{
+			"bundle": bundle,
+		},
+		Storage: storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to import roles: resp:%#v err:%v", resp, err)
+	}
+
+	for roleName, expected := range exported {
+		roleEntry, err := b.lockedAWSRole(context.Background(), storage, roleName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if roleEntry == nil {
+			t.Fatalf("role %q not imported", roleName)
+		}
+		if roleEntry.HMACKey == "" || roleEntry.HMACKey == expected.HMACKey {
+			t.Fatalf("expected a new HMAC key for imported role %q", roleName)
+		}
+		roleEntry.HMACKey = expected.HMACKey
+		if !reflect.DeepEqual(roleEntry, expected) {
+			t.Fatalf("bad: imported role %q\nexpected: %#v\ngot: %#v", roleName, expected, roleEntry)
+		}
+	}
+}
+
+func TestBackend_pathRolesImport_validation(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importRoles := func(roles map[string]*awsRoleEntry) *logical.Response {
+		bundle, err := json.Marshal(&roleExportBundle{
+			FormatVersion:  roleExportFormatVersion,
+			StorageVersion: currentRoleStorageVersion,
+			Roles:          roles,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/import",
+			Data: map[string]interface{}{
+				"bundle": string(bundle),
+			},
+			Storage: storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	validRole := func() *awsRoleEntry {
+		return &awsRoleEntry{
+			AuthType:              iamAuthType,
+			BoundIamPrincipalARNs: []string{"arn:aws:iam::123456789012:role/app"},
+			Version:               currentRoleStorageVersion,
+		}
+	}
+
+	for name, invalid := range map[string]func(*awsRoleEntry){
+		"unknown auth type":   func(r *awsRoleEntry) { r.AuthType = "oidc" },
+		"no bound constraint": func(r *awsRoleEntry) { r.BoundIamPrincipalARNs = nil },
+		"mismatched binding":  func(r *awsRoleEntry) { r.BoundAmiIDs = []string{"ami-12345678"} },
+		"invalid account ID": func(r *awsRoleEntry) {
+			r.AuthType, r.BoundIamPrincipalARNs, r.BoundAccountIDs = ec2AuthType, nil, []string{"not-an-account"}
+		},
+		"invalid ARN pattern":     func(r *awsRoleEntry) { r.BoundIamPrincipalARNs = []string{"regex:arn:aws:iam::123456789012:role/(app"} },
+		"insecure STS endpoint":   func(r *awsRoleEntry) { r.STSEndpoint = "http://sts.example.com" },
+		"too many principal ARNs": func(r *awsRoleEntry) { r.BoundIamPrincipalARNs = make([]string, defaultMaxBoundIamPrincipalARNs+1) },
+	} {
+		role := validRole()
+		invalid(role)
+		resp := importRoles(map[string]*awsRoleEntry{
+			"valid":   validRole(),
+			"invalid": role,
+		})
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected the import to be rejected", name)
+		}
+		roleEntry, err := b.lockedAWSRole(context.Background(), storage, "valid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if roleEntry != nil {
+			t.Fatalf("%s: expected no role to be imported", name)
+		}
+	}
+
+	// Imported roles are normalized as written roles are
+	role := validRole()
+	role.AuthType = ec2AuthType
+	role.BoundIamPrincipalARNs = nil
+	role.BoundAccountIDs = []string{"1234-5678-9012"}
+	role.Policies = []string{"web", " Admin", "web"}
+	if resp := importRoles(map[string]*awsRoleEntry{"normalized": role}); resp != nil && resp.IsError() {
+		t.Fatalf("failed to import role: resp:%#v", resp)
+	}
+	roleEntry, err := b.lockedAWSRole(context.Background(), storage, "normalized")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roleEntry == nil {
+		t.Fatal("role not imported")
+	}
+	if !reflect.DeepEqual(roleEntry.BoundAccountIDs, []string{"123456789012"}) {
+		t.Fatalf("bad: expected a normalized account ID, got %q", roleEntry.BoundAccountIDs)
+	}
+	if !reflect.DeepEqual(roleEntry.Policies, []string{"admin", "web"}) {
+		t.Fatalf("bad: expected sanitized policies, got %q", roleEntry.Policies)
+	}
+}
@@ -183,7 +183,7 @@ func (b *backend) pathRoletagBlacklistUpdate(ctx context.Context, req *logical.R
 		blEntry = &roleTagBlacklistEntry{}
 	}
 
-	currentTime := time.Now()
+	currentTime := b.clock()
 
 	// Check if this is a creation of blacklist entry.
 	if blEntry.CreationTime.IsZero() {
@@ -22,6 +22,12 @@ func pathTidyIdentityWhitelist(b *backend) *framework.Path {
 				Description: `The amount of extra time that must have passed beyond the identity's
 expiration, before it is removed from the backend storage.`,
 			},
+			"dry_run": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the entries which would be deleted are returned, and nothing is
+deleted.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -34,7 +40,23 @@ expiration, before it is removed from the backend storage.`,
 }
 
 // tidyWhitelistIdentity is used to delete entries in the whitelist that are expired.
-func (b *backend) tidyWhitelistIdentity(ctx context.Context, req *logical.Request, safetyBuffer int) (*logical.Response, error) {
+// If dryRun is set, the entries which would be deleted are returned in the
+// response instead.
+func (b *backend) tidyWhitelistIdentity(ctx context.Context, req *logical.Request, safetyBuffer int, dryRun bool) (*logical.Response, error) {
+	bufferDuration := time.Duration(safetyBuffer) * time.Second
+
+	if dryRun {
+		expired, err := b.tidyWhitelistIdentityEntries(ctx, req.Storage, bufferDuration, true)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"would_delete": expired,
+			},
+		}, nil
+	}
+
 	if !atomic.CompareAndSwapUint32(b.tidyWhitelistCASGuard, 0, 1) {
 		resp := &logical.Response{}
 		resp.AddWarning("Tidy operation already in progress.")
@@ -51,44 +73,7 @@ func (b *backend) tidyWhitelistIdentity(ctx context.Context, req *logical.Reques
 
 		logger := b.Logger().Named("wltidy")
 
-		bufferDuration := time.Duration(safetyBuffer) * time.Second
-
-		doTidy := func() error {
-			identities, err := s.List(ctx, "whitelist/identity/")
-			if err != nil {
-				return err
-			}
-
-			for _, instanceID := range identities {
-				identityEntry, err := s.Get(ctx, "whitelist/identity/"+instanceID)
-				if err != nil {
-					return errwrap.Wrapf(fmt.Sprintf("error fetching identity of instanceID %q: {{err}}", instanceID), err)
-				}
-
-				if identityEntry == nil {
-					return fmt.Errorf("identity entry for instanceID %q is nil", instanceID)
-				}
-
-				if identityEntry.Value == nil || len(identityEntry.Value) == 0 {
-					return fmt.Errorf("found identity entry for instanceID %q but actual identity is empty", instanceID)
-				}
-
-				var result whitelistIdentity
-				if err := identityEntry.DecodeJSON(&result); err != nil {
-					return err
-				}
-
-				if time.Now().After(result.ExpirationTime.Add(bufferDuration)) {
-					if err := s.Delete(ctx, "whitelist/identity/"+instanceID); err != nil {
-						return errwrap.Wrapf(fmt.Sprintf("error deleting identity of instanceID %q from storage: {{err}}", instanceID), err)
-					}
-				}
-			}
-
-			return nil
-		}
-
-		if err := doTidy(); err != nil {
+		if _, err := b.tidyWhitelistIdentityEntries(ctx, s, bufferDuration, false); err != nil {
 			logger.Error("error running whitelist tidy", "error", err)
 			return
 		}
@@ -99,9 +84,52 @@ func (b *backend) tidyWhitelistIdentity(ctx context.Context, req *logical.Reques
 	return logical.RespondWithStatusCode(resp, req, http.StatusAccepted)
 }
 
+// tidyWhitelistIdentityEntries deletes the entries which expired before the
+// buffer duration, or only lists them if dryRun is set, and returns their keys.
+func (b *backend) tidyWhitelistIdentityEntries(ctx context.Context, s logical.Storage, bufferDuration time.Duration, dryRun bool) ([]string, error) {
+	identities, err := s.List(ctx, "whitelist/identity/")
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []string{}
+	for _, instanceID := range identities {
+		identityEntry, err := s.Get(ctx, "whitelist/identity/"+instanceID)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("error fetching identity of instanceID %q: {{err}}", instanceID), err)
+		}
+
+		if identityEntry == nil {
+			return nil, fmt.Errorf("identity entry for instanceID %q is nil", instanceID)
+		}
+
+		if identityEntry.Value == nil || len(identityEntry.Value) == 0 {
+			return nil, fmt.Errorf("found identity entry for instanceID %q but actual identity is empty", instanceID)
+		}
+
+		var result whitelistIdentity
+		if err := identityEntry.DecodeJSON(&result); err != nil {
+			return nil, err
+		}
+
+		if !b.clock().After(result.ExpirationTime.Add(bufferDuration)) {
+			continue
+		}
+		expired = append(expired, instanceID)
+		if dryRun {
+			continue
+		}
+		if err := s.Delete(ctx, "whitelist/identity/"+instanceID); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("error deleting identity of instanceID %q from storage: {{err}}", instanceID), err)
+		}
+	}
+
+	return expired, nil
+}
+
 // pathTidyIdentityWhitelistUpdate is used to delete entries in the whitelist that are expired.
 func (b *backend) pathTidyIdentityWhitelistUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	return b.tidyWhitelistIdentity(ctx, req, data.Get("safety_buffer").(int))
+	return b.tidyWhitelistIdentity(ctx, req, data.Get("safety_buffer").(int), data.Get("dry_run").(bool))
 }
 
 const pathTidyIdentityWhitelistSyn = `
@@ -115,5 +143,7 @@ and the backend's mount.
 
 When this endpoint is invoked, all the entries that are expired will be deleted.
 A 'safety_buffer' (duration in seconds) can be provided, to ensure deletion of
-only those entries that are expired before 'safety_buffer' seconds. 
+only those entries that are expired before 'safety_buffer' seconds. If
+'dry_run' is set, the entries which would be deleted are returned under
+'would_delete', and nothing is deleted.
 `
@@ -22,6 +22,12 @@ func pathTidyRoletagBlacklist(b *backend) *framework.Path {
 				Description: `The amount of extra time that must have passed beyond the roletag
 expiration, before it is removed from the backend storage.`,
 			},
+			"dry_run": &framework.FieldSchema{
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If set, the entries which would be deleted are returned, and nothing is
+deleted.`,
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -34,7 +40,23 @@ expiration, before it is removed from the backend storage.`,
 }
 
 // tidyBlacklistRoleTag is used to clean-up the entries in the role tag blacklist.
-func (b *backend) tidyBlacklistRoleTag(ctx context.Context, req *logical.Request, safetyBuffer int) (*logical.Response, error) {
+// If dryRun is set, the entries which would be deleted are returned in the
+// response instead.
+func (b *backend) tidyBlacklistRoleTag(ctx context.Context, req *logical.Request, safetyBuffer int, dryRun bool) (*logical.Response, error) {
+	bufferDuration := time.Duration(safetyBuffer) * time.Second
+
+	if dryRun {
+		expired, err := b.tidyBlacklistRoleTagEntries(ctx, req.Storage, bufferDuration, true)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"would_delete": expired,
+			},
+		}, nil
+	}
+
 	if !atomic.CompareAndSwapUint32(b.tidyBlacklistCASGuard, 0, 1) {
 		resp := &logical.Response{}
 		resp.AddWarning("Tidy operation already in progress.")
@@ -51,44 +73,7 @@ func (b *backend) tidyBlacklistRoleTag(ctx context.Context, req *logical.Request
 
 		logger := b.Logger().Named("bltidy")
 
-		bufferDuration := time.Duration(safetyBuffer) * time.Second
-
-		doTidy := func() error {
-			tags, err := s.List(ctx, "blacklist/roletag/")
-			if err != nil {
-				return err
-			}
-
-			for _, tag := range tags {
-				tagEntry, err := s.Get(ctx, "blacklist/roletag/"+tag)
-				if err != nil {
-					return errwrap.Wrapf(fmt.Sprintf("error fetching tag %q: {{err}}", tag), err)
-				}
-
-				if tagEntry == nil {
-					return fmt.Errorf("tag entry for tag %q is nil", tag)
-				}
-
-				if tagEntry.Value == nil || len(tagEntry.Value) == 0 {
-					return fmt.Errorf("found entry for tag %q but actual tag is empty", tag)
-				}
-
-				var result roleTagBlacklistEntry
-				if err := tagEntry.DecodeJSON(&result); err != nil {
-					return err
-				}
-
-				if time.Now().After(result.ExpirationTime.Add(bufferDuration)) {
-					if err := s.Delete(ctx, "blacklist/roletag/"+tag); err != nil {
-						return errwrap.Wrapf(fmt.Sprintf("error deleting tag %q from storage: {{err}}", tag), err)
-					}
-				}
-			}
-
-			return nil
-		}
-
-		if err := doTidy(); err != nil {
+		if _, err := b.tidyBlacklistRoleTagEntries(ctx, s, bufferDuration, false); err != nil {
 			logger.Error("error running blacklist tidy", "error", err)
 			return
 		}
@@ -99,9 +84,52 @@ func (b *backend) tidyBlacklistRoleTag(ctx context.Context, req *logical.Request
 	return logical.RespondWithStatusCode(resp, req, http.StatusAccepted)
 }
 
+// tidyBlacklistRoleTagEntries deletes the entries which expired before the
+// buffer duration, or only lists them if dryRun is set, and returns their keys.
+func (b *backend) tidyBlacklistRoleTagEntries(ctx context.Context, s logical.Storage, bufferDuration time.Duration, dryRun bool) ([]string, error) {
+	tags, err := s.List(ctx, "blacklist/roletag/")
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []string{}
+	for _, tag := range tags {
+		tagEntry, err := s.Get(ctx, "blacklist/roletag/"+tag)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("error fetching tag %q: {{err}}", tag), err)
+		}
+
+		if tagEntry == nil {
+			return nil, fmt.Errorf("tag entry for tag %q is nil", tag)
+		}
+
+		if tagEntry.Value == nil || len(tagEntry.Value) == 0 {
+			return nil, fmt.Errorf("found entry for tag %q but actual tag is empty", tag)
+		}
+
+		var result roleTagBlacklistEntry
+		if err := tagEntry.DecodeJSON(&result); err != nil {
+			return nil, err
+		}
+
+		if !b.clock().After(result.ExpirationTime.Add(bufferDuration)) {
+			continue
+		}
+		expired = append(expired, tag)
+		if dryRun {
+			continue
+		}
+		if err := s.Delete(ctx, "blacklist/roletag/"+tag); err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("error deleting tag %q from storage: {{err}}", tag), err)
+		}
+	}
+
+	return expired, nil
+}
+
 // pathTidyRoletagBlacklistUpdate is used to clean-up the entries in the role tag blacklist.
 func (b *backend) pathTidyRoletagBlacklistUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	return b.tidyBlacklistRoleTag(ctx, req, data.Get("safety_buffer").(int))
+	return b.tidyBlacklistRoleTag(ctx, req, data.Get("safety_buffer").(int), data.Get("dry_run").(bool))
 }
 
 const pathTidyRoletagBlacklistSyn = `
@@ -115,5 +143,7 @@ backend's mount.
 
 When this endpoint is invoked, all the entries that are expired will be deleted.
 A 'safety_buffer' (duration in seconds) can be provided, to ensure deletion of
-only those entries that are expired before 'safety_buffer' seconds. 
+only those entries that are expired before 'safety_buffer' seconds. If
+'dry_run' is set, the entries which would be deleted are returned under
+'would_delete', and nothing is deleted.
 `
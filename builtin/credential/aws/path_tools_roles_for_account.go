@@ -0,0 +1,157 @@
+package awsauth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/helper/strutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathToolsRolesForAccount(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tools/roles-for-account$",
+		Fields: map[string]*framework.FieldSchema{
+			"account_id": {
+				Type:        framework.TypeString,
+				Description: "AWS account ID to find the roles of.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathToolsRolesForAccountUpdate,
+		},
+
+		HelpSynopsis:    pathToolsRolesForAccountHelpSyn,
+		HelpDescription: pathToolsRolesForAccountHelpDesc,
+	}
+}
+
+// pathToolsRolesForAccountUpdate returns the roles which are bound to the
+// given account ID, along with the constraints binding them
+func (b *backend) pathToolsRolesForAccountUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	accountIDRaw := data.Get("account_id").(string)
+	if accountIDRaw == "" {
+		return logical.ErrorResponse("missing account_id"), nil
+	}
+	accountID, err := normalizeAccountID(accountIDRaw)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	b.roleMutex.RLock()
+	roleNames, err := req.Storage.List(ctx, "role/")
+	b.roleMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []string{}
+	matches := make(map[string][]string)
+	for _, roleName := range roleNames {
+		roleEntry, err := b.lockedAWSRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if roleEntry == nil {
+			continue
+		}
+		matched := roleAccountConstraints(roleEntry, accountID)
+		if len(matched) == 0 {
+			continue
+		}
+		roles = append(roles, roleName)
+		matches[roleName] = matched
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"account_id": accountID,
+			"roles":      roles,
+			"matches":    matches,
+		},
+	}, nil
+}
+
+// roleAccountConstraints returns the names of the constraints of the role
+// which bind it to the account, either directly or through a wildcard
+func roleAccountConstraints(roleEntry *awsRoleEntry, accountID string) []string {
+	var matched []string
+	if strutil.StrListContains(roleEntry.BoundAccountIDs, accountID) {
+		matched = append(matched, "bound_account_id")
+	}
+	if strutil.StrListContains(roleEntry.BoundReservationOwnerIDs, accountID) {
+		matched = append(matched, "bound_reservation_owner_id")
+	}
+	for _, bound := range []struct {
+		name string
+		arns []string
+	}{
+		{"bound_iam_principal_arn", roleEntry.BoundIamPrincipalARNs},
+		{"bound_iam_role_arn", roleEntry.BoundIamRoleARNs},
+		{"bound_iam_instance_profile_arn", roleEntry.BoundIamInstanceProfileARNs},
+		{"bound_permissions_boundary_arn", roleEntry.BoundPermissionsBoundaryARNs},
+	} {
+		for _, arn := range bound.arns {
+			if arnMatchesAccount(arn, accountID) {
+				matched = append(matched, bound.name)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// arnMatchesAccount returns whether the account of a bound ARN, which may
+// hold wildcards or be a pattern, matches the account ID. Regular
+// expressions are not evaluated: they are reported as possible matches.
+func arnMatchesAccount(arn, accountID string) bool {
+	if strings.HasPrefix(arn, principalARNRegexPrefix) {
+		return true
+	}
+	if strings.HasPrefix(arn, principalARNGlobPrefix) {
+		return globMatchesAccount(strings.TrimPrefix(arn, principalARNGlobPrefix), accountID)
+	}
+
+	fields := strings.SplitN(arn, ":", 6)
+	if len(fields) < 5 {
+		// The ARN is cut short by a trailing wildcard before its account,
+		// such as in "arn:aws:iam:*"
+		return strings.HasSuffix(arn, "*")
+	}
+	if fields[4] == "*" {
+		return true
+	}
+	return strutil.GlobbedStringsMatch(fields[4], accountID)
+}
+
+// globMatchesAccount returns whether the account of a glob pattern of ARNs
+// matches the account ID
+func globMatchesAccount(glob, accountID string) bool {
+	fields := strings.SplitN(glob, ":", 6)
+	// A '*' before the account may span colons, so that any account can
+	// match it
+	if len(fields) < 5 || strings.Contains(strings.Join(fields[:4], ":"), "*") {
+		return strings.Contains(glob, "*")
+	}
+	pattern, err := compileBoundPrincipalARNPattern(principalARNGlobPrefix + fields[4])
+	if err != nil {
+		return false
+	}
+	return pattern.MatchString(accountID)
+}
+
+const pathToolsRolesForAccountHelpSyn = `
+Lists the roles bound to an AWS account.
+`
+
+const pathToolsRolesForAccountHelpDesc = `
+Returns the names of the roles which are bound to the given 'account_id',
+either through bound_account_id or bound_reservation_owner_id, or through
+an ARN constraint whose account matches, directly or by wildcard. Roles
+with a 'regex:' bound_iam_principal_arn are reported as possible matches,
+as the expression is not evaluated. For each role, 'matches' lists the
+constraints binding it to the account. This helps finding the roles to
+update when offboarding an account.
+`
@@ -0,0 +1,117 @@
+package awsauth
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestBackend_pathToolsRolesForAccount(t *testing.T) {
+	config := logical.TestBackendConfig()
+	storage := &logical.InmemStorage{}
+	config.StorageView = storage
+
+	b, err := Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Setup(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles := map[string]map[string]interface{}{
+		"ec2-account": {
+			"auth_type":        "ec2",
+			"bound_account_id": "123456789012,210987654321",
+		},
+		"ec2-other-account": {
+			"auth_type":        "ec2",
+			"bound_account_id": "111111111111",
+		},
+		"iam-direct": {
+			"auth_type":               "iam",
+			"bound_iam_principal_arn": "arn:aws:iam::123456789012:role/deployer",
+			"resolve_aws_unique_ids":  false,
+		},
+		"iam-wildcard": {
+			"auth_type":               "iam",
+			"bound_iam_principal_arn": "arn:aws:iam::1234567*",
+			"resolve_aws_unique_ids":  false,
+		},
+		"iam-other-account": {
+			"auth_type":               "iam",
+			"bound_iam_principal_arn": "arn:aws:iam::111111111111:role/*",
+			"resolve_aws_unique_ids":  false,
+		},
+		"iam-glob": {
+			"auth_type":               "iam",
+			"bound_iam_principal_arn": "glob:arn:aws:iam::12345678901?:role/*",
+			"resolve_aws_unique_ids":  false,
+		},
+		"iam-glob-other-account": {
+			"auth_type":               "iam",
+			"bound_iam_principal_arn": "glob:arn:aws:iam::11111111111?:role/*",
+			"resolve_aws_unique_ids":  false,
+		},
+		"iam-regex": {
+			"auth_type":               "iam",
+			"bound_iam_principal_arn": "regex:arn:aws:iam::111111111111:role/.*",
+			"resolve_aws_unique_ids":  false,
+		},
+	}
+	for roleName, data := range roles {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + roleName,
+			Data:      data,
+			Storage:   storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("failed to create role %q: resp:%#v err:%v", roleName, resp, err)
+		}
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "tools/roles-for-account",
+		Data: map[string]interface{}{
+			"account_id": "1234-5678-9012",
+		},
+		Storage: storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("failed to find roles: resp:%#v err:%v", resp, err)
+	}
+	expectedRoles := []string{"ec2-account", "iam-direct", "iam-glob", "iam-regex", "iam-wildcard"}
+	if !reflect.DeepEqual(resp.Data["roles"], expectedRoles) {
+		t.Fatalf("bad: expected roles %q, got %q", expectedRoles, resp.Data["roles"])
+	}
+	expectedMatches := map[string][]string{
+		"ec2-account":  {"bound_account_id"},
+		"iam-direct":   {"bound_iam_principal_arn"},
+		"iam-glob":     {"bound_iam_principal_arn"},
+		"iam-regex":    {"bound_iam_principal_arn"},
+		"iam-wildcard": {"bound_iam_principal_arn"},
+	}
+	if !reflect.DeepEqual(resp.Data["matches"], expectedMatches) {
+		t.Fatalf("bad: expected matches %v, got %v", expectedMatches, resp.Data["matches"])
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "tools/roles-for-account",
+		Data: map[string]interface{}{
+			"account_id": "not-an-account",
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatal("expected an invalid account ID to be rejected")
+	}
+}
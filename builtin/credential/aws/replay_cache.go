@@ -0,0 +1,131 @@
+package awsauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	// Storage prefix of the hashes of the signed requests of past iam logins
+	replayCacheStoragePrefix = "replay/iam/"
+
+	// A SigV4 signed request is accepted by AWS as long as its X-Amz-Date is
+	// within this much of the current time, so a replayed request has to be
+	// rejected for that long
+	maxSignedRequestAge = 15 * time.Minute
+
+	// Format of the X-Amz-Date header
+	amzDateFormat = "20060102T150405Z"
+)
+
+var authorizationSignatureRegex = regexp.MustCompile(`Signature=([0-9a-fA-F]+)`)
+
+// replayCacheEntry records the hash of a signed request presented on an iam
+// login, until the signature of the request expires. The request itself is
+// never stored.
+type replayCacheEntry struct {
+	ExpirationTime time.Time `json:"expiration_time"`
+}
+
+// signedRequestHash returns the hash identifying a signed GetCallerIdentity
+// request, derived from the signature in its Authorization header and its
+// X-Amz-Date, along with the time at which the signature expires
+func signedRequestHash(headers http.Header, now time.Time) (string, time.Time, error) {
+	authzHeaders := headerValues(headers, "Authorization")
+	if len(authzHeaders) == 0 {
+		return "", time.Time{}, fmt.Errorf("missing Authorization header")
+	}
+	matches := authorizationSignatureRegex.FindAllStringSubmatch(strings.Join(authzHeaders, ","), -1)
+	if len(matches) == 0 {
+		return "", time.Time{}, fmt.Errorf("no Signature component in Authorization header")
+	}
+	if len(matches) > 1 {
+		return "", time.Time{}, fmt.Errorf("found multiple Signature components")
+	}
+	amzDate := strings.Join(headerValues(headers, "X-Amz-Date"), ",")
+
+	// The signature stays valid for maxSignedRequestAge after the date it
+	// was signed at, which may be ahead of the clock of this node
+	expirationTime := now.Add(maxSignedRequestAge)
+	if signedAt, err := time.Parse(amzDateFormat, amzDate); err == nil && signedAt.Add(maxSignedRequestAge).After(expirationTime) {
+		expirationTime = signedAt.Add(maxSignedRequestAge)
+	}
+
+	hash := sha256.Sum256([]byte(strings.ToLower(matches[0][1]) + "\n" + amzDate))
+	return hex.EncodeToString(hash[:]), expirationTime, nil
+}
+
+// checkAndRecordSignedRequest returns an error if the signed request in the
+// given headers was already presented on a login, and records it otherwise
+func (b *backend) checkAndRecordSignedRequest(ctx context.Context, s logical.Storage, headers http.Header) error {
+	now := b.clock()
+	hash, expirationTime, err := signedRequestHash(headers, now)
+	if err != nil {
+		return err
+	}
+
+	b.replayCacheMutex.Lock()
+	defer b.replayCacheMutex.Unlock()
+
+	entry, err := s.Get(ctx, replayCacheStoragePrefix+hash)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		var result replayCacheEntry
+		if err := entry.DecodeJSON(&result); err != nil {
+			return err
+		}
+		if now.Before(result.ExpirationTime) {
+			return fmt.Errorf("the signed request was already used to login")
+		}
+	}
+
+	entry, err = logical.StorageEntryJSON(replayCacheStoragePrefix+hash, &replayCacheEntry{
+		ExpirationTime: expirationTime,
+	})
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// tidyReplayCache deletes the hashes of signed requests which have expired
+func (b *backend) tidyReplayCache(ctx context.Context, s logical.Storage) error {
+	b.replayCacheMutex.Lock()
+	defer b.replayCacheMutex.Unlock()
+
+	hashes, err := s.List(ctx, replayCacheStoragePrefix)
+	if err != nil {
+		return err
+	}
+	now := b.clock()
+	for _, hash := range hashes {
+		entry, err := s.Get(ctx, replayCacheStoragePrefix+hash)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+		var result replayCacheEntry
+		if err := entry.DecodeJSON(&result); err != nil {
+			return err
+		}
+		if now.Before(result.ExpirationTime) {
+			continue
+		}
+		if err := s.Delete(ctx, replayCacheStoragePrefix+hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
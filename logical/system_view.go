@@ -66,6 +66,14 @@ type SystemView interface {
 	PluginEnv(context.Context) (*PluginEnvironment, error)
 }
 
+// PolicyLookupSystemView is implemented by the system views which can look
+// up the policies of Vault. It is not part of SystemView, as the system views
+// of external plugins cannot, so backends should check for it.
+type PolicyLookupSystemView interface {
+	// PolicyExists returns whether an ACL policy of the given name exists
+	PolicyExists(ctx context.Context, name string) (bool, error)
+}
+
 type StaticSystemView struct {
 	DefaultLeaseTTLVal  time.Duration
 	MaxLeaseTTLVal      time.Duration
@@ -77,6 +85,7 @@ type StaticSystemView struct {
 	LocalMountVal       bool
 	ReplicationStateVal consts.ReplicationState
 	EntityVal           *Entity
+	PoliciesVal         []string
 	VaultVersion        string
 	PluginEnvironment   *PluginEnvironment
 }
@@ -125,6 +134,15 @@ func (d StaticSystemView) EntityInfo(entityID string) (*Entity, error) {
 	return d.EntityVal, nil
 }
 
+func (d StaticSystemView) PolicyExists(_ context.Context, name string) (bool, error) {
+	for _, policy := range d.PoliciesVal {
+		if policy == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (d StaticSystemView) PluginEnv(_ context.Context) (*PluginEnvironment, error) {
 	return d.PluginEnvironment, nil
 }
@@ -153,6 +153,22 @@ func (d dynamicSystemView) MlockEnabled() bool {
 	return d.core.enableMlock
 }
 
+// PolicyExists returns whether an ACL policy of the given name exists
+func (d dynamicSystemView) PolicyExists(ctx context.Context, name string) (bool, error) {
+	if d.core == nil {
+		return false, fmt.Errorf("system view core is nil")
+	}
+	if d.core.policyStore == nil {
+		return false, fmt.Errorf("system view policy store is nil")
+	}
+
+	policy, err := d.core.policyStore.GetPolicy(ctx, name, PolicyTypeACL)
+	if err != nil {
+		return false, err
+	}
+	return policy != nil, nil
+}
+
 func (d dynamicSystemView) EntityInfo(entityID string) (*logical.Entity, error) {
 	// Requests from token created from the token backend will not have entity information.
 	// Return missing entity instead of error when requesting from MemDB.